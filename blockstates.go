@@ -0,0 +1,63 @@
+package nbt
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// The number of blocks in a chunk section, 16 by 16 by 16.
+const sectionBlocks = 4096
+
+// Returns the palette index of each block of a chunk section from its
+// packed BlockStates (or block_states.data) TAG_Long_Array, in the order
+// Minecraft stores them: x first, then z, then y.
+//
+// Each index takes as many bits as the largest index needs, but at least
+// four, starting from the least significant bit of the first long. Since
+// 1.16 an index never spans two longs and the leftover high bits of each long
+// are unused; before that, spanning must be true, and the indices are packed
+// with no gaps. A section whose palette has a single entry may have no data
+// at all, in which case every index is 0.
+func UnpackBlockStates(data []int64, paletteLen int, spanning bool) ([]uint16, error) {
+	if paletteLen < 1 || paletteLen > sectionBlocks {
+		return nil, fmt.Errorf("nbt: Palette of %d entries is not valid for a chunk section", paletteLen)
+	}
+	indices := make([]uint16, sectionBlocks)
+	if paletteLen == 1 && len(data) == 0 {
+		return indices, nil
+	}
+
+	width := bits.Len(uint(paletteLen - 1))
+	if width < 4 {
+		width = 4
+	}
+	perLong := 64 / width
+	expected := (sectionBlocks + perLong - 1) / perLong
+	if spanning {
+		expected = (sectionBlocks*width + 63) / 64
+	}
+	if len(data) != expected {
+		return nil, fmt.Errorf("nbt: Block states for a palette of %d entries take %d longs, but there are %d", paletteLen, expected, len(data))
+	}
+
+	mask := uint64(1)<<width - 1
+	for i := range indices {
+		var index uint64
+		if spanning {
+			bit := i * width
+			index = uint64(data[bit/64]) >> (bit % 64)
+			if bit%64+width > 64 {
+				index |= uint64(data[bit/64+1]) << (64 - bit%64)
+			}
+		} else {
+			index = uint64(data[i/perLong]) >> (i % perLong * width)
+		}
+		index &= mask
+
+		if index >= uint64(paletteLen) {
+			return nil, fmt.Errorf("nbt: Block %d has palette index %d, but the palette has only %d entries", i, index, paletteLen)
+		}
+		indices[i] = uint16(index)
+	}
+	return indices, nil
+}
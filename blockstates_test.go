@@ -0,0 +1,77 @@
+package nbt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnpackBlockStates(t *testing.T) {
+	// Five bits per block for a palette of 17.
+	first := []uint16{1, 2, 2, 3, 4, 4, 5, 6, 6, 4, 8, 0, 7, 4, 3, 13, 15, 16, 9, 14, 10, 12, 0, 2}
+
+	packed := make([]int64, 342)
+	packed[0] = 0x0020863148418841
+	packed[1] = 0x01018a7260f68c87
+	indices, err := UnpackBlockStates(packed, 17, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indices[:len(first)], first) {
+		t.Errorf("Found %v, but expected %v.", indices[:len(first)], first)
+	}
+
+	// Before 1.16, the thirteenth index is split between the first two
+	// longs.
+	packed = make([]int64, 320)
+	packed[0] = 0x7020863148418841
+	packed[1] = 0x001018a7260f68c8
+	indices, err = UnpackBlockStates(packed, 17, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indices[:len(first)], first) {
+		t.Errorf("Spanning: Found %v, but expected %v.", indices[:len(first)], first)
+	}
+	for i, index := range indices[len(first):] {
+		if index != 0 {
+			t.Fatalf("Spanning: Index %d is %d, but expected 0.", len(first)+i, index)
+		}
+	}
+
+	// Small palettes still use four bits, and the sign bit is just a bit.
+	packed = make([]int64, 256)
+	for i := range packed {
+		packed[i] = -1
+	}
+	for _, spanning := range []bool{false, true} {
+		indices, err = UnpackBlockStates(packed, 16, spanning)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(indices) != 4096 || indices[0] != 15 || indices[4095] != 15 {
+			t.Errorf("Found %d indices from %d to %d, but expected 4096 of 15.", len(indices), indices[0], indices[4095])
+		}
+	}
+
+	indices, err = UnpackBlockStates(nil, 1, false)
+	if err != nil {
+		t.Error(err)
+	} else if len(indices) != 4096 || indices[4095] != 0 {
+		t.Errorf("Found %d indices for a single-entry palette.", len(indices))
+	}
+
+	if _, err := UnpackBlockStates(make([]int64, 320), 17, false); err == nil {
+		t.Error("No error for a spanning array read as non-spanning, but one was expected!")
+	}
+	if _, err := UnpackBlockStates(make([]int64, 256), 16, false); err != nil {
+		t.Error(err)
+	}
+	if _, err := UnpackBlockStates(make([]int64, 256), 0, false); err == nil {
+		t.Error("No error for an empty palette, but one was expected!")
+	}
+	packed = make([]int64, 342)
+	packed[0] = 31
+	if _, err := UnpackBlockStates(packed, 17, false); err == nil {
+		t.Error("No error for an index past the end of the palette, but one was expected!")
+	}
+}
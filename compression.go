@@ -0,0 +1,99 @@
+package nbt
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// How to read and write one kind of compressed stream.
+type codec struct {
+	newReader func(io.Reader) (io.Reader, error)
+	newWriter func(io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[Compression]codec)
+)
+
+// Makes a compression format available to Unmarshal, Marshal and the rest
+// under the given id, so that formats this package doesn't know about, such
+// as LZ4, can be plugged in. Registering an id again replaces the codec that
+// was there, built-in or not. Uncompressed and AutoDetect cannot be
+// registered, and AutoDetect only ever picks the built-in formats.
+//
+// Either function may be nil if the format is only ever read or only ever
+// written. The reader is never closed; writers are closed by Encoder.Close.
+func RegisterCompression(id Compression, newReader func(io.Reader) (io.Reader, error), newWriter func(io.Writer) (io.WriteCloser, error)) {
+	if id == Uncompressed || id == AutoDetect {
+		panic(fmt.Errorf("nbt: Compression type %d cannot be registered", id))
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[id] = codec{newReader, newWriter}
+}
+
+func init() {
+	RegisterCompression(GZip, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	}, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	})
+	RegisterCompression(ZLib, func(r io.Reader) (io.Reader, error) {
+		return zlib.NewReader(r)
+	}, func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriter(w), nil
+	})
+	RegisterCompression(Zstd, func(r io.Reader) (io.Reader, error) {
+		return zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	}, func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	})
+}
+
+// Returns a reader that decompresses in.
+func newDecompressor(compression Compression, in io.Reader) (io.Reader, error) {
+	if compression == Uncompressed {
+		return in, nil
+	}
+	codecsMu.RLock()
+	c, ok := codecs[compression]
+	codecsMu.RUnlock()
+	if !ok || c.newReader == nil {
+		return nil, fmt.Errorf("nbt: Unknown compression type: %d", compression)
+	}
+	return c.newReader(in)
+}
+
+// Points r, a reader returned by newDecompressor, at in, as gzip, zlib and
+// zstd readers can. It returns false if r cannot be reused, in which case
+// a new one is needed.
+func resetDecompressor(r, in io.Reader) (bool, error) {
+	switch r := r.(type) {
+	case zlib.Resetter:
+		return true, r.Reset(in, nil)
+	case interface{ Reset(io.Reader) error }:
+		return true, r.Reset(in)
+	}
+	return false, nil
+}
+
+// Returns a writer that compresses into out, or nil if compression is
+// Uncompressed.
+func newCompressor(compression Compression, out io.Writer) (io.WriteCloser, error) {
+	if compression == Uncompressed {
+		return nil, nil
+	}
+	codecsMu.RLock()
+	c, ok := codecs[compression]
+	codecsMu.RUnlock()
+	if !ok || c.newWriter == nil {
+		return nil, fmt.Errorf("nbt: Unknown compression type: %d", compression)
+	}
+	return c.newWriter(out)
+}
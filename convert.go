@@ -0,0 +1,73 @@
+package nbt
+
+import (
+	"bufio"
+	"io"
+)
+
+// Rewrites every root tag in in with its numbers and lengths in dstOrder
+// rather than srcOrder, such as to turn a Java Edition file into a Bedrock
+// Edition one. Tags are copied one at a time as they are read, in the order
+// they appear, so nothing is lost and the output is exactly what a program
+// writing that byte order would have written.
+//
+// The input may be compressed in any of the formats AutoDetect knows about,
+// and the output is not compressed. A Bedrock level.dat starts with an
+// eight-byte header that is not part of the NBT, and must be skipped first.
+// NetworkLittleEndian can be read but not written.
+func Convert(srcOrder, dstOrder ByteOrder, in io.Reader, out io.Writer) (err error) {
+	s := NewScanner(AutoDetect, in)
+	s.dec.SetByteOrder(srcOrder)
+
+	buffered := bufio.NewWriter(out)
+	enc := NewEncoder(Uncompressed, buffered)
+	enc.SetByteOrder(dstOrder)
+	if enc.err != nil {
+		return enc.err
+	}
+
+	if err := enc.state.convert(s); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func (e *encodeState) convert(s *Scanner) (err error) {
+	defer recoverError(&err)
+
+	// Whether each list or compound the Scanner is in is a list, whose
+	// elements have no header.
+	var inList []bool
+	for {
+		t, err := s.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if t.Kind == CompoundEnd {
+			e.w(TagEnd)
+		}
+		if t.Kind == CompoundEnd || t.Kind == ListEnd {
+			inList = inList[:len(inList)-1]
+			continue
+		}
+		if len(inList) == 0 || !inList[len(inList)-1] {
+			e.w(t.Tag)
+			e.writeValue(TagString, t.Name)
+		}
+
+		switch t.Kind {
+		case CompoundStart:
+			inList = append(inList, false)
+		case ListStart:
+			e.w(t.Elem)
+			e.w(uint32(t.Len))
+			inList = append(inList, true)
+		default:
+			e.writeNodePayload(Node{Tag: t.Tag, Value: t.Value})
+		}
+	}
+}
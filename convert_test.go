@@ -0,0 +1,100 @@
+package nbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	compressed, err := ioutil.ReadFile("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty list keeps its element type, and several root tags are all
+	// converted.
+	original = append(original, byte(TagList), 0, 1, 'l', byte(TagInt), 0, 0, 0, 0)
+
+	var le bytes.Buffer
+	if err := Convert(BigEndian, LittleEndian, bytes.NewReader(compressed), &le); err != nil {
+		t.Fatal(err)
+	}
+	var le2 bytes.Buffer
+	if err := Convert(BigEndian, LittleEndian, bytes.NewReader(original), &le2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(le2.Bytes(), le.Bytes()) || le2.Len() != le.Len()+9 {
+		t.Errorf("Converting gave %d bytes, but converting the decompressed file gave %d.", le.Len(), le2.Len())
+	}
+
+	// The little endian version holds the same tags.
+	expected, err := Parse(Uncompressed, bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecoder(Uncompressed, bytes.NewReader(le2.Bytes()))
+	dec.SetByteOrder(LittleEndian)
+	n, err := dec.DecodeNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Error("The little endian file holds different tags.")
+	}
+
+	var be bytes.Buffer
+	if err := Convert(LittleEndian, BigEndian, &le2, &be); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(be.Bytes(), original) {
+		t.Errorf("Converting back gave\n% x\nbut expected\n% x", be.Bytes(), original)
+	}
+
+	if err := Convert(BigEndian, NetworkLittleEndian, bytes.NewReader(original), ioutil.Discard); err == nil {
+		t.Error("No error writing NetworkLittleEndian, but one was expected!")
+	}
+	if err := Convert(BigEndian, LittleEndian, bytes.NewReader(original[:100]), ioutil.Discard); err == nil {
+		t.Error("No error for truncated input, but one was expected!")
+	}
+}
+
+func TestEncoderByteOrder(t *testing.T) {
+	type Level struct {
+		Name    string
+		Seed    int64
+		Spawn   []int32
+		Heights [4]int64
+		Weather float32
+	}
+	level := Level{"world", -0x0102030405060708, []int32{1, -2, 3}, [4]int64{4, 5, 6, 7}, 0.5}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(Uncompressed, &buf)
+	enc.SetByteOrder(LittleEndian)
+	if err := enc.Encode("", level); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte{byte(TagCompound), 0, 0, byte(TagString), 4, 0, 'N', 'a', 'm', 'e', 5, 0}) {
+		t.Errorf("Lengths are not little endian: % x", buf.Bytes()[:12])
+	}
+
+	dec := NewDecoder(Uncompressed, &buf)
+	dec.SetByteOrder(LittleEndian)
+	var result Level
+	if err := dec.Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, level) {
+		t.Errorf("Found %#v, but expected %#v.", result, level)
+	}
+}
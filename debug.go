@@ -1,11 +1,11 @@
 package nbt
 
 import (
-	"compress/gzip"
-	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"reflect"
+	"strings"
 )
 
 // Prints a human-readable representation of an NBT file to stdout.
@@ -14,33 +14,103 @@ func Debug(compression Compression, in io.Reader) {
 	return
 }
 
-type debugState struct {
-	in io.Reader
+// Returns the NBT root tag in in as text in the format of the examples in the
+// original NBT specification, with one tag per line and the contents of each
+// list and compound indented two spaces between braces:
+//
+//	TAG_Compound("hello world"): 1 entries
+//	{
+//	  TAG_String("name"): Bananrama
+//	}
+//
+// Tags appear in the order they are in the input. Arrays are summarized by
+// their length rather than printed in full.
+func Dump(compression Compression, in io.Reader) (string, error) {
+	s := NewScanner(compression, in)
+	t, err := s.Next()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := dumpToken(&b, s, t, 0, true); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
-func (d *debugState) init(compression Compression, in io.Reader) *debugState {
-	if in == nil {
-		panic(fmt.Errorf("nbt: Input stream is nil"))
+// Writes t to b, followed by the rest of it if it starts a list or compound.
+func dumpToken(b *strings.Builder, s *Scanner, t Token, indent int, named bool) error {
+	pad := strings.Repeat("  ", indent)
+	b.WriteString(pad)
+	b.WriteString(t.Tag.String())
+	if named {
+		fmt.Fprintf(b, "(\"%s\")", t.Name)
 	}
-
-	switch compression {
-	case Uncompressed:
-		d.in = in
-	case GZip:
-		r, err := gzip.NewReader(in)
-		if err != nil {
-			panic(err)
+	b.WriteString(": ")
+
+	switch t.Kind {
+	case ListStart:
+		fmt.Fprintf(b, "%d entries of type %s\n%s{\n", t.Len, t.Elem, pad)
+		for {
+			elem, err := s.Next()
+			if err != nil {
+				return err
+			}
+			if elem.Kind == ListEnd {
+				break
+			}
+			if err := dumpToken(b, s, elem, indent+1, false); err != nil {
+				return err
+			}
 		}
-		d.in = r
-	case ZLib:
-		r, err := zlib.NewReader(in)
-		if err != nil {
-			panic(err)
+		fmt.Fprintf(b, "%s}\n", pad)
+
+	case CompoundStart:
+		// The number of entries comes first, but is only known once they
+		// have all been read.
+		var entries strings.Builder
+		count := 0
+		for {
+			entry, err := s.Next()
+			if err != nil {
+				return err
+			}
+			if entry.Kind == CompoundEnd {
+				break
+			}
+			if err := dumpToken(&entries, s, entry, indent+1, true); err != nil {
+				return err
+			}
+			count++
 		}
-		d.in = r
+		fmt.Fprintf(b, "%d entries\n%s{\n%s%s}\n", count, pad, entries.String(), pad)
+
 	default:
-		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+		switch t.Tag {
+		case TagByteArray:
+			fmt.Fprintf(b, "[%d bytes]\n", reflect.ValueOf(t.Value).Len())
+		case TagIntArray:
+			fmt.Fprintf(b, "[%d ints]\n", reflect.ValueOf(t.Value).Len())
+		case TagLongArray:
+			fmt.Fprintf(b, "[%d longs]\n", reflect.ValueOf(t.Value).Len())
+		default:
+			fmt.Fprintf(b, "%v\n", t.Value)
+		}
 	}
+	return nil
+}
+
+type debugState struct {
+	in io.Reader
+}
+
+func (d *debugState) init(compression Compression, in io.Reader) *debugState {
+	// Share the decoder's handling of compression.
+	var state decodeState
+	if err := state.init(compression, in); err != nil {
+		panic(err)
+	}
+	d.in = state.in
 
 	return d
 }
@@ -51,7 +121,7 @@ func (d *debugState) printf(indent int, format string, args ...interface{}) {
 
 func (d *debugState) debug(indent int) bool {
 	name, tag := d.readTag()
-	if tag == tagEnd {
+	if tag == TagEnd {
 		d.printf(indent, "%s", tag)
 		return false
 	}
@@ -67,12 +137,22 @@ func (d *debugState) r(i interface{}) {
 	}
 }
 
+// Reads the length of an array or list, which must not be negative.
+func (d *debugState) readLength() uint32 {
+	var length int32
+	d.r(&length)
+	if length < 0 {
+		panic(fmt.Errorf("nbt: Negative length %d", length))
+	}
+	return uint32(length)
+}
+
 // Returns the name of the tag that was read.
 func (d *debugState) readTag() (string, Tag) {
 	var tag Tag
 	d.r(&tag)
 
-	if tag == tagEnd {
+	if tag == TagEnd {
 		return "", tag
 	}
 
@@ -86,7 +166,7 @@ func (d *debugState) readString() string {
 	d.r(&length)
 
 	value := make([]byte, length)
-	_, err := d.in.Read(value)
+	_, err := io.ReadFull(d.in, value)
 	if err != nil {
 		panic(err)
 	}
@@ -96,54 +176,55 @@ func (d *debugState) readString() string {
 
 func (d *debugState) debugValue(indent int, tag Tag) {
 	switch tag {
-	case tagByte:
+	case TagByte:
 		var value uint8
 		d.r(&value)
 		d.printf(indent, "0x%02x", value)
 
-	case tagShort:
+	case TagShort:
 		var value uint16
 		d.r(&value)
 		d.printf(indent, "0x%04x", value)
 
-	case tagInt:
+	case TagInt:
 		var value uint32
 		d.r(&value)
 		d.printf(indent, "0x%08x", value)
 
-	case tagLong:
+	case TagLong:
 		var value uint64
 		d.r(&value)
 		d.printf(indent, "0x%016x", value)
 
-	case tagFloat:
+	case TagFloat:
 		var value float32
 		d.r(&value)
 		d.printf(indent, "%#v", value)
 
-	case tagDouble:
+	case TagDouble:
 		var value float64
 		d.r(&value)
 		d.printf(indent, "%#v", value)
 
-	case tagByteArray:
-		var length uint32
-		d.r(&length)
+	case TagByteArray:
+		length := d.readLength()
 		value := make([]byte, length)
 		d.printf(indent, "Length: %d (0x%08x)", length, length)
-		d.in.Read(value)
+		_, err := io.ReadFull(d.in, value)
+		if err != nil {
+			panic(err)
+		}
 		d.printf(indent, "Value: %#v", value)
 
-	case tagString:
+	case TagString:
 		value := d.readString()
 		d.printf(indent, "Length: %d", len(value))
 		d.printf(indent, "Value: %s", value)
 
-	case tagList:
+	case TagList:
 		var inner Tag
 		d.r(&inner)
-		var length uint32
-		d.r(&length)
+		length := d.readLength()
 
 		d.printf(indent, "Element type: %s", inner)
 		d.printf(indent, "Length: %d", length)
@@ -155,29 +236,27 @@ func (d *debugState) debugValue(indent int, tag Tag) {
 
 		d.printf(indent, "}")
 
-	case tagCompound:
+	case TagCompound:
 		d.printf(indent, "Values: {")
 		for d.debug(indent + 1) {
 		}
 		d.printf(indent, "}")
 
-	case tagIntArray:
-		var length uint32
-		d.r(&length)
+	case TagIntArray:
+		length := d.readLength()
 		d.printf(indent, "Length: %d", length)
 		d.printf(indent, "Values: {")
 		for i := uint32(0); i < length; i++ {
-			d.debugValue(indent+1, tagInt)
+			d.debugValue(indent+1, TagInt)
 		}
 		d.printf(indent, "}")
 
-	case tagLongArray:
-		var length uint32
-		d.r(&length)
+	case TagLongArray:
+		length := d.readLength()
 		d.printf(indent, "Length: %d", length)
 		d.printf(indent, "Values: {")
 		for i := uint32(0); i < length; i++ {
-			d.debugValue(indent+1, tagLong)
+			d.debugValue(indent+1, TagLong)
 		}
 		d.printf(indent, "}")
 
@@ -0,0 +1,80 @@
+package nbt
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	type Item struct {
+		ID    string `nbt:"id"`
+		Count int8
+	}
+	data := mustMarshal(t, GZip, "Level", struct {
+		X       int32 `nbt:"x"`
+		Name    string
+		Items   []Item
+		Pos     []float64
+		Heights [37]int64
+		Empty   struct{}
+	}{
+		X:     5,
+		Name:  "Bananrama",
+		Items: []Item{{"minecraft:stone", 3}},
+		Pos:   []float64{0.5, -2},
+	})
+
+	dump, err := Dump(GZip, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `TAG_Compound("Level"): 6 entries
+{
+  TAG_Int("x"): 5
+  TAG_String("Name"): Bananrama
+  TAG_List("Items"): 1 entries of type TAG_Compound
+  {
+    TAG_Compound: 2 entries
+    {
+      TAG_String("id"): minecraft:stone
+      TAG_Byte("Count"): 3
+    }
+  }
+  TAG_List("Pos"): 2 entries of type TAG_Double
+  {
+    TAG_Double: 0.5
+    TAG_Double: -2
+  }
+  TAG_Long_Array("Heights"): [37 longs]
+  TAG_Compound("Empty"): 0 entries
+  {
+  }
+}
+`
+	if dump != expected {
+		t.Errorf("Dumped:\n%s\nbut expected:\n%s", dump, expected)
+	}
+
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dump, err = Dump(GZip, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"TAG_Compound(\"Level\"): 11 entries\n{\n",
+		"\n  TAG_Float(\"floatTest\"): 0.49823147\n",
+		"\n  TAG_List(\"listTest (compound)\"): 2 entries of type TAG_Compound\n",
+		"\n      TAG_String(\"name\"): Compound tag #0\n",
+		"): [1000 bytes]\n",
+	} {
+		if !strings.Contains(dump, line) {
+			t.Errorf("Dump of bigtest.nbt does not contain %q:\n%s", line, dump)
+		}
+	}
+}
@@ -1,387 +1,2077 @@
 package nbt
 
 import (
-	"compress/gzip"
-	"compress/zlib"
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"reflect"
+	"strings"
+	"sync"
 )
 
-func Unmarshal(compression Compression, in io.Reader, v interface{}) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if s, ok := r.(string); ok {
-				err = fmt.Errorf(s)
-			} else {
-				err = r.(error)
+// Reads an NBT root tag from in and stores it in the value pointed to by v.
+//
+// Compounds can be read into structs or into maps with string keys, such as
+// a map[string]int32 or a map[string]interface{}. When the destination is an
+// interface{}, such as the values of the latter or the elements of a
+// []interface{}, the Go type is chosen based on the tag:
+//
+//	TAG_Byte       int8
+//	TAG_Short      int16
+//	TAG_Int        int32
+//	TAG_Long       int64
+//	TAG_Float      float32
+//	TAG_Double     float64
+//	TAG_Byte_Array []byte
+//	TAG_String     string
+//	TAG_List       []interface{}
+//	TAG_Compound   map[string]interface{}
+//	TAG_Int_Array  []int32
+//	TAG_Long_Array []int64
+//
+// Compound fields that a struct has no field for are skipped, unless one of
+// its fields is tagged `nbt:",extra"`. That field, which must be a map with
+// string keys, collects all of them instead, and Marshal writes them back
+// out alongside the other fields. A struct can only have one extra field.
+//
+// The fields of an embedded struct are treated as fields of the struct that
+// embeds it, unless the embedded struct is given a name in its tag, following
+// the same rules as encoding/json when names collide. The same goes for an
+// embedded pointer to a struct, which is allocated when one of its fields is
+// first read, and left out by Marshal while it is nil.
+//
+// A field with a type option, as described by Marshal, must be given that
+// tag, and its value must fit in the field.
+//
+// A time.Time can be read from a TAG_Long giving milliseconds since the Unix
+// epoch, and a UUID from a TAG_Int_Array of four ints. A TAG_Compound read
+// into an OrderedCompound keeps the order of its tags. Values of types that
+// implement Validator are checked once they have been read.
+//
+// A TAG_String can also be read into a []byte or [N]byte, which gets its
+// bytes exactly as they were stored, without being decoded.
+func Unmarshal(compression Compression, in io.Reader, v interface{}) error {
+	return NewDecoder(compression, in).Decode(v)
+}
+
+// Like Unmarshal, but gives up once ctx is done. See Decoder.DecodeContext.
+func UnmarshalContext(ctx context.Context, compression Compression, in io.Reader, v interface{}) error {
+	return NewDecoder(compression, in).DecodeContext(ctx, v)
+}
+
+// Like Unmarshal, but reads from data. This is quicker than wrapping data in
+// an io.Reader, as fields are read straight out of the slice. Compressed
+// data is decompressed into memory first.
+func UnmarshalBytes(compression Compression, data []byte, v interface{}) error {
+	d := decodeState{maxDepth: DefaultMaxDepth}
+	if err := d.initBytes(compression, data); err != nil {
+		return err
+	}
+	return d.offsetError(d.unmarshal(v))
+}
+
+// Like Unmarshal, but reads from r starting at off, such as to decode a chunk
+// from the middle of a region file. Nothing is read before off, and offsets
+// in errors count from it.
+func UnmarshalAt(compression Compression, r io.ReaderAt, off int64, v interface{}) error {
+	return Unmarshal(compression, &offsetReader{r: r, off: off}, v)
+}
+
+// Reads an io.ReaderAt from a position that moves on as it is read.
+type offsetReader struct {
+	r   io.ReaderAt
+	off int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.r.ReadAt(p, r.off)
+	r.off += int64(n)
+	// ReadAt reports io.EOF with a short read, which is normal for Read.
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Returned, wrapped, by DecodeField and DecodePath when there is nothing at
+// the name or path asked for.
+var ErrFieldNotFound = errors.New("nbt: Field not found")
+
+// Reads the field with the given name from the root compound in in and stores
+// it in v, following the same rules as Unmarshal. The fields before it are
+// skipped over, and nothing after it is read, so this is much quicker than
+// decoding the whole root tag for something like a chunk's DataVersion.
+func DecodeField(compression Compression, in io.Reader, name string, v interface{}) error {
+	dec := NewDecoder(compression, in)
+	if dec.err != nil {
+		return dec.err
+	}
+	return dec.state.offsetError(dec.state.decodePath([]pathStep{{key: name, path: name}}, v))
+}
+
+// Returns the DataVersion of a chunk read from in, using DecodeField so that
+// nothing after it is decoded. The layout of a chunk depends on its version,
+// such as sections moving out of the Level compound in 1.18, so this tells
+// callers what to decode the rest into. Chunks saved before 1.9 have no
+// DataVersion, for which the error wraps ErrFieldNotFound.
+func ReadDataVersion(compression Compression, in io.Reader) (int32, error) {
+	var version int32
+	err := DecodeField(compression, in, "DataVersion", &version)
+	return version, err
+}
+
+// Reads a root tag from in and returns nil if it is well-formed NBT, without
+// decoding it into anything. Otherwise, the error is the one Unmarshal would
+// give for the same problem. Nesting is limited to DefaultMaxDepth, and
+// anything following the root tag is an error.
+func Valid(compression Compression, in io.Reader) error {
+	dec := NewDecoder(compression, in)
+	if dec.err != nil {
+		return dec.err
+	}
+	dec.state.disallowTrailingData = true
+	return dec.state.offsetError(dec.state.validate())
+}
+
+// Unmarshaler is implemented by types that know how to decode themselves.
+// UnmarshalNBT is given the type of the tag and a reader holding its payload,
+// which is everything that follows the tag's name, in the byte order of the
+// input (big endian unless the Decoder was told otherwise).
+type Unmarshaler interface {
+	UnmarshalNBT(tag Tag, r io.Reader) error
+}
+
+// Validator is implemented by types, usually enums, that only allow some of
+// the values of their underlying type. Once a value of such a type has been
+// decoded, Valid is called on it, and decoding fails if it returns false.
+type Validator interface {
+	Valid() bool
+}
+
+// A Decoder reads NBT values from an input stream. Unlike Unmarshal, a
+// Decoder can be used to read several root tags one after the other from the
+// same stream, and it offers options that Unmarshal does not.
+type Decoder struct {
+	state decodeState
+	err   error
+}
+
+// Returns a Decoder that reads from in, decompressing it if needed. The
+// decompressor is only set up once, so every call to Decode continues where
+// the previous one stopped.
+//
+// Unless in is an io.ByteReader, the Decoder buffers it and so may read past
+// the end of the last root tag it decodes.
+func NewDecoder(compression Compression, in io.Reader) *Decoder {
+	dec := new(Decoder)
+	dec.state.maxDepth = DefaultMaxDepth
+	dec.err = dec.state.init(compression, in)
+	return dec
+}
+
+// Makes the Decoder read from in as if it had just been created with
+// NewDecoder, but keeping its options. The buffer and, where the format
+// allows it, the decompressor are reused rather than allocated again, which
+// makes a pool of Decoders worthwhile for programs that decode many small
+// documents. Any error setting up the new input, such as a bad gzip header,
+// is returned by the next call to Decode.
+func (dec *Decoder) Reset(in io.Reader) {
+	dec.err = dec.state.reset(in)
+}
+
+// How deeply lists and compounds may be nested before a Decoder gives up,
+// unless told otherwise with SetMaxDepth.
+const DefaultMaxDepth = 512
+
+// Causes the Decoder to return an error when a compound contains a field
+// that the destination struct has no place for. By default such fields are
+// read and thrown away.
+func (dec *Decoder) DisallowUnknownFields(disallow bool) {
+	dec.state.disallowUnknownFields = disallow
+}
+
+// Causes the Decoder to return an error when a compound contains the same
+// name twice, which Minecraft never writes. By default the last one wins,
+// silently replacing what the first one was decoded into.
+func (dec *Decoder) DisallowDuplicateKeys(disallow bool) {
+	dec.state.disallowDuplicateKeys = disallow
+}
+
+// Causes the Decoder to fall back to matching compound fields to struct fields
+// regardless of case when there is no exact match, so that "pos" can fill a
+// field named "Pos". An exact match always wins, and if several fields match
+// ignoring case, the first one declared is used.
+func (dec *Decoder) CaseInsensitiveFields(insensitive bool) {
+	dec.state.caseInsensitiveFields = insensitive
+}
+
+// Causes the Decoder to return an error if anything follows the root tag it
+// decodes. Leftover input usually means the data was not what it seemed,
+// such as the wrong compression or byte order having been used. Only one
+// root tag can be decoded this way, as the next one counts as trailing data.
+// Anything after the end of a compressed stream, such as sector padding,
+// is not part of the decompressed input and so is not counted.
+func (dec *Decoder) DisallowTrailingData(disallow bool) {
+	dec.state.disallowTrailingData = disallow
+}
+
+// Allows TAG_Byte, TAG_Short and TAG_Int to be read into Go integer types
+// wider than themselves, such as a TAG_Byte into an int32 or a TAG_Short into
+// a uint64, which are otherwise refused. Signed types get the value itself,
+// and unsigned types get it read as unsigned first, so that a TAG_Byte of -1
+// becomes 255 in a uint32 just as it would in a uint8. Nothing is ever
+// narrowed, so a TAG_Long still cannot go in an int32, and int and uint
+// remain unsupported.
+func (dec *Decoder) WidenIntegers(widen bool) {
+	dec.state.widenIntegers = widen
+}
+
+// Causes numbers decoded into an interface{}, including those in untyped maps
+// and lists, to be stored as Byte, Short, Int, Long, Float and Double rather
+// than int8, int16, int32, int64, float32 and float64, so that the tag each
+// came from can be told apart after the fact. Nodes are not affected.
+func (dec *Decoder) TypedScalars(typed bool) {
+	dec.state.typedScalars = typed
+}
+
+// Allows a TAG_Float to be read into a float64. Every float32 is exactly a
+// float64 too, so nothing is lost.
+func (dec *Decoder) WidenFloats(widen bool) {
+	dec.state.widenFloats = widen
+}
+
+// Allows a TAG_Double to be read into a float32, rounding it to the nearest
+// float32. This is lossy, and so separate from WidenFloats. A double too big
+// to be a float32 at all is an error rather than becoming infinity, while NaN
+// and the infinities stay as they are.
+func (dec *Decoder) NarrowFloats(narrow bool) {
+	dec.state.narrowFloats = narrow
+}
+
+// Makes reading a TAG_Byte into a bool an error unless it is 0 or 1, to
+// catch corrupt files. Otherwise, any value but 0 is true.
+func (dec *Decoder) StrictBools(strict bool) {
+	dec.state.strictBools = strict
+}
+
+// Sets the byte order numbers and lengths are read in. The default is
+// BigEndian.
+func (dec *Decoder) SetByteOrder(order ByteOrder) {
+	dec.state.order = order
+}
+
+// Causes the Decoder to expect root tags without a name, as sent over the
+// network since Java Edition 1.20.2. Tags inside the root still have names.
+func (dec *Decoder) NetworkRoot(nameless bool) {
+	dec.state.networkRoot = nameless
+}
+
+// Sets how deeply lists and compounds may be nested inside each other. This
+// stops maliciously crafted files from exhausting the stack.
+func (dec *Decoder) SetMaxDepth(depth int) {
+	dec.state.maxDepth = depth
+}
+
+// Sets the largest number of elements a list or array may claim to have.
+// Zero, the default, means there is no limit.
+func (dec *Decoder) SetMaxElements(n int) {
+	dec.state.maxElements = n
+}
+
+// Reads the next NBT root tag and stores it in the value pointed to by v.
+// See Unmarshal for how tags are matched up with Go types. Errors start with
+// the number of bytes of decompressed input read before the problem was
+// found.
+//
+// If the input ends cleanly before the next root tag, the error is io.EOF
+// itself. If it ends partway through one, the error wraps
+// io.ErrUnexpectedEOF.
+func (dec *Decoder) Decode(v interface{}) error {
+	if dec.err != nil {
+		return dec.err
+	}
+	return dec.state.offsetError(dec.state.unmarshal(v))
+}
+
+// Reports whether there is more input after the root tags read so far, so
+// that a stream of them can be read with
+//
+//	for dec.More() {
+//		if err := dec.Decode(&v); err != nil {
+//			return err
+//		}
+//	}
+//
+// The members of a gzip stream are read one after the other, so any number
+// of gzipped documents joined together work too. More only returns false at
+// the end of the input: if the input can't be read, it returns true and
+// Decode returns the error.
+func (dec *Decoder) More() bool {
+	// Compressed input that is empty fails as the decoder is made.
+	if dec.err == io.EOF {
+		return false
+	}
+	if dec.err != nil {
+		return true
+	}
+	if _, err := dec.state.scanner.ReadByte(); err != nil {
+		if err == io.EOF {
+			return false
+		}
+		dec.err = dec.state.offsetError(err)
+		return true
+	}
+	dec.state.scanner.UnreadByte()
+	return true
+}
+
+// Like Decode, but also returns the name of the root tag. With NetworkRoot,
+// the name is always empty.
+func (dec *Decoder) DecodeNamed(v interface{}) (string, error) {
+	if dec.err != nil {
+		return "", dec.err
+	}
+	name, err := dec.state.unmarshalNamed(v)
+	return name, dec.state.offsetError(err)
+}
+
+// Like Decode, but gives up once ctx is done, returning an error that wraps
+// ctx.Err(). The context is checked every few thousand compound fields and
+// list elements rather than on every read.
+func (dec *Decoder) DecodeContext(ctx context.Context, v interface{}) error {
+	if dec.err != nil {
+		return dec.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dec.state.ctx = ctx
+	defer func() { dec.state.ctx = nil }()
+	return dec.state.offsetError(dec.state.unmarshal(v))
+}
+
+type decodeState struct {
+	in    io.Reader
+	count *countingReader
+	order ByteOrder
+
+	// The buffered input under count, which More peeks at.
+	scanner io.ByteScanner
+
+	// Kept so that Reset can reuse them. compression is what the Decoder
+	// was created with, which may be AutoDetect, and decompressor is the
+	// reader for the format of the last input, if it was compressed.
+	compression  Compression
+	decompressed Compression
+	decompressor io.Reader
+	buffer       *bufio.Reader
+
+	// When decoding from memory, in is nil and the input is data[pos:].
+	// tail is the error that stopped decompressing data, if any, which is
+	// given when reading past its end.
+	fromBytes bool
+	data      []byte
+	pos       int
+	tail      error
+
+	buf [8]byte
+
+	// Reused for the bytes of strings too long for buf.
+	stringBuf []byte
+
+	disallowUnknownFields bool
+	disallowDuplicateKeys bool
+	disallowTrailingData  bool
+	caseInsensitiveFields bool
+	widenIntegers         bool
+	widenFloats           bool
+	narrowFloats          bool
+	strictBools           bool
+	typedScalars          bool
+	networkRoot           bool
+
+	// Set while reading an OrderedCompound, so that the compounds inside it
+	// are read as OrderedCompounds too.
+	ordered bool
+
+	// The offset the root tag being read starts at. Only there is the end
+	// of the input not an error.
+	root int64
+
+	depth       int
+	maxDepth    int
+	maxElements int
+
+	// The union option of the struct field being read, if any.
+	union string
+
+	// Where data starts in the input, for a decodeState made by sub.
+	base int64
+
+	// Set by DecodeContext. ticks counts towards the next check of ctx.
+	ctx   context.Context
+	ticks int
+}
+
+func (d *decodeState) init(compression Compression, in io.Reader) error {
+	d.compression = compression
+	return d.reset(in)
+}
+
+// Starts reading from in, reusing the decompressor and buffer from the
+// previous input where possible.
+func (d *decodeState) reset(in io.Reader) error {
+	d.in, d.root, d.depth, d.ticks = nil, 0, 0, 0
+	if in == nil {
+		return fmt.Errorf("nbt: Input stream is nil")
+	}
+
+	compression := d.compression
+	if compression == AutoDetect {
+		var err error
+		compression, in, err = detectCompression(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := d.decompress(compression, in)
+	if err != nil {
+		return err
+	}
+
+	// Every field is its own small read, which is slow without a buffer.
+	// The buffer also lets More look at the next byte without reading it.
+	scanner, ok := r.(io.ByteScanner)
+	if !ok {
+		if d.buffer == nil {
+			d.buffer = bufio.NewReader(r)
+		} else {
+			d.buffer.Reset(r)
+		}
+		r, scanner = d.buffer, d.buffer
+	}
+	d.scanner = scanner
+
+	if d.count == nil {
+		d.count = new(countingReader)
+	}
+	*d.count = countingReader{r: r}
+	d.in = d.count
+
+	return nil
+}
+
+func (d *decodeState) initBytes(compression Compression, data []byte) error {
+	if compression == AutoDetect {
+		compression, _, _ = detectCompression(bytes.NewReader(data))
+	}
+
+	if compression != Uncompressed {
+		if err := d.init(compression, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		// Whatever follows the compressed stream, such as the padding at
+		// the end of a region file's sector, may well not decompress. That
+		// only matters if the document turns out to need more bytes.
+		var err error
+		data, err = ioutil.ReadAll(d.in)
+		d.tail = err
+		d.in = nil
+	}
+
+	d.fromBytes = true
+	d.data = data
+	return nil
+}
+
+// Returns a reader that decompresses in, which is the last one returned if
+// it was for the same format and can be reset.
+func (d *decodeState) decompress(compression Compression, in io.Reader) (io.Reader, error) {
+	if compression == Uncompressed {
+		return in, nil
+	}
+	if compression == d.decompressed {
+		if ok, err := resetDecompressor(d.decompressor, in); ok {
+			return d.decompressor, err
+		}
+	}
+	r, err := newDecompressor(compression, in)
+	if err != nil {
+		return nil, err
+	}
+	d.decompressor, d.decompressed = r, compression
+	return r, nil
+}
+
+// Counts the bytes read through it, so that errors can say where in the
+// decompressed input they happened.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Adds the number of bytes read so far to err. A clean io.EOF is left alone
+// so that callers can still compare against it.
+func (d *decodeState) offsetError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	// Nothing has been read yet, so where would not help.
+	if _, ok := err.(*InvalidUnmarshalError); ok {
+		return err
+	}
+	// Errors inside a union already know where they happened, which is
+	// before the end of the compound d has read past.
+	var inner *offsetError
+	if errors.As(err, &inner) {
+		return err
+	}
+	return &offsetError{offset: d.offset(), err: err}
+}
+
+// Returns the number of bytes of decompressed input read so far.
+func (d *decodeState) offset() int64 {
+	if d.fromBytes {
+		return d.base + int64(d.pos)
+	}
+	return d.count.n
+}
+
+type offsetError struct {
+	offset int64
+	err    error
+}
+
+func (e *offsetError) Error() string {
+	return fmt.Sprintf("nbt: at offset %d: %s", e.offset, strings.TrimPrefix(e.err.Error(), "nbt: "))
+}
+
+func (e *offsetError) Unwrap() error {
+	return e.err
+}
+
+// Peeks at the start of in to guess how it was compressed. The returned
+// reader must be used in place of in, as it still holds the peeked bytes.
+func detectCompression(in io.Reader) (Compression, io.Reader, error) {
+	r := bufio.NewReader(in)
+
+	magic, err := r.Peek(2)
+	if err != nil && err != io.EOF {
+		return Uncompressed, r, err
+	}
+
+	switch {
+	case len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return GZip, r, nil
+	case len(magic) == 2 && magic[0] == 0x78 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0:
+		return ZLib, r, nil
+	case len(magic) == 2 && magic[0] == 0x28 && magic[1] == 0xb5:
+		return Zstd, r, nil
+	}
+
+	// Too short to be compressed, or starts with something else (usually
+	// TAG_Compound), so let the decoder deal with it as it is.
+	return Uncompressed, r, nil
+}
+
+func (d *decodeState) unmarshal(v interface{}) error {
+	_, err := d.unmarshalNamed(v)
+	return err
+}
+
+// Returned when Unmarshal or one of its relatives is given something other
+// than a non-nil pointer to decode into. Type is nil if v itself was nil.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "nbt: Unmarshal requires a non-nil pointer, got nil"
+	}
+	if e.Type.Kind() == reflect.Ptr {
+		return fmt.Sprintf("nbt: Unmarshal requires a non-nil pointer, got nil %v", e.Type)
+	}
+	return fmt.Sprintf("nbt: Unmarshal requires a non-nil pointer, got %v", e.Type)
+}
+
+// Returned when the input holds a tag id that is not one of the tags in this
+// package, which usually means the file comes from a newer version of the
+// format. Offset is where the id is in the decompressed input.
+type UnknownTagError struct {
+	Tag    Tag
+	Offset int64
+}
+
+func (e *UnknownTagError) Error() string {
+	return fmt.Sprintf("nbt: Unhandled tag: %s", e.Tag)
+}
+
+// Returned when a number is read into a Go type that is too small for it,
+// which only happens when a field's type is overridden or with
+// Decoder.NarrowFloats. Value is the number as it was read.
+type RangeError struct {
+	Value interface{}
+	Type  reflect.Type
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("nbt: value %v out of range for %s", e.Value, e.Type)
+}
+
+// Stores x, an int64, uint64 or float64, in v, which must be a number of the
+// same kind but may be narrower, or returns a *RangeError if it doesn't fit.
+func setNumber(v reflect.Value, x interface{}) error {
+	var overflow bool
+	switch x := x.(type) {
+	case int64:
+		if overflow = v.OverflowInt(x); !overflow {
+			v.SetInt(x)
+		}
+	case uint64:
+		if overflow = v.OverflowUint(x); !overflow {
+			v.SetUint(x)
+		}
+	case float64:
+		if overflow = v.OverflowFloat(x); !overflow {
+			v.SetFloat(x)
+		}
+	}
+	if overflow {
+		return &RangeError{Value: x, Type: v.Type()}
+	}
+	return nil
+}
+
+// Returns an *InvalidUnmarshalError unless v is a non-nil pointer.
+func checkUnmarshalTarget(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	return nil
+}
+
+// Like unmarshal, but also returns the name of the root tag.
+func (d *decodeState) unmarshalNamed(v interface{}) (string, error) {
+	if err := checkUnmarshalTarget(v); err != nil {
+		return "", err
+	}
+	d.root = d.offset()
+	var name string
+	var tag Tag
+	var err error
+	if d.networkRoot {
+		tag, err = d.readTagID()
+	} else {
+		name, tag, err = d.readTag()
+	}
+	if err != nil {
+		return name, err
+	}
+	if err := d.readValue(tag, reflect.ValueOf(v).Elem()); err != nil {
+		return name, err
+	}
+	return name, d.end()
+}
+
+func (d *decodeState) validate() error {
+	d.root = d.offset()
+	_, tag, err := d.readTag()
+	if err != nil {
+		return err
+	}
+	if err := d.skipValue(tag); err != nil {
+		return err
+	}
+	return d.end()
+}
+
+// Called after reading a root tag. Unless trailing data is disallowed, there
+// is nothing to check.
+func (d *decodeState) end() error {
+	if !d.disallowTrailingData {
+		return nil
+	}
+	// An error here is the end of the input, or of the compressed stream,
+	// which is what was hoped for. Sector padding after a compressed chunk
+	// fails to decompress, for one, but is not part of the document.
+	d.root = d.offset()
+	if _, err := d.next(1); err == nil {
+		return fmt.Errorf("nbt: Trailing data after the root tag")
+	}
+	return nil
+}
+
+// Returns the next n bytes of input, which are only valid until the next
+// read. Running out of input gives io.EOF if nothing at all was left before
+// the start of a root tag, and io.ErrUnexpectedEOF anywhere else.
+func (d *decodeState) next(n int) ([]byte, error) {
+	if d.fromBytes {
+		if left := len(d.data) - d.pos; left < n {
+			d.pos = len(d.data)
+			if d.tail != nil {
+				return nil, d.tail
+			}
+			if left == 0 && d.pos == int(d.root) {
+				return nil, io.EOF
 			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := d.data[d.pos : d.pos+n]
+		d.pos += n
+		return b, nil
+	}
+
+	var b []byte
+	if n <= len(d.buf) {
+		b = d.buf[:n]
+	} else {
+		b = make([]byte, n)
+	}
+	if _, err := io.ReadFull(d.in, b); err != nil {
+		if err == io.EOF && d.count.n != d.root {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *decodeState) byteOrder() binary.ByteOrder {
+	if d.order != BigEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// Lets binary.ReadUvarint and binary.ReadVarint read from the input.
+func (d *decodeState) ReadByte() (byte, error) {
+	return d.readUint8()
+}
+
+func (d *decodeState) readUint8() (uint8, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *decodeState) readUint16() (uint16, error) {
+	b, err := d.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return d.byteOrder().Uint16(b), nil
+}
+
+func (d *decodeState) readUint32() (uint32, error) {
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.byteOrder().Uint32(b), nil
+}
+
+func (d *decodeState) readUint64() (uint64, error) {
+	b, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return d.byteOrder().Uint64(b), nil
+}
+
+// Reads a tag id, returning an *UnknownTagError for ids past TagLongArray.
+func (d *decodeState) readTagID() (Tag, error) {
+	b, err := d.readUint8()
+	if err == nil && Tag(b) > TagLongArray {
+		return Tag(b), &UnknownTagError{Tag: Tag(b), Offset: d.offset() - 1}
+	}
+	return Tag(b), err
+}
+
+// Returns the name of the tag that was read.
+func (d *decodeState) readTag() (string, Tag, error) {
+	if err := d.tick(1); err != nil {
+		return "", TagEnd, err
+	}
+	tag, err := d.readTagID()
+	if err != nil {
+		return "", tag, err
+	}
+
+	if tag == TagEnd {
+		return "", tag, nil
+	}
+
+	name, err := d.readString()
+
+	return name, tag, err
+}
+
+// Returns a new value of the type tag is stored as in an interface{}.
+func (d *decodeState) allocateInterface(tag Tag) (reflect.Value, error) {
+	if d.typedScalars {
+		switch tag {
+		case TagByte:
+			return reflect.ValueOf(new(Byte)).Elem(), nil
+		case TagShort:
+			return reflect.ValueOf(new(Short)).Elem(), nil
+		case TagInt:
+			return reflect.ValueOf(new(Int)).Elem(), nil
+		case TagLong:
+			return reflect.ValueOf(new(Long)).Elem(), nil
+		case TagFloat:
+			return reflect.ValueOf(new(Float)).Elem(), nil
+		case TagDouble:
+			return reflect.ValueOf(new(Double)).Elem(), nil
+		}
+	}
+	return d.allocate(tag)
+}
+
+func (d *decodeState) allocate(tag Tag) (reflect.Value, error) {
+	switch tag {
+	case TagByte:
+		return reflect.ValueOf(new(int8)).Elem(), nil
+	case TagShort:
+		return reflect.ValueOf(new(int16)).Elem(), nil
+	case TagInt:
+		return reflect.ValueOf(new(int32)).Elem(), nil
+	case TagLong:
+		return reflect.ValueOf(new(int64)).Elem(), nil
+	case TagFloat:
+		return reflect.ValueOf(new(float32)).Elem(), nil
+	case TagDouble:
+		return reflect.ValueOf(new(float64)).Elem(), nil
+	case TagByteArray:
+		return reflect.ValueOf(new([]byte)).Elem(), nil
+	case TagString:
+		return reflect.ValueOf(new(string)).Elem(), nil
+	case TagList:
+		return reflect.ValueOf(new([]interface{})).Elem(), nil
+	case TagCompound:
+		if d.ordered {
+			return reflect.ValueOf(new(OrderedCompound)).Elem(), nil
+		}
+		return reflect.ValueOf(new(map[string]interface{})).Elem(), nil
+	case TagIntArray:
+		return reflect.ValueOf(new([]int32)).Elem(), nil
+	case TagLongArray:
+		return reflect.ValueOf(new([]int64)).Elem(), nil
+	}
+	return reflect.Value{}, fmt.Errorf("nbt: Unhandled tag %s", tag)
+}
+
+// Reads the length of a TAG_Byte_Array, TAG_List, TAG_Int_Array, or
+// TAG_Long_Array.
+func (d *decodeState) readLength(tag Tag) (uint32, error) {
+	length, err := d.readInt()
+	if err == nil && int32(length) < 0 {
+		// Lengths are signed in Minecraft, so this is a corrupt file rather
+		// than a very long array.
+		err = fmt.Errorf("nbt: %s has negative length %d", tag, int32(length))
+	}
+	if err == nil && d.maxElements > 0 && int64(length) > int64(d.maxElements) {
+		err = fmt.Errorf("nbt: %s of length %d is longer than the limit of %d", tag, length, d.maxElements)
+	}
+	return length, err
+}
+
+// Reads the element tag and length of a TAG_List. Only an empty list may
+// have TAG_End as its element type, which is how Minecraft writes them.
+func (d *decodeState) readListHeader() (Tag, uint32, error) {
+	inner, err := d.readTagID()
+	if err != nil {
+		return inner, 0, err
+	}
+	length, err := d.readLength(TagList)
+	if err == nil && inner == TagEnd && length != 0 {
+		err = fmt.Errorf("nbt: TAG_List of TAG_End has %d elements", length)
+	}
+	return inner, length, err
+}
+
+// Slices are only allocated up to this capacity before any elements have
+// been read. Anything longer grows as the elements arrive, so that a bogus
+// length runs into the end of the input instead of exhausting memory.
+const maxPreallocate = 1024
+
+func initialCapacity(length uint32) int {
+	if length > maxPreallocate {
+		return maxPreallocate
+	}
+	return int(length)
+}
+
+// Reads the payload of a TAG_Byte_Array, TAG_Int_Array or TAG_Long_Array
+// holding length elements of type elem into an array or slice.
+func (d *decodeState) readArray(tag, elem Tag, name string, length uint32, v reflect.Value) error {
+	if elem == TagByte && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() == reflect.Uint8 {
+		return d.readByteArray(name, length, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Array:
+		if uint32(v.Len()) < length {
+			return fmt.Errorf("nbt: %s array is of length %d, but only the array given is only %d long!", name, length, v.Len())
+		}
+
+		for i := 0; i < int(length); i++ {
+			if err := d.readValue(elem, v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		if ok, err := d.readScalars(elem, length, v); ok {
+			return err
+		}
+
+		if uint32(v.Cap()) < length {
+			v.Set(reflect.MakeSlice(v.Type(), 0, initialCapacity(length)))
+		} else {
+			v.Set(v.Slice(0, 0))
+		}
+		zero := reflect.Zero(v.Type().Elem())
+
+		for i := 0; i < int(length); i++ {
+			v.Set(reflect.Append(v, zero))
+			if err := d.readValue(elem, v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
+	}
+
+	return nil
+}
+
+// Reads length elements of type elem in one go if v is a plain slice of a
+// matching fixed-width type, such as a []float64 for a TAG_List of
+// TAG_Double, reusing the slice if it is big enough. It returns false without
+// reading anything otherwise.
+func (d *decodeState) readScalars(elem Tag, length uint32, v reflect.Value) (bool, error) {
+	if !v.CanAddr() || d.order == NetworkLittleEndian && (elem == TagInt || elem == TagLong) {
+		return false, nil
+	}
+	order := d.byteOrder()
+	n := initialCapacity(length)
+
+	switch p := v.Addr().Interface().(type) {
+	case *[]bool:
+		// Strict bools are read one at a time, so that the error for one
+		// that isn't 0 or 1 says where it is.
+		if elem != TagByte || d.strictBools {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]bool, 0, n)
+		}
+		err := d.readChunks(length, 1, func(b []byte) {
+			for _, c := range b {
+				s = append(s, c != 0)
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]int8:
+		if elem != TagByte {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]int8, 0, n)
+		}
+		err := d.readChunks(length, 1, func(b []byte) {
+			for _, c := range b {
+				s = append(s, int8(c))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]int16:
+		if elem != TagShort {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]int16, 0, n)
+		}
+		err := d.readChunks(length, 2, func(b []byte) {
+			for ; len(b) != 0; b = b[2:] {
+				s = append(s, int16(order.Uint16(b)))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]uint16:
+		if elem != TagShort {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]uint16, 0, n)
+		}
+		err := d.readChunks(length, 2, func(b []byte) {
+			for ; len(b) != 0; b = b[2:] {
+				s = append(s, order.Uint16(b))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]int32:
+		if elem != TagInt {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]int32, 0, n)
+		}
+		err := d.readChunks(length, 4, func(b []byte) {
+			for ; len(b) != 0; b = b[4:] {
+				s = append(s, int32(order.Uint32(b)))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]uint32:
+		if elem != TagInt {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]uint32, 0, n)
+		}
+		err := d.readChunks(length, 4, func(b []byte) {
+			for ; len(b) != 0; b = b[4:] {
+				s = append(s, order.Uint32(b))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]int64:
+		if elem != TagLong {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]int64, 0, n)
+		}
+		err := d.readChunks(length, 8, func(b []byte) {
+			for ; len(b) != 0; b = b[8:] {
+				s = append(s, int64(order.Uint64(b)))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]uint64:
+		if elem != TagLong {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]uint64, 0, n)
+		}
+		err := d.readChunks(length, 8, func(b []byte) {
+			for ; len(b) != 0; b = b[8:] {
+				s = append(s, order.Uint64(b))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]float32:
+		if elem != TagFloat {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]float32, 0, n)
+		}
+		err := d.readChunks(length, 4, func(b []byte) {
+			for ; len(b) != 0; b = b[4:] {
+				s = append(s, math.Float32frombits(order.Uint32(b)))
+			}
+		})
+		*p = s
+		return true, err
+
+	case *[]float64:
+		if elem != TagDouble {
+			return false, nil
+		}
+		s := (*p)[:0]
+		if s == nil || uint32(cap(s)) < length {
+			s = make([]float64, 0, n)
+		}
+		err := d.readChunks(length, 8, func(b []byte) {
+			for ; len(b) != 0; b = b[8:] {
+				s = append(s, math.Float64frombits(order.Uint64(b)))
+			}
+		})
+		*p = s
+		return true, err
+	}
+
+	return false, nil
+}
+
+// Reads count elements of size bytes each, handing them to f a chunk of
+// whole elements at a time.
+func (d *decodeState) readChunks(count uint32, size int, f func([]byte)) error {
+	var buf []byte
+	for count > 0 {
+		n := count
+		if n > maxPreallocate {
+			n = maxPreallocate
+		}
+		count -= n
+		if err := d.tick(int(n)); err != nil {
+			return err
+		}
+
+		var b []byte
+		var err error
+		if d.fromBytes {
+			b, err = d.next(int(n) * size)
+		} else {
+			if buf == nil {
+				buf = make([]byte, int(n)*size)
+			}
+			b = buf[:int(n)*size]
+			_, err = io.ReadFull(d.in, b)
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		f(b)
+	}
+	return nil
+}
+
+// Reads the payload of a TAG_Byte_Array into a []byte or [N]byte in one go,
+// rather than a byte at a time.
+func (d *decodeState) readByteArray(name string, length uint32, v reflect.Value) error {
+	if v.Kind() == reflect.Array {
+		if uint32(v.Len()) < length {
+			return fmt.Errorf("nbt: %s array is of length %d, but only the array given is only %d long!", name, length, v.Len())
+		}
+		b, err := d.readBytes(length, nil)
+		reflect.Copy(v, reflect.ValueOf(b))
+		return err
+	}
+
+	b, err := d.readBytes(length, v.Bytes())
+	v.SetBytes(b)
+	return err
+}
+
+// Reads length bytes, reusing dst if it is big enough. A bogus length runs
+// out of input before it can exhaust memory, as with initialCapacity.
+func (d *decodeState) readBytes(length uint32, dst []byte) ([]byte, error) {
+	if d.fromBytes {
+		b, err := d.next(int(length))
+		if err == io.EOF && length != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return append(dst[:0], b...), err
+	}
+
+	if uint32(cap(dst)) >= length {
+		dst = dst[:length]
+		_, err := io.ReadFull(d.in, dst)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return dst, err
+	}
+
+	dst = make([]byte, 0, initialCapacity(length))
+	var chunk [maxPreallocate]byte
+	for uint32(len(dst)) < length {
+		n := length - uint32(len(dst))
+		if n > maxPreallocate {
+			n = maxPreallocate
+		}
+		if _, err := io.ReadFull(d.in, chunk[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return dst, err
+		}
+		dst = append(dst, chunk[:n]...)
+	}
+	return dst, nil
+}
+
+// Reads the length in bytes of a TAG_String or tag name.
+func (d *decodeState) readStringLength() (uint32, error) {
+	if d.order == NetworkLittleEndian {
+		// The length is a VarInt, but no more fits than in the other byte
+		// orders.
+		value, err := binary.ReadUvarint(d)
+		if err == nil && value > math.MaxUint16 {
+			err = fmt.Errorf("nbt: String of length %d is longer than the %d bytes a TAG_String can hold", value, math.MaxUint16)
 		}
-	}()
-	new(decodeState).init(compression, in).unmarshal(v)
-	return
+		return uint32(value), err
+	}
+
+	length, err := d.readUint16()
+	return uint32(length), err
 }
 
-type decodeState struct {
-	in io.Reader
+// Reads the payload of a TAG_Int.
+func (d *decodeState) readInt() (uint32, error) {
+	if d.order == NetworkLittleEndian {
+		value, err := binary.ReadVarint(d)
+		if err == nil && (value < math.MinInt32 || value > math.MaxInt32) {
+			err = fmt.Errorf("nbt: VarInt %d does not fit in 32 bits", value)
+		}
+		return uint32(value), err
+	}
+
+	return d.readUint32()
 }
 
-func (d *decodeState) init(compression Compression, in io.Reader) *decodeState {
-	if in == nil {
-		panic(fmt.Errorf("nbt: Input stream is nil"))
+// Reads the payload of a TAG_Long.
+func (d *decodeState) readLong() (uint64, error) {
+	if d.order == NetworkLittleEndian {
+		value, err := binary.ReadVarint(d)
+		return uint64(value), err
+	}
+
+	return d.readUint64()
+}
+
+func (d *decodeState) readString() (string, error) {
+	length, err := d.readStringLength()
+	if err != nil {
+		return "", err
+	}
+
+	var value []byte
+	if d.fromBytes || int(length) <= len(d.buf) {
+		value, err = d.next(int(length))
+		if err == io.EOF && length != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	} else {
+		// Read in chunks, so that a length with nothing after it costs
+		// no more than what is really there.
+		value, err = d.readBytes(length, d.stringBuf)
+		d.stringBuf = value[:0]
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return decodeMUTF8(value), nil
+}
+
+// The names read so far from one compound, when duplicates are disallowed.
+// A nil set allows anything.
+type keySet map[string]struct{}
+
+func (d *decodeState) seenKeys() keySet {
+	if !d.disallowDuplicateKeys {
+		return nil
+	}
+	return make(keySet)
+}
+
+// Returns an error if name has been added to s before.
+func (s keySet) add(name string) error {
+	if s == nil {
+		return nil
+	}
+	if _, ok := s[name]; ok {
+		return fmt.Errorf("nbt: Duplicate key %q in compound", name)
+	}
+	s[name] = struct{}{}
+	return nil
+}
+
+// Called when starting to read a TAG_List or TAG_Compound. Every call that
+// returns nil must be followed by a call to leave.
+func (d *decodeState) enter() error {
+	if d.depth >= d.maxDepth {
+		return fmt.Errorf("nbt: Lists and compounds are nested more than %d deep", d.maxDepth)
+	}
+	d.depth++
+	return nil
+}
+
+func (d *decodeState) leave() {
+	d.depth--
+}
+
+// How much work is done between checks of the context given to
+// DecodeContext. Each compound field or list element counts as one.
+const contextCheckInterval = 4096
+
+// Called as n compound fields or list elements are read. Now and then, it
+// returns an error if the context given to DecodeContext is done.
+func (d *decodeState) tick(n int) error {
+	if d.ctx == nil {
+		return nil
+	}
+	if d.ticks += n; d.ticks < contextCheckInterval {
+		return nil
+	}
+	d.ticks = 0
+	return d.ctx.Err()
+}
+
+// Reads n bytes and throws them away.
+func (d *decodeState) skip(n int64) error {
+	if d.fromBytes {
+		if int64(len(d.data)-d.pos) < n {
+			d.pos = len(d.data)
+			if d.tail != nil {
+				return d.tail
+			}
+			return io.ErrUnexpectedEOF
+		}
+		d.pos += int(n)
+		return nil
+	}
+
+	_, err := io.CopyN(ioutil.Discard, d.in, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Reads a value of the given type without storing it anywhere.
+func (d *decodeState) skipValue(tag Tag) error {
+	if tag == TagList || tag == TagCompound {
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
 	}
 
-	switch compression {
-	case Uncompressed:
-		d.in = in
-	case GZip:
-		r, err := gzip.NewReader(in)
+	switch tag {
+	case TagByte:
+		return d.skip(1)
+
+	case TagShort:
+		return d.skip(2)
+
+	case TagInt:
+		_, err := d.readInt()
+		return err
+
+	case TagLong:
+		_, err := d.readLong()
+		return err
+
+	case TagFloat:
+		return d.skip(4)
+
+	case TagDouble:
+		return d.skip(8)
+
+	case TagByteArray:
+		length, err := d.readLength(tag)
+		if err != nil {
+			return err
+		}
+		return d.skip(int64(length))
+
+	case TagString:
+		length, err := d.readStringLength()
 		if err != nil {
-			panic(err)
+			return err
 		}
-		d.in = r
-	case ZLib:
-		r, err := zlib.NewReader(in)
+		return d.skip(int64(length))
+
+	case TagList:
+		inner, length, err := d.readListHeader()
 		if err != nil {
-			panic(err)
+			return err
 		}
-		d.in = r
-	default:
-		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+
+		for i := uint32(0); i < length; i++ {
+			if err := d.tick(1); err != nil {
+				return err
+			}
+			if err := d.skipValue(inner); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case TagCompound:
+		seen := d.seenKeys()
+		for {
+			name, tag, err := d.readTag()
+			if err != nil {
+				return err
+			}
+			if tag == TagEnd {
+				return nil
+			}
+			if err := seen.add(name); err != nil {
+				return err
+			}
+			if err := d.skipValue(tag); err != nil {
+				return err
+			}
+		}
+
+	case TagIntArray:
+		length, err := d.readLength(tag)
+		if err != nil {
+			return err
+		}
+		if d.order == NetworkLittleEndian {
+			for i := uint32(0); i < length; i++ {
+				if _, err := d.readInt(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return d.skip(int64(length) * 4)
+
+	case TagLongArray:
+		length, err := d.readLength(tag)
+		if err != nil {
+			return err
+		}
+		if d.order == NetworkLittleEndian {
+			for i := uint32(0); i < length; i++ {
+				if _, err := d.readLong(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return d.skip(int64(length) * 8)
+	}
+	return fmt.Errorf("nbt: Unhandled tag: %s", tag)
+}
+
+// Reads the value of the given type and returns its bytes exactly as they
+// appeared in the input.
+func (d *decodeState) readRaw(tag Tag) ([]byte, error) {
+	if d.fromBytes {
+		start := d.pos
+		err := d.skipValue(tag)
+		return d.data[start:d.pos], err
 	}
 
-	return d
+	var raw bytes.Buffer
+
+	in := d.in
+	d.in = io.TeeReader(in, &raw)
+	err := d.skipValue(tag)
+	d.in = in
+
+	return raw.Bytes(), err
 }
 
-func (d *decodeState) unmarshal(v interface{}) {
-	_, tag := d.readTag()
-	d.readValue(tag, reflect.ValueOf(v).Elem())
+// Returns the field of a struct with the given fields that the compound field
+// with the given name goes in.
+func (d *decodeState) field(info *structInfo, name string) (structField, bool) {
+	if field, ok := info.byName[name]; ok || !d.caseInsensitiveFields {
+		return field, ok
+	}
+	for _, f := range info.fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return structField{}, false
 }
 
-func (d *decodeState) r(i interface{}) {
-	err := binary.Read(d.in, binary.BigEndian, i)
+// Reads a number into v, a field with a type option saying it is held in a
+// want tag, checking that the value fits.
+func (d *decodeState) readOverride(tag, want Tag, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if tag != want {
+		return fmt.Errorf("nbt: Tag is %s, but the field is tagged as a %s", tag, want)
+	}
+
+	value, err := d.allocate(tag)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err := d.readValue(tag, value); err != nil {
+		return err
 	}
-}
 
-// Returns the name of the tag that was read.
-func (d *decodeState) readTag() (string, Tag) {
-	var tag Tag
-	d.r(&tag)
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		err = setNumber(v, value.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// The bits are read as unsigned, as they would be into a uint of
+		// the tag's own size.
+		err = setNumber(v, uint64(value.Int())&(1<<uint(value.Type().Bits())-1))
+	default:
+		err = setNumber(v, value.Float())
+	}
+	if err == nil && isValidator(v) {
+		err = checkValid(v)
+	}
+	return err
+}
 
-	if tag == tagEnd {
-		return "", tag
+// Sets v back to its zero value, but keeps the storage of slices and maps in
+// it, so that decoding into v again can fill them rather than allocating new
+// ones. Structs with unexported fields are simply zeroed, as are pointers,
+// since a nil pointer is how a missing field shows.
+func clearForReuse(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Slice:
+		if !v.IsNil() {
+			v.SetLen(0)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			v.SetMapIndex(key, reflect.Value{})
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				v.Set(reflect.Zero(v.Type()))
+				return
+			}
+		}
+		for i := 0; i < v.NumField(); i++ {
+			clearForReuse(v.Field(i))
+		}
+	case reflect.Array:
+		switch v.Type().Elem().Kind() {
+		case reflect.Slice, reflect.Map, reflect.Struct, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				clearForReuse(v.Index(i))
+			}
+		default:
+			v.Set(reflect.Zero(v.Type()))
+		}
+	default:
+		v.Set(reflect.Zero(v.Type()))
 	}
+}
 
-	name := d.readString()
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
 
-	return name, tag
+// Reads a value of the given type into map v, which has string keys, under
+// the given name, making the map first if it is nil.
+func (d *decodeState) readMapValue(tag Tag, name string, v reflect.Value) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	value := reflect.New(v.Type().Elem()).Elem()
+	if err := d.readValue(tag, value); err != nil {
+		return err
+	}
+	v.SetMapIndex(reflect.ValueOf(name).Convert(v.Type().Key()), value)
+	return nil
 }
 
-func (d *decodeState) allocate(tag Tag) reflect.Value {
+// Reports whether readValue knows how to put a tag of the given type into a
+// value of type t. For lists, only the outer type is checked.
+func (d *decodeState) canDecode(tag Tag, t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return true
+	}
+	if t == timeType && tag == TagLong || t == uuidType && tag == TagIntArray || t == orderedCompoundType && tag == TagCompound {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if tag == TagCompound && d.union != "" {
+			return true
+		}
+		value, err := d.allocateInterface(tag)
+		return err == nil && value.Type().AssignableTo(t)
+	}
+
 	switch tag {
-	case tagByte:
-		return reflect.ValueOf(new(int8)).Elem()
-	case tagShort:
-		return reflect.ValueOf(new(int16)).Elem()
-	case tagInt:
-		return reflect.ValueOf(new(int32)).Elem()
-	case tagLong:
-		return reflect.ValueOf(new(int64)).Elem()
-	case tagFloat:
-		return reflect.ValueOf(new(float32)).Elem()
-	case tagDouble:
-		return reflect.ValueOf(new(float64)).Elem()
-	case tagByteArray:
-		return reflect.ValueOf(new([]byte)).Elem()
-	case tagString:
-		return reflect.ValueOf(new(string)).Elem()
-	case tagList:
-		return reflect.ValueOf(new([]interface{})).Elem()
-	case tagCompound:
-		return reflect.ValueOf(new(map[string]interface{})).Elem()
-	case tagIntArray:
-		return reflect.ValueOf(new([]int32)).Elem()
-	case tagLongArray:
-		return reflect.ValueOf(new([]int64)).Elem()
-	}
-	panic(fmt.Errorf("nbt: Unhandled tag %s", tag))
-}
-
-func (d *decodeState) readString() string {
-	var length uint16
-	d.r(&length)
-
-	value := make([]byte, length)
-	_, err := d.in.Read(value)
-	if err != nil {
-		panic(err)
+	case TagByte:
+		return t.Kind() == reflect.Bool || t.Kind() == reflect.Int8 || t.Kind() == reflect.Uint8 || d.canWiden(t, 8)
+	case TagShort:
+		return t.Kind() == reflect.Int16 || t.Kind() == reflect.Uint16 || d.canWiden(t, 16)
+	case TagInt:
+		return t.Kind() == reflect.Int32 || t.Kind() == reflect.Uint32 || d.canWiden(t, 32)
+	case TagLong:
+		return t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64
+	case TagFloat:
+		return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 && d.widenFloats
+	case TagDouble:
+		return t.Kind() == reflect.Float64 || t.Kind() == reflect.Float32 && d.narrowFloats
+	case TagString:
+		return t.Kind() == reflect.String || (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8
+	case TagList:
+		return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+	case TagCompound:
+		return t.Kind() == reflect.Struct || t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+	case TagByteArray:
+		return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && d.canDecode(TagByte, t.Elem())
+	case TagIntArray:
+		return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && d.canDecode(TagInt, t.Elem())
+	case TagLongArray:
+		return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && d.canDecode(TagLong, t.Elem())
+	}
+	return false
+}
+
+// Reports whether an integer tag of the given size in bits may be widened to
+// fit in a t.
+func (d *decodeState) canWiden(t reflect.Type, size int) bool {
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return d.widenIntegers && t.Bits() > size
+	}
+	return false
+}
+
+// Stores bits, the payload of an integer tag of the given size, in v if it
+// may be widened to fit. See Decoder.WidenIntegers.
+func (d *decodeState) widen(v reflect.Value, bits uint64, size int) bool {
+	if !d.canWiden(v.Type(), size) {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		shift := 64 - size
+		v.SetInt(int64(bits<<shift) >> shift)
+	default:
+		v.SetUint(bits)
+	}
+	return true
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// Whether each type, or a pointer to it, implements Validator, as a bool.
+var validatorCache sync.Map
+
+// Reports whether v, or a pointer to it, is a Validator. Values of interface
+// type are not; the value they end up holding is checked instead.
+func isValidator(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface {
+		return false
+	}
+	t := v.Type()
+	is, ok := validatorCache.Load(t)
+	if !ok {
+		is, _ = validatorCache.LoadOrStore(t, t.Implements(validatorType) || reflect.PtrTo(t).Implements(validatorType))
+	}
+	return is.(bool) && (v.CanAddr() || t.Implements(validatorType))
+}
+
+// Returns an error if v, which isValidator, is not valid.
+func checkValid(v reflect.Value) error {
+	var validator Validator
+	if v.CanAddr() && v.Addr().Type().Implements(validatorType) {
+		validator = v.Addr().Interface().(Validator)
+	} else {
+		validator = v.Interface().(Validator)
 	}
+	if !validator.Valid() {
+		return fmt.Errorf("nbt: %v is not a valid %v", v.Interface(), v.Type())
+	}
+	return nil
+}
 
-	return string(value)
+// Returns v as an Unmarshaler if it (or rather a pointer to it) is one.
+func unmarshaler(v reflect.Value) (Unmarshaler, bool) {
+	if !v.CanAddr() || !v.Addr().CanInterface() {
+		return nil, false
+	}
+	u, ok := v.Addr().Interface().(Unmarshaler)
+	return u, ok
 }
 
-func (d *decodeState) readValue(tag Tag, v reflect.Value) {
+func (d *decodeState) readValue(tag Tag, v reflect.Value) (err error) {
+	// Follow pointers all the way down, allocating any that are nil.
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if isValidator(v) {
+		defer func() {
+			if err == nil {
+				err = checkValid(v)
+			}
+		}()
+	}
+
+	switch {
+	case v.Type() == timeType && tag == TagLong:
+		return d.readTime(v)
+	case v.Type() == uuidType && tag == TagIntArray:
+		return d.readUUID(v)
+	case v.Type() == orderedCompoundType && tag == TagCompound:
+		return d.readOrderedCompound(v)
+	}
+
+	if u, ok := unmarshaler(v); ok {
+		raw, err := d.readRaw(tag)
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalNBT(tag, bytes.NewReader(raw))
+	}
+
 	switch v.Kind() {
 	case reflect.Int, reflect.Uint:
-		panic(fmt.Errorf("nbt: int and uint types are not supported for portability reasons. Try int32 or uint32."))
+		return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
 	case reflect.Interface:
-		v.Set(d.allocate(tag))
-		v = v.Elem()
-	case reflect.Ptr:
-		v.Set(reflect.New(v.Type().Elem()))
-		v = v.Elem()
+		if tag == TagCompound && d.union != "" {
+			return d.readUnion(v)
+		}
+		value, err := d.allocateInterface(tag)
+		if err != nil {
+			return err
+		}
+		if !value.Type().AssignableTo(v.Type()) {
+			return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
+		}
+		if err := d.readValue(tag, value); err != nil {
+			return err
+		}
+		v.Set(value)
+		return nil
+	}
+
+	if tag == TagList || tag == TagCompound {
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
 	}
 
 	switch tag {
-	case tagByte:
-		var value uint8
-		d.r(&value)
+	case TagByte:
+		value, err := d.readUint8()
+		if err != nil {
+			return err
+		}
 		switch v.Kind() {
 		case reflect.Bool:
+			if d.strictBools && value > 1 {
+				return fmt.Errorf("nbt: TAG_Byte %d is not a valid bool", int8(value))
+			}
 			v.SetBool(value != 0)
 		case reflect.Int8:
 			v.SetInt(int64(int8(value)))
 		case reflect.Uint8:
 			v.SetUint(uint64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			if !d.widen(v, uint64(value), 8) {
+				return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
+			}
 		}
 
-	case tagShort:
-		var value uint16
-		d.r(&value)
+	case TagShort:
+		value, err := d.readUint16()
+		if err != nil {
+			return err
+		}
 		switch v.Kind() {
 		case reflect.Int16:
 			v.SetInt(int64(int16(value)))
 		case reflect.Uint16:
 			v.SetUint(uint64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			if !d.widen(v, uint64(value), 16) {
+				return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
+			}
 		}
 
-	case tagInt:
-		var value uint32
-		d.r(&value)
+	case TagInt:
+		value, err := d.readInt()
+		if err != nil {
+			return err
+		}
 		switch v.Kind() {
 		case reflect.Int32:
-			v.SetInt(int64(int16(value)))
+			v.SetInt(int64(int32(value)))
 		case reflect.Uint32:
 			v.SetUint(uint64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			if !d.widen(v, uint64(value), 32) {
+				return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
+			}
 		}
 
-	case tagLong:
-		var value uint64
-		d.r(&value)
+	case TagLong:
+		value, err := d.readLong()
+		if err != nil {
+			return err
+		}
 		switch v.Kind() {
 		case reflect.Int64:
 			v.SetInt(int64(value))
 		case reflect.Uint64:
 			v.SetUint(value)
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
 		}
 
-	case tagFloat:
-		var value float32
-		d.r(&value)
-		switch v.Kind() {
-		case reflect.Float32:
+	case TagFloat:
+		bits, err := d.readUint32()
+		if err != nil {
+			return err
+		}
+		value := math.Float32frombits(bits)
+		switch {
+		case v.Kind() == reflect.Float32, v.Kind() == reflect.Float64 && d.widenFloats:
 			v.SetFloat(float64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
 		}
 
-	case tagDouble:
-		var value float64
-		d.r(&value)
-		switch v.Kind() {
-		case reflect.Float64:
+	case TagDouble:
+		bits, err := d.readUint64()
+		if err != nil {
+			return err
+		}
+		value := math.Float64frombits(bits)
+		switch {
+		case v.Kind() == reflect.Float64:
 			v.SetFloat(value)
+		case v.Kind() == reflect.Float32 && d.narrowFloats:
+			return setNumber(v, value)
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
 		}
 
-	case tagByteArray:
-		var length uint32
-		d.r(&length)
+	case TagByteArray:
+		length, err := d.readLength(tag)
+		if err != nil {
+			return err
+		}
+		return d.readArray(tag, TagByte, "Byte", length, v)
 
+	case TagString:
 		switch v.Kind() {
-		case reflect.Array, reflect.Slice:
-			if v.Kind() == reflect.Array {
-				if uint32(v.Len()) < length {
-					panic(fmt.Errorf("nbt: Byte array is of length %d, but only the array given is only %d long!", length, v.Len()))
-				}
-			} else {
-				if uint32(v.Len()) < length {
-					v.Set(reflect.MakeSlice(v.Type(), int(length), int(length)))
-				}
+		case reflect.String:
+			value, err := d.readString()
+			if err != nil {
+				return err
 			}
-
-			for i := 0; i < int(length); i++ {
-				value := v.Index(i)
-				d.readValue(tagByte, value)
+			v.SetString(value)
+		case reflect.Slice, reflect.Array:
+			// The bytes exactly as they appear in the input, without
+			// making a Go string out of them.
+			if v.Type().Elem().Kind() != reflect.Uint8 {
+				return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
+			}
+			length, err := d.readStringLength()
+			if err != nil {
+				return err
+			}
+			if err := d.readByteArray("String", length, v); err != nil {
+				return err
+			}
+			for i := int(length); v.Kind() == reflect.Array && i < v.Len(); i++ {
+				v.Index(i).SetUint(0)
 			}
-
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
 		}
 
-	case tagString:
-		switch v.Kind() {
-		case reflect.String:
-			v.SetString(d.readString())
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+	case TagList:
+		inner, length, err := d.readListHeader()
+		if err != nil {
+			return err
+		}
+		if length != 0 && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && !d.canDecode(inner, v.Type().Elem()) {
+			return fmt.Errorf("nbt: list of %s cannot decode into %s", inner, v.Type())
 		}
-
-	case tagList:
-		var inner Tag
-		d.r(&inner)
-		var length uint32
-		d.r(&length)
 
 		switch v.Kind() {
 		case reflect.Slice:
-			if uint32(v.Cap()) < length {
-				v.Set(reflect.MakeSlice(v.Type(), 0, int(length)))
+			if ok, err := d.readScalars(inner, length, v); ok {
+				return err
+			}
+
+			// Reuse the slice if it is big enough, but don't leave anything
+			// behind from whatever was in it before.
+			reused := !v.IsNil() && uint32(v.Cap()) >= length
+			if reused {
+				v.Set(v.Slice(0, int(length)))
 			} else {
-				v.Set(v.Slice(0, 0))
+				v.Set(reflect.MakeSlice(v.Type(), initialCapacity(length), initialCapacity(length)))
 			}
-			kind := v.Type().Elem()
+			zero := reflect.Zero(v.Type().Elem())
 
-			var i uint32
-			defer func() {
-				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat list index %d", r, i))
+			for i := 0; i < int(length); i++ {
+				if i == v.Len() {
+					v.Set(reflect.Append(v, zero))
+					v.Set(v.Slice(0, v.Cap()))
+				}
+				elem := v.Index(i)
+				if reused {
+					clearForReuse(elem)
+				}
+				if err := d.tick(1); err != nil {
+					return err
 				}
-			}()
+				if err := d.readValue(inner, elem); err != nil {
+					return fmt.Errorf("%w\n\t\tat list index %d", err, i)
+				}
+			}
+			v.Set(v.Slice(0, int(length)))
 
-			for i = 0; i < length; i++ {
-				var value reflect.Value
-				if kind.Kind() == reflect.Ptr {
-					value = reflect.New(kind.Elem())
-					d.readValue(inner, value.Elem())
-				} else {
-					if kind.Kind() == reflect.Interface {
-						value = d.allocate(inner)
-					} else {
-						value = reflect.New(kind).Elem()
-					}
-					d.readValue(inner, value)
+		case reflect.Array:
+			if uint32(v.Len()) < length {
+				return fmt.Errorf("nbt: List is of length %d, but only the array given is only %d long!", length, v.Len())
+			}
+
+			for i := 0; i < int(length); i++ {
+				if err := d.tick(1); err != nil {
+					return err
 				}
-				v.Set(reflect.Append(v, value))
+				if err := d.readValue(inner, v.Index(i)); err != nil {
+					return fmt.Errorf("%w\n\t\tat list index %d", err, i)
+				}
+			}
+
+			// Don't leave anything behind from whatever was in the array
+			// before.
+			zero := reflect.Zero(v.Type().Elem())
+			for i := int(length); i < v.Len(); i++ {
+				v.Index(i).Set(zero)
 			}
 
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
 		}
 
-	case tagCompound:
+	case TagCompound:
 		switch v.Kind() {
 		case reflect.Struct:
-			fields := parseStruct(v)
-
-			var name string
-			defer func() {
-				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
-				}
-			}()
+			info, err := cachedStructInfo(v.Type())
+			if err != nil {
+				return err
+			}
+			defer func(union string) { d.union = union }(d.union)
 
+			seen := d.seenKeys()
 			for {
-				var tag Tag
-				name, tag = d.readTag()
-				if tag == tagEnd {
+				name, tag, err := d.readTag()
+				if err != nil {
+					return err
+				}
+				if tag == TagEnd {
 					break
 				}
-				if field, ok := fields[name]; ok {
-					d.readValue(tag, field)
+				if err := seen.add(name); err != nil {
+					return err
+				}
+				if field, ok := d.field(info, name); ok && field.tag != TagEnd {
+					err = d.readOverride(tag, field.tag, fieldByIndexAlloc(v, field.index))
+				} else if ok {
+					d.union = field.union
+					err = d.readValue(tag, fieldByIndexAlloc(v, field.index))
+				} else if info.hasExtra {
+					d.union = info.extra.union
+					err = d.readMapValue(tag, name, fieldByIndexAlloc(v, info.extra.index))
+				} else if d.disallowUnknownFields {
+					err = fmt.Errorf("nbt: Unhandled %s", tag)
 				} else {
-					panic(fmt.Errorf("nbt: Unhandled %s", tag))
+					err = d.skipValue(tag)
+				}
+				if err != nil {
+					return fmt.Errorf("%w\n\t\tat struct field %#v", err, name)
 				}
 			}
 
 		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
+			}
 			if v.IsNil() {
-				v.Set(reflect.ValueOf(make(map[string]interface{})))
+				v.Set(reflect.MakeMap(v.Type()))
 			}
 
-			var name string
-			defer func() {
-				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
-				}
-			}()
-
+			seen := d.seenKeys()
 			for {
-				var tag Tag
-				name, tag = d.readTag()
-				if tag == tagEnd {
+				name, tag, err := d.readTag()
+				if err != nil {
+					return err
+				}
+				if tag == TagEnd {
 					break
 				}
-				val := d.allocate(tag)
-				d.readValue(tag, val)
-				v.SetMapIndex(reflect.ValueOf(name), val)
-			}
-
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
-		}
-
-	case tagIntArray:
-		var length uint32
-		d.r(&length)
-
-		switch v.Kind() {
-		case reflect.Array, reflect.Slice:
-			if v.Kind() == reflect.Array {
-				if uint32(v.Len()) < length {
-					panic(fmt.Errorf("nbt: Int array is of length %d, but only the array given is only %d long!", length, v.Len()))
+				if err := seen.add(name); err != nil {
+					return err
 				}
-			} else {
-				if uint32(v.Len()) < length {
-					v.Set(reflect.MakeSlice(v.Type(), int(length), int(length)))
+				if err := d.readMapValue(tag, name, v); err != nil {
+					return fmt.Errorf("%w\n\t\tat struct field %#v", err, name)
 				}
 			}
 
-			for i := 0; i < int(length); i++ {
-				value := v.Index(i)
-				d.readValue(tagInt, value)
-			}
-
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			return &UnsupportedTypeError{Tag: tag, Type: v.Type()}
 		}
-	case tagLongArray:
-		var length uint32
-		d.r(&length)
-
-		switch v.Kind() {
-		case reflect.Array, reflect.Slice:
-			if v.Kind() == reflect.Array {
-				if uint32(v.Len()) < length {
-					panic(fmt.Errorf("nbt: Int array is of length %d, but only the array given is only %d long!", length, v.Len()))
-				}
-			} else {
-				if uint32(v.Len()) < length {
-					v.Set(reflect.MakeSlice(v.Type(), int(length), int(length)))
-				}
-			}
 
-			for i := 0; i < int(length); i++ {
-				value := v.Index(i)
-				d.readValue(tagLong, value)
-			}
+	case TagIntArray:
+		length, err := d.readLength(tag)
+		if err != nil {
+			return err
+		}
+		return d.readArray(tag, TagInt, "Int", length, v)
 
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+	case TagLongArray:
+		length, err := d.readLength(tag)
+		if err != nil {
+			return err
 		}
+		return d.readArray(tag, TagLong, "Long", length, v)
+
 	default:
-		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
+		return fmt.Errorf("nbt: Unhandled tag: %s", tag)
 	}
+
+	return nil
 }
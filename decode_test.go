@@ -1,9 +1,21 @@
 package nbt
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 type ServerList struct {
@@ -49,6 +61,125 @@ func TestServerList(t *testing.T) {
 	assertString(t, "Servers[2].IP", list.Servers[2].IP, "snow.man")
 }
 
+func TestOneByteReader(t *testing.T) {
+	f, err := os.Open("testcases/servers.dat")
+	if err != nil {
+		t.Error(err)
+	}
+	defer f.Close()
+
+	var list ServerList
+
+	err = Unmarshal(Uncompressed, iotest.OneByteReader(f), &list)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(list.Servers) != 3 {
+		t.Errorf("Server list length is %d, but expected 3.", len(list.Servers))
+	}
+
+	assertString(t, "Servers[2].Name", list.Servers[2].Name, "☃")
+	assertString(t, "Servers[2].IP", list.Servers[2].IP, "snow.man")
+}
+
+func TestTruncatedString(t *testing.T) {
+	data := []byte{
+		byte(TagString), 0, 0, // TAG_String ""
+		0, 5, 'H', 'e', 'l', // Five bytes claimed, but only three present.
+	}
+
+	var value string
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &value)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected %v, but got %v.", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestDecoderMultiple(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(intArrayData)
+	buf.Write(longArrayData)
+
+	dec := NewDecoder(Uncompressed, &buf)
+
+	var ints []int32
+	err := dec.Decode(&ints)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := []int32{1, -300, 2147483647}; !reflect.DeepEqual(ints, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", ints, expected)
+	}
+
+	var longs []int64
+	err = dec.Decode(&longs)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := []int64{1 << 40, -2}; !reflect.DeepEqual(longs, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", longs, expected)
+	}
+}
+
+func TestTruncatedStream(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/servers.dat")
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 1; i < len(data); i++ {
+		var list ServerList
+		err = Unmarshal(Uncompressed, bytes.NewReader(data[:i]), &list)
+		if err == nil {
+			t.Errorf("No error for a stream truncated to %d bytes, but one was expected!", i)
+		} else if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			t.Errorf("Truncated to %d bytes: %v", i, err)
+		}
+	}
+}
+
+func TestAutoDetect(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/servers.dat")
+	if err != nil {
+		t.Error(err)
+	}
+
+	var reference ServerList
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &reference)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, compression := range []Compression{Uncompressed, GZip, ZLib, Zstd} {
+		var buf bytes.Buffer
+		err = Marshal(compression, &buf, "", reference)
+		if err != nil {
+			t.Error(err)
+		}
+
+		var list ServerList
+		err = Unmarshal(AutoDetect, &buf, &list)
+		if err != nil {
+			t.Errorf("Compression %d: %v", compression, err)
+		}
+		if !reflect.DeepEqual(list, reference) {
+			t.Errorf("Compression %d: decoded %#v, but expected %#v.", compression, list, reference)
+		}
+	}
+
+	var list ServerList
+	err = Unmarshal(AutoDetect, bytes.NewReader(nil), &list)
+	if err != io.EOF {
+		t.Errorf("Expected %v for an empty stream, but got %v.", io.EOF, err)
+	}
+
+	err = Unmarshal(AutoDetect, bytes.NewReader([]byte{byte(TagCompound)}), &list)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected %v for a one byte stream, but got %v.", io.ErrUnexpectedEOF, err)
+	}
+}
+
 type MapServerList struct {
 	Servers []map[string]interface{} `nbt:"servers"`
 }
@@ -111,97 +242,875 @@ func TestMapDecode2(t *testing.T) {
 	assertString(t, "Servers[2].IP", servers[2].(map[string]interface{})["ip"].(string), "snow.man")
 }
 
-type EmptyServerList struct {
+type MixedCase struct {
+	Pos  []float64
+	PoS  []float64
+	Name string `nbt:"name"`
 }
 
-func TestErrMissingField(t *testing.T) {
-	f, err := os.Open("testcases/servers.dat")
+func TestCaseInsensitiveFields(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagList), 0, 3, 'p', 'o', 's', byte(TagDouble), 0, 0, 0, 1, // TAG_List "pos": 1 TAG_Double
+		0x3f, 0xf0, 0, 0, 0, 0, 0, 0, // 1
+		byte(TagList), 0, 3, 'P', 'o', 'S', byte(TagDouble), 0, 0, 0, 1, // TAG_List "PoS": 1 TAG_Double
+		0x40, 0, 0, 0, 0, 0, 0, 0, // 2
+		byte(TagString), 0, 4, 'N', 'A', 'M', 'E', 0, 1, 'x', // TAG_String "NAME": "x"
+		byte(TagEnd),
+	}
+
+	var exact MixedCase
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &exact); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exact, MixedCase{PoS: []float64{2}}) {
+		t.Errorf("Only exact matches should be used by default, but found %#v.", exact)
+	}
+
+	var insensitive MixedCase
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.CaseInsensitiveFields(true)
+	if err := dec.Decode(&insensitive); err != nil {
+		t.Fatal(err)
+	}
+	// "pos" goes in the first field that matches it ignoring case, while
+	// "PoS" matches a field exactly.
+	if !reflect.DeepEqual(insensitive, MixedCase{Pos: []float64{1}, PoS: []float64{2}, Name: "x"}) {
+		t.Errorf("Found %#v.", insensitive)
+	}
+}
+
+type Palette struct {
+	Palette map[string]int32
+}
+
+func TestTypedMapDecode(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagCompound), 0, 7, 'P', 'a', 'l', 'e', 't', 't', 'e', // TAG_Compound "Palette"
+		byte(TagInt), 0, 5, 's', 't', 'o', 'n', 'e', 0, 0, 0, 0, // TAG_Int "stone": 0
+		byte(TagInt), 0, 4, 'd', 'i', 'r', 't', 0, 0, 0, 1, // TAG_Int "dirt": 1
+		byte(TagInt), 0, 3, 'a', 'i', 'r', 0, 0, 0, 2, // TAG_Int "air": 2
+		byte(TagEnd),
+		byte(TagEnd),
+	}
+
+	var palette Palette
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &palette); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int32{"stone": 0, "dirt": 1, "air": 2}
+	if !reflect.DeepEqual(palette.Palette, expected) {
+		t.Errorf("Found %v, but expected %v.", palette.Palette, expected)
+	}
+
+	var wrong map[string]string
+	err := Unmarshal(Uncompressed, bytes.NewReader(data[3:len(data)-1]), &wrong)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: at offset 22: Tag is TAG_Int, but I don't know how to put that in a string!\n\t\tat struct field \"stone\"" {
+		t.Error(err)
+	}
+
+	var badKeys map[int32]int32
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &badKeys); err == nil {
+		t.Error("No error for a map with int32 keys.")
+	}
+}
+
+type IntValue struct {
+	Value int32
+}
+
+func TestIntDecode(t *testing.T) {
+	for _, expected := range []int32{0, 1, -1, 32767, -32768, 70000, -70000, 2147483647, -2147483648} {
+		var buf bytes.Buffer
+		buf.Write([]byte{byte(TagCompound), 0, 0})
+		buf.Write([]byte{byte(TagInt), 0, 5, 'V', 'a', 'l', 'u', 'e'})
+		binary.Write(&buf, binary.BigEndian, expected)
+		buf.WriteByte(byte(TagEnd))
+
+		var value IntValue
+		err := Unmarshal(Uncompressed, &buf, &value)
+		if err != nil {
+			t.Error(err)
+		}
+		if value.Value != expected {
+			t.Errorf("Decoded %d, but expected %d.", value.Value, expected)
+		}
+	}
+}
+
+var intArrayData = []byte{
+	byte(TagIntArray), 0, 0, // TAG_Int_Array ""
+	0, 0, 0, 3, // Length 3
+	0, 0, 0, 1, // 1
+	0xff, 0xff, 0xfe, 0xd4, // -300
+	0x7f, 0xff, 0xff, 0xff, // 2147483647
+}
+
+func TestIntArrayDecode(t *testing.T) {
+	var slice []int32
+	err := Unmarshal(Uncompressed, bytes.NewReader(intArrayData), &slice)
 	if err != nil {
 		t.Error(err)
 	}
-	defer f.Close()
+	if expected := []int32{1, -300, 2147483647}; !reflect.DeepEqual(slice, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", slice, expected)
+	}
 
-	var list EmptyServerList
+	reused := []int32{9, 9, 9, 9, 9}
+	err = Unmarshal(Uncompressed, bytes.NewReader(intArrayData), &reused)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := []int32{1, -300, 2147483647}; !reflect.DeepEqual(reused, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", reused, expected)
+	}
 
-	err = Unmarshal(Uncompressed, f, &list)
+	var unsigned []uint32
+	err = Unmarshal(Uncompressed, bytes.NewReader(intArrayData), &unsigned)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := []uint32{1, 4294966996, 2147483647}; !reflect.DeepEqual(unsigned, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", unsigned, expected)
+	}
+
+	var array [4]int32
+	err = Unmarshal(Uncompressed, bytes.NewReader(intArrayData), &array)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := [4]int32{1, -300, 2147483647, 0}; array != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", array, expected)
+	}
+
+	var short [2]int32
+	err = Unmarshal(Uncompressed, bytes.NewReader(intArrayData), &short)
 	if err == nil {
 		t.Error("No error, but one was expected!")
-	} else if err.Error() != "nbt: Unhandled tagList (0x09)\n\t\tat struct field \"servers\"" {
-		t.Error(err)
 	}
 }
 
-type WronglyTypedServerList struct {
-	Servers []WronglyTypedServer `nbt:"servers"`
+var longArrayData = []byte{
+	byte(TagLongArray), 0, 0, // TAG_Long_Array ""
+	0, 0, 0, 2, // Length 2
+	0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, // 1 << 40
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe, // -2
 }
 
-type WronglyTypedServer struct {
-	Name string  `nbt:"name"`
-	IP   float64 `nbt:"ip"`
+func TestLongArrayDecode(t *testing.T) {
+	var slice []int64
+	err := Unmarshal(Uncompressed, bytes.NewReader(longArrayData), &slice)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := []int64{1 << 40, -2}; !reflect.DeepEqual(slice, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", slice, expected)
+	}
+
+	var unsigned []uint64
+	err = Unmarshal(Uncompressed, bytes.NewReader(longArrayData), &unsigned)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := []uint64{1 << 40, 1<<64 - 2}; !reflect.DeepEqual(unsigned, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", unsigned, expected)
+	}
+
+	var array [3]int64
+	err = Unmarshal(Uncompressed, bytes.NewReader(longArrayData), &array)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := [3]int64{1 << 40, -2, 0}; array != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", array, expected)
+	}
+
+	var short [1]int64
+	err = Unmarshal(Uncompressed, bytes.NewReader(longArrayData), &short)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: at offset 7: Long array is of length 2, but only the array given is only 1 long!" {
+		t.Error(err)
+	}
 }
 
-func TestErrWrongType(t *testing.T) {
+func TestNestedMapDecode(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagCompound), 0, 5, 'i', 'n', 'n', 'e', 'r', // TAG_Compound "inner"
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 2, 'h', 'i', // TAG_String "name": "hi"
+		byte(TagList), 0, 4, 'l', 'i', 's', 't', byte(TagShort), 0, 0, 0, 2, 0, 1, 0, 2, // TAG_List "list": [1, 2]
+		byte(TagEnd),
+		byte(TagByte), 0, 1, 'b', 0xff, // TAG_Byte "b": -1
+		byte(TagEnd),
+	}
+
+	var value map[string]interface{}
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &value)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := map[string]interface{}{
+		"inner": map[string]interface{}{
+			"name": "hi",
+			"list": []interface{}{int16(1), int16(2)},
+		},
+		"b": int8(-1),
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", value, expected)
+	}
+}
+
+func TestInterfaceDecode(t *testing.T) {
 	f, err := os.Open("testcases/servers.dat")
 	if err != nil {
 		t.Error(err)
 	}
 	defer f.Close()
 
-	var list WronglyTypedServerList
+	var list interface{}
 
 	err = Unmarshal(Uncompressed, f, &list)
-	if err == nil {
-		t.Error("No error, but one was expected!")
-	} else if err.Error() != "nbt: Tag is tagString (0x08), but I don't know how to put that in a float64!\n\t\tat struct field \"ip\"\n\t\tat list index 0\n\t\tat struct field \"servers\"" {
+	if err != nil {
+		t.Error(err)
+	}
+
+	root, ok := list.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Decoded a %T, but expected a map[string]interface{}.", list)
+	}
+	servers := root["servers"].([]interface{})
+
+	if len(servers) != 3 {
+		t.Errorf("Server list length is %d, but expected 3.", len(servers))
+	}
+
+	assertString(t, "Servers[2].Name", servers[2].(map[string]interface{})["name"].(string), "☃")
+	assertString(t, "Servers[2].IP", servers[2].(map[string]interface{})["ip"].(string), "snow.man")
+
+	var number interface{}
+	err = Unmarshal(Uncompressed, bytes.NewReader(longArrayData), &number)
+	if err != nil {
 		t.Error(err)
 	}
+	if expected := []int64{1 << 40, -2}; !reflect.DeepEqual(number, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", number, expected)
+	}
 }
 
-type BigTest struct {
-	ByteTest     int8       `nbt:"byteTest"`
-	ShortTest    int16      `nbt:"shortTest"`
-	IntTest      int32      `nbt:"intTest"`
-	LongTest     int64      `nbt:"longTest"`
-	FloatTest    float32    `nbt:"floatTest"`
-	DoubleTest   float64    `nbt:"doubleTest"`
-	StringTest   string     `nbt:"stringTest"`
-	Nested       Nested     `nbt:"nested compound test"`
-	LongList     []int64    `nbt:"listTest (long)"`
-	CompoundList []ListItem `nbt:"listTest (compound)"`
-	ByteArray    []byte     `nbt:"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"`
+type TaggedFields struct {
+	Explicit int32 `nbt:"xPos"`
+	Fallback int32
+	Skipped  int32 `nbt:"-"`
 }
 
-type Nested struct {
-	Ham Food `nbt:"ham"`
-	Egg Food `nbt:"egg"`
+func TestStructTags(t *testing.T) {
+	info, err := cachedStructInfo(reflect.TypeOf(TaggedFields{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := info.byName
+	if len(fields) != 2 {
+		t.Errorf("Found %d fields, but expected 2.", len(fields))
+	}
+	for _, name := range []string{"xPos", "Fallback"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("Field %#v is missing.", name)
+		}
+	}
+
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagInt), 0, 4, 'x', 'P', 'o', 's', 0, 0, 0, 1, // TAG_Int "xPos": 1
+		byte(TagInt), 0, 8, 'F', 'a', 'l', 'l', 'b', 'a', 'c', 'k', 0, 0, 0, 2, // TAG_Int "Fallback": 2
+		byte(TagEnd),
+	}
+
+	var value TaggedFields
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &value)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := (TaggedFields{Explicit: 1, Fallback: 2}); value != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", value, expected)
+	}
+
+	skipped := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagInt), 0, 7, 'S', 'k', 'i', 'p', 'p', 'e', 'd', 0, 0, 0, 3, // TAG_Int "Skipped": 3
+		byte(TagEnd),
+	}
+
+	err = Unmarshal(Uncompressed, bytes.NewReader(skipped), &value)
+	if err != nil {
+		t.Error(err)
+	}
+	if value.Skipped != 0 {
+		t.Errorf("Skipped field was set to %d.", value.Skipped)
+	}
 }
 
-type Food struct {
+type LittleEndianValues struct {
+	Value int32  `nbt:"value"`
+	Name  string `nbt:"name"`
+}
+
+func TestLittleEndian(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagInt), 5, 0, 'v', 'a', 'l', 'u', 'e', 0x70, 0x11, 0x01, 0x00, // TAG_Int "value": 70000
+		byte(TagString), 4, 0, 'n', 'a', 'm', 'e', 5, 0, 'S', 't', 'e', 'v', 'e', // TAG_String "name": "Steve"
+		byte(TagEnd),
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.SetByteOrder(LittleEndian)
+
+	var value LittleEndianValues
+	err := dec.Decode(&value)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := (LittleEndianValues{Value: 70000, Name: "Steve"}); value != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", value, expected)
+	}
+}
+
+type NetworkValues struct {
+	Value int32   `nbt:"value"`
+	Time  int64   `nbt:"time"`
+	Short int16   `nbt:"short"`
 	Name  string  `nbt:"name"`
-	Value float32 `nbt:"value"`
+	List  []int32 `nbt:"list"`
 }
 
-type ListItem struct {
-	Name    string `nbt:"name"`
-	Created uint64 `nbt:"created-on"`
+func TestNetworkLittleEndian(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, // TAG_Compound ""
+		byte(TagInt), 5, 'v', 'a', 'l', 'u', 'e', 0xdf, 0xc5, 0x08, // TAG_Int "value": -70000
+		byte(TagLong), 4, 't', 'i', 'm', 'e', 0x80, 0x80, 0x80, 0x80, 0x80, 0x40, // TAG_Long "time": 1 << 40
+		byte(TagShort), 5, 's', 'h', 'o', 'r', 't', 0x34, 0x12, // TAG_Short "short": 0x1234
+		byte(TagString), 4, 'n', 'a', 'm', 'e', 5, 'S', 't', 'e', 'v', 'e', // TAG_String "name": "Steve"
+		byte(TagList), 4, 'l', 'i', 's', 't', byte(TagInt), 0x04, 0x01, 0xe0, 0xc5, 0x08, // TAG_List "list": [-1, 70000]
+		byte(TagEnd),
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.SetByteOrder(NetworkLittleEndian)
+
+	var value NetworkValues
+	err := dec.Decode(&value)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := NetworkValues{Value: -70000, Time: 1 << 40, Short: 0x1234, Name: "Steve", List: []int32{-1, 70000}}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", value, expected)
+	}
 }
 
-func TestBigTest(t *testing.T) {
-	f, err := os.Open("testcases/bigtest.nbt")
+func TestHugeStringLength(t *testing.T) {
+	for _, test := range []struct {
+		order ByteOrder
+		data  []byte
+	}{
+		// A length of 0xfffffffe, which no TAG_String can have.
+		{NetworkLittleEndian, []byte{byte(TagString), 0xfe, 0xff, 0xff, 0xff, 0x0f}},
+		// As long as a string can be, but with nothing after the length.
+		{NetworkLittleEndian, []byte{byte(TagString), 0xff, 0xff, 0x03}},
+		{BigEndian, []byte{byte(TagString), 0xff, 0xff}},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		dec := NewDecoder(Uncompressed, bytes.NewReader(test.data))
+		dec.SetByteOrder(test.order)
+		var v interface{}
+		err := dec.Decode(&v)
+		runtime.ReadMemStats(&after)
+
+		if err == nil {
+			t.Errorf("No error for % x, but one was expected!", test.data)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 32<<10 {
+			t.Errorf("Allocated %d bytes for % x.", allocated, test.data)
+		}
+	}
+}
+
+func TestNetworkRoot(t *testing.T) {
+	data := []byte{
+		byte(TagCompound),                                                        // TAG_Compound with no name
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 5, 'S', 't', 'e', 'v', 'e', // TAG_String "name": "Steve"
+		byte(TagShort), 0, 6, 'H', 'e', 'a', 'l', 't', 'h', 0, 20, // TAG_Short "Health": 20
+		byte(TagEnd),
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.NetworkRoot(true)
+
+	var player PartialPlayer
+	err := dec.Decode(&player)
 	if err != nil {
 		t.Error(err)
 	}
-	defer f.Close()
+	if expected := (PartialPlayer{Health: 20, Name: "Steve"}); player != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", player, expected)
+	}
+}
 
-	expected := BigTest{
-		ByteTest:   127,
-		ShortTest:  32767,
-		IntTest:    -1,
-		LongTest:   9223372036854775807,
-		FloatTest:  0.49823147,
-		DoubleTest: 0.4931287132182315,
-		StringTest: "HELLO WORLD THIS IS A TEST STRING ÅÄÖ!",
-		Nested: Nested{
+// Returns a root TAG_List containing depth-1 more lists, each inside the last.
+func nestedLists(depth int) []byte {
+	data := []byte{byte(TagList), 0, 0}
+	for i := 1; i < depth; i++ {
+		data = append(data, byte(TagList), 0, 0, 0, 1)
+	}
+	return append(data, byte(TagEnd), 0, 0, 0, 0)
+}
+
+func TestMaxDepth(t *testing.T) {
+	var value interface{}
+	err := Unmarshal(Uncompressed, bytes.NewReader(nestedLists(DefaultMaxDepth)), &value)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = Unmarshal(Uncompressed, bytes.NewReader(nestedLists(100000)), &value)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if !strings.HasPrefix(err.Error(), "nbt: at offset 2563: Lists and compounds are nested more than 512 deep\n") {
+		t.Error(err)
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(nestedLists(4)))
+	dec.SetMaxDepth(3)
+	err = dec.Decode(&value)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	}
+
+	// Fields that are skipped are held to the same limit.
+	data := []byte{byte(TagCompound), 0, 0, byte(TagList), 0, 1, 'x'}
+	data = append(data, nestedLists(DefaultMaxDepth)[3:]...)
+	data = append(data, byte(TagEnd))
+
+	var empty struct{}
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &empty)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if !strings.HasPrefix(err.Error(), "nbt: at offset 2562: Lists and compounds are nested more than 512 deep") {
+		t.Error(err)
+	}
+}
+
+func TestHugeLength(t *testing.T) {
+	for _, data := range [][]byte{
+		{byte(TagByteArray), 0, 0, 0x7f, 0xff, 0xff, 0xff, 1, 2, 3},
+		{byte(TagIntArray), 0, 0, 0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 1},
+		{byte(TagLongArray), 0, 0, 0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 1},
+		{byte(TagList), 0, 0, byte(TagInt), 0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 1},
+	} {
+		var value interface{}
+		err := Unmarshal(Uncompressed, bytes.NewReader(data), &value)
+		if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			t.Errorf("%s: expected the end of the stream, but got %v.", Tag(data[0]), err)
+		}
+
+		// The length comes after the tag, the empty name and, for a list,
+		// the element tag.
+		offset := 7
+		if Tag(data[0]) == TagList {
+			offset = 8
+		}
+
+		dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+		dec.SetMaxElements(1000)
+		err = dec.Decode(&value)
+		if err == nil {
+			t.Errorf("%s: no error, but one was expected!", Tag(data[0]))
+		} else if expected := fmt.Sprintf("nbt: at offset %d: %s of length 2147483647 is longer than the limit of 1000", offset, Tag(data[0])); err.Error() != expected {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNegativeLength(t *testing.T) {
+	for _, data := range [][]byte{
+		{byte(TagByteArray), 0, 0, 0xff, 0xff, 0xff, 0xff, 1, 2, 3},
+		{byte(TagIntArray), 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1},
+		{byte(TagLongArray), 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 1},
+		{byte(TagList), 0, 0, byte(TagInt), 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1},
+	} {
+		offset := 7
+		if Tag(data[0]) == TagList {
+			offset = 8
+		}
+		expected := fmt.Sprintf("nbt: at offset %d: %s has negative length -1", offset, Tag(data[0]))
+
+		var value interface{}
+		if err := Unmarshal(Uncompressed, bytes.NewReader(data), &value); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q, but got %v.", Tag(data[0]), expected, err)
+		}
+		if _, err := Parse(Uncompressed, bytes.NewReader(data)); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q from Parse, but got %v.", Tag(data[0]), expected, err)
+		}
+		if err := Valid(Uncompressed, bytes.NewReader(data)); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q from Valid, but got %v.", Tag(data[0]), expected, err)
+		}
+	}
+}
+
+// Stored as a TAG_String like "12,-5".
+type Coordinates struct {
+	X, Z int32
+}
+
+func (c *Coordinates) UnmarshalNBT(tag Tag, r io.Reader) error {
+	if tag != TagString {
+		return fmt.Errorf("Coordinates must be a TAG_String, not a %s", tag)
+	}
+
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return err
+	}
+
+	_, err := fmt.Sscanf(string(value), "%d,%d", &c.X, &c.Z)
+	return err
+}
+
+type Waypoint struct {
+	Name     string      `nbt:"name"`
+	Position Coordinates `nbt:"pos"`
+}
+
+func TestUnmarshaler(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagString), 0, 3, 'p', 'o', 's', 0, 5, '1', '2', ',', '-', '5', // TAG_String "pos": "12,-5"
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 4, 'h', 'o', 'm', 'e', // TAG_String "name": "home"
+		byte(TagEnd),
+	}
+
+	var waypoint Waypoint
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &waypoint)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := (Waypoint{Name: "home", Position: Coordinates{12, -5}}); waypoint != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", waypoint, expected)
+	}
+
+	wrong := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagInt), 0, 3, 'p', 'o', 's', 0, 0, 0, 1, // TAG_Int "pos": 1
+		byte(TagEnd),
+	}
+	err = Unmarshal(Uncompressed, bytes.NewReader(wrong), &waypoint)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: at offset 13: Coordinates must be a TAG_String, not a TAG_Int\n\t\tat struct field \"pos\"" {
+		t.Error(err)
+	}
+}
+
+type RawMessageHolder struct {
+	Name string     `nbt:"name"`
+	Data RawMessage `nbt:"data"`
+}
+
+func TestRawMessage(t *testing.T) {
+	nested := []byte{
+		byte(TagCompound),                                                // The payload of "data" follows.
+		byte(TagList), 0, 1, 'l', byte(TagShort), 0, 0, 0, 2, 0, 1, 0, 2, // TAG_List "l": [1, 2]
+		byte(TagCompound), 0, 1, 'c', // TAG_Compound "c"
+		byte(TagString), 0, 1, 's', 0, 2, 'h', 'i', // TAG_String "s": "hi"
+		byte(TagEnd),
+		byte(TagEnd),
+	}
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 4, 'r', 'o', 'o', 't', // TAG_String "name": "root"
+		byte(TagCompound), 0, 4, 'd', 'a', 't', 'a', // TAG_Compound "data"
+	}
+	data = append(data, nested[1:]...)
+	data = append(data, byte(TagEnd))
+
+	var holder RawMessageHolder
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &holder)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(holder.Data, nested) {
+		t.Errorf("Captured %#v, but expected %#v.", holder.Data, nested)
+	}
+
+	var encoded bytes.Buffer
+	err = Marshal(Uncompressed, &encoded, "", holder)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var reference, result map[string]interface{}
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &reference)
+	if err != nil {
+		t.Error(err)
+	}
+	err = Unmarshal(Uncompressed, &encoded, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, reference) {
+		t.Errorf("Re-encoded %#v, but expected %#v.", result, reference)
+	}
+}
+
+func TestRawMessageList(t *testing.T) {
+	data := mustMarshal(t, Uncompressed, "", map[string]interface{}{
+		"Items": []map[string]interface{}{
+			{"id": "minecraft:stone", "Count": int8(3)},
+			{"id": "minecraft:dirt", "Count": int8(64)},
+		},
+		"Pos": []float64{0.5, -2},
+	})
+
+	var raw struct {
+		Items []RawMessage
+		Pos   []RawMessage
+	}
+	if err := UnmarshalBytes(Uncompressed, data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := MarshalBytes(Uncompressed, "", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("Re-encoded %v, but expected %v.", encoded, data)
+	}
+}
+
+type OptionalFields struct {
+	Name    string `nbt:"name"`
+	Present *Food  `nbt:"present"`
+	Absent  *Food  `nbt:"absent"`
+	Deep    **int32
+}
+
+func TestPointerFields(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagCompound), 0, 7, 'p', 'r', 'e', 's', 'e', 'n', 't', // TAG_Compound "present"
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 3, 'H', 'a', 'm', // TAG_String "name": "Ham"
+		byte(TagEnd),
+		byte(TagInt), 0, 4, 'D', 'e', 'e', 'p', 0, 0, 0, 7, // TAG_Int "Deep": 7
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 1, 'x', // TAG_String "name": "x"
+		byte(TagEnd),
+	}
+
+	var value OptionalFields
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &value)
+	if err != nil {
+		t.Error(err)
+	}
+	if value.Present == nil || value.Present.Name != "Ham" {
+		t.Errorf("Present is %#v, but expected a Food named Ham.", value.Present)
+	}
+	if value.Absent != nil {
+		t.Errorf("Absent is %#v, but expected nil.", value.Absent)
+	}
+	if value.Deep == nil || *value.Deep == nil || **value.Deep != 7 {
+		t.Errorf("Deep was not decoded as a pointer to a pointer to 7.")
+	}
+	assertString(t, "Name", value.Name, "x")
+
+	var encoded bytes.Buffer
+	err = Marshal(Uncompressed, &encoded, "", value)
+	if err != nil {
+		t.Error(err)
+	}
+	var result OptionalFields
+	err = Unmarshal(Uncompressed, &encoded, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, value) {
+		t.Errorf("Round trip gave %#v, but expected %#v.", result, value)
+	}
+}
+
+func TestListIntoArray(t *testing.T) {
+	data := []byte{
+		byte(TagList), 0, 0, byte(TagDouble), 0, 0, 0, 3, // TAG_List "" of 3 TAG_Double
+		0x3f, 0xf0, 0, 0, 0, 0, 0, 0, // 1.0
+		0x40, 0x50, 0, 0, 0, 0, 0, 0, // 64.0
+		0xc0, 0x00, 0, 0, 0, 0, 0, 0, // -2.0
+	}
+
+	var pos [3]float64
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &pos)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := [3]float64{1, 64, -2}; pos != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", pos, expected)
+	}
+
+	longer := [4]float64{9, 9, 9, 9}
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &longer)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := [4]float64{1, 64, -2, 0}; longer != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", longer, expected)
+	}
+
+	var shorter [2]float64
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &shorter)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: at offset 8: List is of length 3, but only the array given is only 2 long!" {
+		t.Error(err)
+	}
+}
+
+type EmptyServerList struct {
+}
+
+func TestErrMissingField(t *testing.T) {
+	f, err := os.Open("testcases/servers.dat")
+	if err != nil {
+		t.Error(err)
+	}
+	defer f.Close()
+
+	var list EmptyServerList
+
+	dec := NewDecoder(Uncompressed, f)
+	dec.DisallowUnknownFields(true)
+	err = dec.Decode(&list)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: at offset 13: Unhandled TAG_List\n\t\tat struct field \"servers\"" {
+		t.Error(err)
+	}
+}
+
+type PartialPlayer struct {
+	Health int16
+	Name   string `nbt:"name"`
+}
+
+func TestSkipUnknownFields(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagLong), 0, 4, 'T', 'i', 'm', 'e', 0, 0, 0, 0, 0, 0, 0, 1, // TAG_Long "Time": 1
+		byte(TagShort), 0, 6, 'H', 'e', 'a', 'l', 't', 'h', 0, 20, // TAG_Short "Health": 20
+		byte(TagList), 0, 3, 'P', 'o', 's', byte(TagDouble), 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, // TAG_List "Pos": [0.0]
+		byte(TagCompound), 0, 5, 'E', 'x', 't', 'r', 'a', // TAG_Compound "Extra"
+		byte(TagIntArray), 0, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 7, // TAG_Int_Array "a": [7]
+		byte(TagString), 0, 1, 's', 0, 1, 'x', // TAG_String "s": "x"
+		byte(TagEnd),
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 5, 'S', 't', 'e', 'v', 'e', // TAG_String "name": "Steve"
+		byte(TagEnd),
+	}
+
+	var player PartialPlayer
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &player)
+	if err != nil {
+		t.Error(err)
+	}
+	if expected := (PartialPlayer{Health: 20, Name: "Steve"}); player != expected {
+		t.Errorf("Decoded %#v, but expected %#v.", player, expected)
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.DisallowUnknownFields(true)
+	err = dec.Decode(&player)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	}
+}
+
+type WronglyTypedServerList struct {
+	Servers []WronglyTypedServer `nbt:"servers"`
+}
+
+type WronglyTypedServer struct {
+	Name string  `nbt:"name"`
+	IP   float64 `nbt:"ip"`
+}
+
+func TestErrWrongType(t *testing.T) {
+	f, err := os.Open("testcases/servers.dat")
+	if err != nil {
+		t.Error(err)
+	}
+	defer f.Close()
+
+	var list WronglyTypedServerList
+
+	err = Unmarshal(Uncompressed, f, &list)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: at offset 35: Tag is TAG_String, but I don't know how to put that in a float64!\n\t\tat struct field \"ip\"\n\t\tat list index 0\n\t\tat struct field \"servers\"" {
+		t.Error(err)
+	}
+}
+
+type BigTest struct {
+	ByteTest     int8       `nbt:"byteTest"`
+	ShortTest    int16      `nbt:"shortTest"`
+	IntTest      int32      `nbt:"intTest"`
+	LongTest     int64      `nbt:"longTest"`
+	FloatTest    float32    `nbt:"floatTest"`
+	DoubleTest   float64    `nbt:"doubleTest"`
+	StringTest   string     `nbt:"stringTest"`
+	Nested       Nested     `nbt:"nested compound test"`
+	LongList     []int64    `nbt:"listTest (long)"`
+	CompoundList []ListItem `nbt:"listTest (compound)"`
+	ByteArray    []byte     `nbt:"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"`
+}
+
+type Nested struct {
+	Ham Food `nbt:"ham"`
+	Egg Food `nbt:"egg"`
+}
+
+type Food struct {
+	Name  string  `nbt:"name"`
+	Value float32 `nbt:"value"`
+}
+
+type ListItem struct {
+	Name    string `nbt:"name"`
+	Created uint64 `nbt:"created-on"`
+}
+
+func TestBigTest(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer f.Close()
+
+	expected := BigTest{
+		ByteTest:   127,
+		ShortTest:  32767,
+		IntTest:    2147483647,
+		LongTest:   9223372036854775807,
+		FloatTest:  0.49823147,
+		DoubleTest: 0.4931287132182315,
+		StringTest: "HELLO WORLD THIS IS A TEST STRING ÅÄÖ!",
+		Nested: Nested{
 			Ham: Food{Name: "Hampus", Value: 0.75},
 			Egg: Food{Name: "Eggbert", Value: 0.5},
 		},
@@ -300,14 +1209,2374 @@ func TestBigTest(t *testing.T) {
 		t.Error(err)
 	}
 
-	left, right := parseStruct(reflect.ValueOf(bigTest)), parseStruct(reflect.ValueOf(expected))
-	for field, l := range left {
-		r := right[field]
+	info, err := cachedStructInfo(reflect.TypeOf(bigTest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range info.fields {
+		l, _ := fieldByIndex(reflect.ValueOf(bigTest), f.index)
+		r, _ := fieldByIndex(reflect.ValueOf(expected), f.index)
 
 		if !reflect.DeepEqual(l.Interface(), r.Interface()) {
-			t.Errorf("Field %s differs:", field)
+			t.Errorf("Field %s differs:", f.name)
 			t.Logf("Found   : %#v", l)
 			t.Logf("Expected: %#v", r)
 		}
 	}
 }
+
+func TestErrorOffset(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagInt), 0, 1, 'a', 0, 0, 0, 1, // TAG_Int "a": 1
+		42, // Not a tag.
+	}
+
+	var value map[string]interface{}
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &value)
+	if err == nil {
+		t.Fatal("No error, but one was expected!")
+	}
+	if !strings.HasPrefix(err.Error(), "nbt: at offset 12: ") {
+		t.Error(err)
+	}
+
+	if _, err := Parse(Uncompressed, bytes.NewReader(data)); err == nil || !strings.HasPrefix(err.Error(), "nbt: at offset 12: ") {
+		t.Errorf("Parse: %v", err)
+	}
+}
+
+type BenchChunk struct {
+	DataVersion int32
+	XPos        int32 `nbt:"xPos"`
+	ZPos        int32 `nbt:"zPos"`
+	Status      string
+	Sections    []BenchSection `nbt:"sections"`
+	Heightmaps  struct {
+		MotionBlocking [37]int64 `nbt:"MOTION_BLOCKING"`
+		WorldSurface   [37]int64 `nbt:"WORLD_SURFACE"`
+	}
+	BlockEntities []map[string]interface{} `nbt:"block_entities"`
+}
+
+type BenchSection struct {
+	Y           int8
+	BlockStates struct {
+		Palette []BenchBlockState `nbt:"palette"`
+		Data    []int64           `nbt:"data"`
+	} `nbt:"block_states"`
+	BlockLight []byte
+	SkyLight   []byte
+}
+
+type BenchBlockState struct {
+	Name       string
+	Properties struct {
+		Level string `nbt:"level"`
+	}
+}
+
+// Returns the encoding of something shaped like a chunk from a region file.
+func benchChunk(b *testing.B) []byte {
+	var chunk BenchChunk
+	chunk.DataVersion = 3465
+	chunk.Status = "minecraft:full"
+	for y := -4; y < 20; y++ {
+		var section BenchSection
+		section.Y = int8(y)
+		for i, name := range []string{"stone", "dirt", "grass_block", "oak_log", "water"} {
+			state := BenchBlockState{Name: "minecraft:" + name}
+			state.Properties.Level = fmt.Sprint(i)
+			section.BlockStates.Palette = append(section.BlockStates.Palette, state)
+		}
+		section.BlockStates.Data = make([]int64, 256)
+		for i := range section.BlockStates.Data {
+			section.BlockStates.Data[i] = int64(i) * 0x0123456789
+		}
+		section.BlockLight = make([]byte, 2048)
+		section.SkyLight = make([]byte, 2048)
+		chunk.Sections = append(chunk.Sections, section)
+	}
+	for i := 0; i < 10; i++ {
+		chunk.BlockEntities = append(chunk.BlockEntities, map[string]interface{}{
+			"id": "minecraft:chest",
+			"x":  int32(i), "y": int32(64), "z": int32(0),
+			"Items": []map[string]interface{}{{"id": "minecraft:stone", "Count": int8(64), "Slot": int8(0)}},
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := Marshal(Uncompressed, &buf, "", chunk); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeChunk(b *testing.B) {
+	data := benchChunk(b)
+	name := filepath.Join(b.TempDir(), "chunk.nbt")
+	if err := ioutil.WriteFile(name, data, 0666); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var chunk BenchChunk
+		err = Unmarshal(Uncompressed, f, &chunk)
+		f.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnmarshalBytes(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, compression := range []Compression{GZip, AutoDetect} {
+		var fromBytes, fromReader BigTest
+		if err := UnmarshalBytes(compression, data, &fromBytes); err != nil {
+			t.Fatal(err)
+		}
+		if err := Unmarshal(compression, bytes.NewReader(data), &fromReader); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fromBytes, fromReader) {
+			t.Errorf("%v: UnmarshalBytes and Unmarshal disagree.", compression)
+		}
+	}
+
+	// Every way of cutting a document short fails the same way either way.
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "", PartialPlayer{}); err != nil {
+		t.Fatal(err)
+	}
+	full := encoded.Bytes()
+	for i := 0; i < len(full); i++ {
+		var fromBytes, fromReader map[string]interface{}
+		errBytes := UnmarshalBytes(Uncompressed, full[:i], &fromBytes)
+		errReader := Unmarshal(Uncompressed, bytes.NewReader(full[:i]), &fromReader)
+		if fmt.Sprint(errBytes) != fmt.Sprint(errReader) {
+			t.Errorf("%d bytes: UnmarshalBytes gave %v, but Unmarshal gave %v.", i, errBytes, errReader)
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data := benchChunk(b)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var chunk BenchChunk
+		if err := Unmarshal(Uncompressed, bytes.NewReader(data), &chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalBytes(b *testing.B) {
+	data := benchChunk(b)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var chunk BenchChunk
+		if err := UnmarshalBytes(Uncompressed, data, &chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeFloatList(b *testing.B) {
+	list := make([]float64, 100000)
+	for i := range list {
+		list[i] = float64(i) / 3
+	}
+	var buf bytes.Buffer
+	if err := Marshal(Uncompressed, &buf, "", list); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var result []float64
+		if err := Unmarshal(Uncompressed, bytes.NewReader(data), &result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestListElementMismatch(t *testing.T) {
+	data := []byte{
+		byte(TagList), 0, 0, byte(TagString), 0, 0, 0, 2, // TAG_List "": 2 TAG_String
+		0, 1, 'a', // "a"
+		0, 1, 'b', // "b"
+	}
+
+	var ints []int32
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &ints)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: at offset 8: list of TAG_String cannot decode into []int32" {
+		t.Error(err)
+	}
+
+	var strs []string
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &strs); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(strs, []string{"a", "b"}) {
+		t.Errorf("Found %#v.", strs)
+	}
+
+	var fixed [2]*string
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &fixed); err != nil {
+		t.Error(err)
+	} else if *fixed[1] != "b" {
+		t.Errorf("Found %#v.", fixed)
+	}
+
+	var ifaces []fmt.Stringer
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &ifaces)
+	if err == nil || err.Error() != "nbt: at offset 8: list of TAG_String cannot decode into []fmt.Stringer" {
+		t.Errorf("Expected a mismatch for []fmt.Stringer, but got %v.", err)
+	}
+}
+
+func TestEmptyEndList(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 1, 'L', byte(TagEnd), 0, 0, 0, 0, // TAG_List "L": 0 TAG_End
+		byte(TagEnd),
+	}
+
+	var v struct {
+		L []float64
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.L == nil || len(v.L) != 0 {
+		t.Errorf("Found %#v, but expected an empty slice.", v.L)
+	}
+
+	var iface interface{}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &iface); err != nil {
+		t.Fatal(err)
+	}
+	if l := iface.(map[string]interface{})["L"].([]interface{}); l == nil || len(l) != 0 {
+		t.Errorf("Found %#v, but expected an empty slice.", l)
+	}
+
+	n, err := Parse(Uncompressed, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := n.Get("L"); l.Elem != TagEnd || l.Len() != 0 {
+		t.Errorf("Found %#v, but expected an empty list.", l)
+	}
+
+	data[11] = 2 // Two TAG_End elements.
+	for name, decode := range map[string]func() error{
+		"Unmarshal": func() error { return Unmarshal(Uncompressed, bytes.NewReader(data), &v) },
+		"Parse": func() error {
+			_, err := Parse(Uncompressed, bytes.NewReader(data))
+			return err
+		},
+		"skip": func() error { return Unmarshal(Uncompressed, bytes.NewReader(data), &struct{}{}) },
+	} {
+		err := decode()
+		if err == nil {
+			t.Errorf("%s: No error, but one was expected!", name)
+		} else if err.Error() != "nbt: at offset 12: TAG_List of TAG_End has 2 elements\n\t\tat struct field \"L\"" {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestTimeAndUUID(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagLong), 0, 10, 'L', 'a', 's', 't', 'P', 'l', 'a', 'y', 'e', 'd',
+		0, 0, 0x01, 0x8b, 0xcf, 0xe5, 0x68, 0x00, // 1700000000000
+		byte(TagIntArray), 0, 4, 'U', 'U', 'I', 'D', 0, 0, 0, 4,
+		0x06, 0x9a, 0x79, 0xf4, 0x44, 0xe9, 0x47, 0x26, 0xa5, 0xbe, 0xfc, 0xa9, 0x0e, 0x38, 0xaa, 0xf5,
+		byte(TagEnd),
+	}
+
+	type Player struct {
+		LastPlayed time.Time
+		UUID       UUID
+	}
+	var p Player
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &p); err != nil {
+		t.Fatal(err)
+	}
+	if !p.LastPlayed.Equal(time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC)) {
+		t.Errorf("LastPlayed is %v.", p.LastPlayed)
+	}
+	if p.UUID.String() != "069a79f4-44e9-4726-a5be-fca90e38aaf5" {
+		t.Errorf("UUID is %v.", p.UUID)
+	}
+	if u := UUIDFromLongs(0x069a79f444e94726, -0x5a410356f1c7550b); u != p.UUID {
+		t.Errorf("UUIDFromLongs gave %v.", u)
+	}
+
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "", p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded.Bytes(), data) {
+		t.Errorf("Marshal wrote % x.", encoded.Bytes())
+	}
+
+	data[34] = 3
+	err := Unmarshal(Uncompressed, bytes.NewReader(data[:48]), &p)
+	if err == nil || err.Error() != "nbt: at offset 35: UUID needs a TAG_Int_Array of length 4, not 3\n\t\tat struct field \"UUID\"" {
+		t.Errorf("Expected a length error, but got %v.", err)
+	}
+}
+
+// Cancels a context once more than n bytes have been read through it.
+type cancelingReader struct {
+	r      io.Reader
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.n -= n; c.n < 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestDecodeContext(t *testing.T) {
+	type Entry struct {
+		X, Y float64
+	}
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "", struct {
+		Doubles []float64
+		Entries []Entry
+	}{make([]float64, 100000), make([]Entry, 100000)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel while reading each of the lists.
+	for _, after := range []int{1000, encoded.Len() / 2} {
+		ctx, cancel := context.WithCancel(context.Background())
+		in := bytes.NewReader(encoded.Bytes())
+		var v struct {
+			Doubles []float64
+			Entries []Entry
+		}
+		err := UnmarshalContext(ctx, Uncompressed, &cancelingReader{r: in, n: after, cancel: cancel}, &v)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Canceled after %d bytes, but got %v.", after, err)
+		}
+		if in.Len() == 0 {
+			t.Errorf("Canceled after %d bytes, but the whole input was read.", after)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var v interface{}
+	if err := UnmarshalContext(ctx, Uncompressed, bytes.NewReader(encoded.Bytes()), &v); err != context.Canceled {
+		t.Errorf("Expected %v, but got %v.", context.Canceled, err)
+	}
+	if err := UnmarshalContext(context.Background(), Uncompressed, bytes.NewReader(encoded.Bytes()), &v); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWidenIntegers(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagByte), 0, 1, 'B', 0xfb, // -5
+		byte(TagByte), 0, 1, 'U', 0xff, // -1
+		byte(TagShort), 0, 1, 'S', 0x80, 0x00, // -32768
+		byte(TagList), 0, 1, 'L', byte(TagByte), 0, 0, 0, 2, 1, 0xfe, // [1, -2]
+		byte(TagEnd),
+	}
+
+	type Widened struct {
+		B int32
+		U uint32
+		S int64
+		L []int16
+	}
+	var v Widened
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.WidenIntegers(true)
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Widened{B: -5, U: 255, S: -32768, L: []int16{1, -2}}); !reflect.DeepEqual(v, expected) {
+		t.Errorf("Found %#v, but expected %#v.", v, expected)
+	}
+
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &v)
+	if err == nil || err.Error() != "nbt: at offset 8: Tag is TAG_Byte, but I don't know how to put that in a int32!\n\t\tat struct field \"B\"" {
+		t.Errorf("Expected TAG_Byte to be refused without widening, but got %v.", err)
+	}
+
+	data = []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagLong), 0, 1, 'B', 0, 0, 0, 0, 0, 0, 0, 1,
+		byte(TagEnd),
+	}
+	dec = NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.WidenIntegers(true)
+	err = dec.Decode(&v)
+	if err == nil || err.Error() != "nbt: at offset 15: Tag is TAG_Long, but I don't know how to put that in a int32!\n\t\tat struct field \"B\"" {
+		t.Errorf("Expected TAG_Long to be refused for an int32, but got %v.", err)
+	}
+}
+
+func TestRootEOF(t *testing.T) {
+	doc := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagByte), 0, 1, 'B', 1,
+		byte(TagEnd),
+	}
+	input := append(append([]byte(nil), doc...), doc...)
+
+	// Two documents and then a clean end.
+	dec := NewDecoder(Uncompressed, bytes.NewReader(input))
+	for i := 0; i < 2; i++ {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("Document %d: %v", i, err)
+		}
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err != io.EOF {
+		t.Errorf("Expected %v after the last document, but got %v.", io.EOF, err)
+	}
+	if _, err := dec.DecodeNode(); err != io.EOF {
+		t.Errorf("Expected %v from DecodeNode after the last document, but got %v.", io.EOF, err)
+	}
+
+	// Ending after the root tag ID, or between fields, is not clean.
+	for _, end := range []int{1, 8} {
+		err := Unmarshal(Uncompressed, bytes.NewReader(doc[:end]), &v)
+		if err == io.EOF || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Expected %v after %d bytes, but got %v.", io.ErrUnexpectedEOF, end, err)
+		}
+		err = UnmarshalBytes(Uncompressed, doc[:end], &v)
+		if err == io.EOF || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("UnmarshalBytes: Expected %v after %d bytes, but got %v.", io.ErrUnexpectedEOF, end, err)
+		}
+		_, err = Parse(Uncompressed, bytes.NewReader(doc[:end]))
+		if err == io.EOF || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Parse: Expected %v after %d bytes, but got %v.", io.ErrUnexpectedEOF, end, err)
+		}
+	}
+	if err := UnmarshalBytes(Uncompressed, nil, &v); err != io.EOF {
+		t.Errorf("Expected %v for no input, but got %v.", io.EOF, err)
+	}
+}
+
+type ItemStack struct {
+	ID    string `nbt:"id"`
+	Count int8
+	Slot  uint8
+}
+
+func TestInventory(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 9, 'I', 'n', 'v', 'e', 'n', 't', 'o', 'r', 'y', byte(TagCompound), 0, 0, 0, 2,
+		byte(TagString), 0, 2, 'i', 'd', 0, 15, 'm', 'i', 'n', 'e', 'c', 'r', 'a', 'f', 't', ':', 's', 't', 'o', 'n', 'e',
+		byte(TagByte), 0, 5, 'C', 'o', 'u', 'n', 't', 64,
+		byte(TagByte), 0, 4, 'S', 'l', 'o', 't', 0,
+		byte(TagEnd),
+		byte(TagByte), 0, 4, 'S', 'l', 'o', 't', 8,
+		byte(TagString), 0, 2, 'i', 'd', 0, 15, 'm', 'i', 'n', 'e', 'c', 'r', 'a', 'f', 't', ':', 'a', 'p', 'p', 'l', 'e',
+		byte(TagByte), 0, 5, 'C', 'o', 'u', 'n', 't', 3,
+		byte(TagEnd),
+		byte(TagEnd),
+	}
+	expected := []ItemStack{
+		{ID: "minecraft:stone", Count: 64, Slot: 0},
+		{ID: "minecraft:apple", Count: 3, Slot: 8},
+	}
+
+	var slice struct {
+		Inventory []ItemStack
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &slice); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(slice.Inventory, expected) {
+		t.Errorf("Found %#v, but expected %#v.", slice.Inventory, expected)
+	}
+
+	var pointers struct {
+		Inventory []*ItemStack
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &pointers); err != nil {
+		t.Error(err)
+	} else if len(pointers.Inventory) != 2 || *pointers.Inventory[0] != expected[0] || *pointers.Inventory[1] != expected[1] {
+		t.Errorf("Found %#v, but expected %#v.", pointers.Inventory, expected)
+	}
+
+	var array struct {
+		Inventory [36]ItemStack
+	}
+	array.Inventory[35].ID = "minecraft:dirt"
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &array); err != nil {
+		t.Error(err)
+	} else if array.Inventory[0] != expected[0] || array.Inventory[1] != expected[1] || array.Inventory[35] != (ItemStack{}) {
+		t.Errorf("Found %#v, but expected %#v.", array.Inventory[:2], expected)
+	}
+}
+
+func TestDisallowTrailingData(t *testing.T) {
+	doc := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagByte), 0, 1, 'B', 1,
+		byte(TagEnd),
+	}
+
+	for _, trailing := range [][]byte{nil, {0}, doc} {
+		input := append(append([]byte(nil), doc...), trailing...)
+		dec := NewDecoder(Uncompressed, bytes.NewReader(input))
+		dec.DisallowTrailingData(true)
+		var v struct{ B int8 }
+		err := dec.Decode(&v)
+		if len(trailing) == 0 {
+			if err != nil {
+				t.Error(err)
+			}
+		} else if err == nil || err.Error() != "nbt: at offset 10: Trailing data after the root tag" {
+			t.Errorf("Expected trailing data after % x, but got %v.", trailing, err)
+		}
+
+		dec = NewDecoder(Uncompressed, bytes.NewReader(input))
+		dec.DisallowTrailingData(true)
+		_, err = dec.DecodeNode()
+		if (err != nil) != (len(trailing) != 0) {
+			t.Errorf("DecodeNode with trailing % x: %v", trailing, err)
+		}
+	}
+}
+
+func TestStringBytes(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagString), 0, 1, 'S', 0, 3, 'a', 0x80, 'b',
+		byte(TagList), 0, 1, 'L', byte(TagString), 0, 0, 0, 1, 0, 2, 0xc0, 0x80,
+		byte(TagEnd),
+	}
+
+	var v struct {
+		S []byte
+		L [][4]byte
+	}
+	v.L = [][4]byte{{1, 2, 3, 4}}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.S, []byte{'a', 0x80, 'b'}) {
+		t.Errorf("S is % x.", v.S)
+	}
+	if len(v.L) != 1 || v.L[0] != [4]byte{0xc0, 0x80} {
+		t.Errorf("L is % x.", v.L)
+	}
+
+	var short struct {
+		S [2]byte
+	}
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &short)
+	if err == nil || err.Error() != "nbt: at offset 9: String array is of length 3, but only the array given is only 2 long!\n\t\tat struct field \"S\"" {
+		t.Errorf("Expected the string not to fit, but got %v.", err)
+	}
+}
+
+func TestModifiedUTF8(t *testing.T) {
+	for encoded, expected := range map[string]string{
+		"plain":                     "plain",
+		"a\xc0\x80b":                "a\x00b",
+		"\xed\xa0\xbd\xed\xb8\x80!": "\U0001F600!",
+		"\xf0\x9f\x98\x80":          "\U0001F600", // Plain UTF-8.
+		"\xed\xa0\xbdx":             "\uFFFDx",    // Half of a surrogate pair.
+		"\xed\xb8\x80\xed\xa0\xbd":  "\uFFFD\uFFFD",
+		"\xc0\x80\xed\xa0\xbd\xed\xb8\x80\xc0\x80": "\x00\U0001F600\x00",
+	} {
+		data := append([]byte{byte(TagString), 0, 0, 0, byte(len(encoded))}, encoded...)
+		var s string
+		if err := Unmarshal(Uncompressed, bytes.NewReader(data), &s); err != nil {
+			t.Errorf("%q: %v", encoded, err)
+		} else if s != expected {
+			t.Errorf("%q decoded to %q, but expected %q.", encoded, s, expected)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := Valid(GZip, f); err != nil {
+		t.Errorf("bigtest.nbt: %v", err)
+	}
+
+	doc := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 1, 'L', byte(TagInt), 0, 0, 0, 1, 0, 0, 0, 42,
+		byte(TagEnd),
+	}
+	if err := Valid(Uncompressed, bytes.NewReader(doc)); err != nil {
+		t.Error(err)
+	}
+	for end := 1; end < len(doc); end++ {
+		if err := Valid(Uncompressed, bytes.NewReader(doc[:end])); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Expected %v after %d bytes, but got %v.", io.ErrUnexpectedEOF, end, err)
+		}
+	}
+
+	bad := append([]byte(nil), doc...)
+	bad[3] = 13
+	if err := Valid(Uncompressed, bytes.NewReader(bad)); err == nil || err.Error() != "nbt: at offset 4: Unhandled tag: Unknown(13)" {
+		t.Errorf("Expected an unhandled tag, but got %v.", err)
+	}
+
+	deep := append([]byte{byte(TagList), 0, 0}, bytes.Repeat([]byte{byte(TagList), 0, 0, 0, 1}, DefaultMaxDepth+1)...)
+	if err := Valid(Uncompressed, bytes.NewReader(deep)); err == nil || !strings.Contains(err.Error(), "nested more than") {
+		t.Errorf("Expected the nesting to be too deep, but got %v.", err)
+	}
+
+	if err := Valid(Uncompressed, bytes.NewReader(append(doc, 0))); err == nil || err.Error() != "nbt: at offset 18: Trailing data after the root tag" {
+		t.Errorf("Expected trailing data, but got %v.", err)
+	}
+}
+
+type Entity interface {
+	EntityID() string
+}
+
+type Zombie struct {
+	ID     string `nbt:"id"`
+	Health float32
+}
+
+func (z *Zombie) EntityID() string { return z.ID }
+
+type ArmorStand struct {
+	ID        string `nbt:"id"`
+	ShowArms  bool
+	Invisible bool
+}
+
+func (a ArmorStand) EntityID() string { return a.ID }
+
+func TestUnion(t *testing.T) {
+	RegisterType("minecraft:zombie", (*Zombie)(nil))
+	RegisterType("minecraft:armor_stand", ArmorStand{})
+
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 8, 'E', 'n', 't', 'i', 't', 'i', 'e', 's', byte(TagCompound), 0, 0, 0, 2,
+		// The discriminator doesn't have to come first.
+		byte(TagFloat), 0, 6, 'H', 'e', 'a', 'l', 't', 'h', 0x41, 0xa0, 0, 0, // 20
+		byte(TagString), 0, 2, 'i', 'd', 0, 16, 'm', 'i', 'n', 'e', 'c', 'r', 'a', 'f', 't', ':', 'z', 'o', 'm', 'b', 'i', 'e',
+		byte(TagEnd),
+		byte(TagString), 0, 2, 'i', 'd', 0, 21, 'm', 'i', 'n', 'e', 'c', 'r', 'a', 'f', 't', ':', 'a', 'r', 'm', 'o', 'r', '_', 's', 't', 'a', 'n', 'd',
+		byte(TagByte), 0, 8, 'S', 'h', 'o', 'w', 'A', 'r', 'm', 's', 1,
+		byte(TagEnd),
+		byte(TagEnd),
+	}
+
+	var chunk struct {
+		Entities []Entity `nbt:"Entities,union=id"`
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.Entities) != 2 {
+		t.Fatalf("Found %d entities, but expected 2.", len(chunk.Entities))
+	}
+	if z, ok := chunk.Entities[0].(*Zombie); !ok || *z != (Zombie{ID: "minecraft:zombie", Health: 20}) {
+		t.Errorf("Found %#v, but expected a zombie.", chunk.Entities[0])
+	}
+	if a, ok := chunk.Entities[1].(ArmorStand); !ok || a != (ArmorStand{ID: "minecraft:armor_stand", ShowArms: true}) {
+		t.Errorf("Found %#v, but expected an armor stand.", chunk.Entities[1])
+	}
+
+	// Without a registered type, compounds are read as maps, where they fit.
+	data[49] = 'Z'
+	var generic struct {
+		Entities []interface{} `nbt:"Entities,union=id"`
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &generic); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := generic.Entities[0].(map[string]interface{}); !ok || m["id"] != "minecraft:Zombie" {
+		t.Errorf("Found %#v, but expected a map.", generic.Entities[0])
+	}
+	if _, ok := generic.Entities[1].(ArmorStand); !ok {
+		t.Errorf("Found %#v, but expected an armor stand.", generic.Entities[1])
+	}
+
+	err := Unmarshal(Uncompressed, bytes.NewReader(data), &chunk)
+	if err == nil || !strings.HasPrefix(err.Error(), `nbt: at offset 56: No type is registered for id "minecraft:Zombie", and a nbt.Entity cannot hold a map[string]interface {}`) {
+		t.Errorf("Expected an unregistered type, but got %v.", err)
+	}
+
+	// Errors inside the compound say where in it they happened.
+	data[49] = 'z'
+	data[19] = byte(TagInt)
+	for _, fromBytes := range []bool{false, true} {
+		if fromBytes {
+			err = UnmarshalBytes(Uncompressed, data, &chunk)
+		} else {
+			err = Unmarshal(Uncompressed, bytes.NewReader(data), &chunk)
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), "nbt: at offset 32: Tag is TAG_Int, but I don't know how to put that in a float32!") {
+			t.Errorf("fromBytes=%v: Expected an error at the Health field, but got %v.", fromBytes, err)
+		}
+	}
+}
+
+func TestSectorPadding(t *testing.T) {
+	reference := RoundTripNested{Name: "padded"}
+	for _, compression := range []Compression{ZLib, GZip} {
+		var buf bytes.Buffer
+		if err := Marshal(compression, &buf, "", reference); err != nil {
+			t.Fatal(err)
+		}
+		payload := buf.Bytes()
+		padded := append(append([]byte(nil), payload...), make([]byte, 4096-len(payload))...)
+
+		var v RoundTripNested
+		if err := Unmarshal(compression, bytes.NewReader(padded), &v); err != nil || v != reference {
+			t.Errorf("%v: Unmarshal gave %#v, %v", compression, v, err)
+		}
+		v = RoundTripNested{}
+		if err := UnmarshalBytes(compression, padded, &v); err != nil || v != reference {
+			t.Errorf("%v: UnmarshalBytes gave %#v, %v", compression, v, err)
+		}
+		dec := NewDecoder(compression, bytes.NewReader(padded))
+		dec.DisallowTrailingData(true)
+		if err := dec.Decode(&v); err != nil {
+			t.Errorf("%v: Decode with trailing data disallowed: %v", compression, err)
+		}
+		if err := Valid(compression, bytes.NewReader(padded)); err != nil {
+			t.Errorf("%v: Valid: %v", compression, err)
+		}
+
+		// A payload that was cut short is still an error.
+		if err := UnmarshalBytes(compression, payload[:len(payload)/2], &v); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%v: Expected %v for a truncated payload, but got %v.", compression, io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+type ExtraFields struct {
+	Name  string                 `nbt:"name"`
+	Extra map[string]interface{} `nbt:",extra"`
+}
+
+func TestExtraFields(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagInt), 0, 4, 'S', 'l', 'o', 't', 0, 0, 0, 7,
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 1, 'x',
+		byte(TagShort), 0, 5, 'E', 'x', 't', 'r', 'a', 0, 1,
+		byte(TagEnd),
+	}
+
+	var v ExtraFields
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	expected := ExtraFields{
+		Name:  "x",
+		Extra: map[string]interface{}{"Slot": int32(7), "Extra": int16(1)},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Found %#v, but expected %#v.", v, expected)
+	}
+
+	// The unknown fields survive a round trip, and a name the struct has a
+	// field for isn't written twice.
+	v.Extra["name"] = "ignored"
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "", v); err != nil {
+		t.Fatal(err)
+	}
+	var generic map[string]interface{}
+	if err := Unmarshal(Uncompressed, &encoded, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]interface{}{"name": "x", "Slot": int32(7), "Extra": int16(1)}; !reflect.DeepEqual(generic, expected) {
+		t.Errorf("Found %#v, but expected %#v.", generic, expected)
+	}
+
+	var twice struct {
+		A map[string]int32 `nbt:",extra"`
+		B map[string]int32 `nbt:",extra"`
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &twice); err == nil || !strings.HasSuffix(err.Error(), " has multiple fields with the extra option") {
+		t.Errorf("Expected an error for two extra fields, but got %v.", err)
+	}
+}
+
+type BogusTypeOption struct {
+	X int32 `nbt:"x,type=bogus"`
+}
+
+func TestBadStructTags(t *testing.T) {
+	data := mustMarshal(t, Uncompressed, "", map[string]int32{"x": 1})
+
+	for _, test := range []struct {
+		v        interface{}
+		expected string
+	}{
+		{&struct {
+			A int32 `nbt:"x"`
+			B int32 `nbt:"x"`
+		}{}, `has multiple fields with name "x"`},
+		{&BogusTypeOption{}, `Field X of nbt.BogusTypeOption has a bad struct tag: unknown type option "bogus", which must be one of byte, short, int, long, float, double`},
+		{&struct {
+			X int32 `nbt:"x,type=compound"`
+		}{}, `unknown type option "compound"`},
+		{&struct {
+			Extra []int32 `nbt:",extra"`
+		}{}, "is a []int32, not a map with string keys"},
+		{&struct {
+			X string `nbt:"x,type=int"`
+		}{}, "which cannot be a TAG_Int"},
+	} {
+		if err := UnmarshalBytes(Uncompressed, data, test.v); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Decoding into %T: Expected an error containing %q, but got %v.", test.v, test.expected, err)
+		}
+		// The error is remembered for the type, and encoding gives it too.
+		if err := UnmarshalBytes(Uncompressed, data, test.v); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Decoding into %T again: Expected an error containing %q, but got %v.", test.v, test.expected, err)
+		}
+		if _, err := MarshalBytes(Uncompressed, "", test.v); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Encoding %T: Expected an error containing %q, but got %v.", test.v, test.expected, err)
+		}
+	}
+}
+
+func TestUnexportedFields(t *testing.T) {
+	type Entity struct {
+		X int32 `nbt:"x"`
+		y int32
+		z int32 `nbt:"z"`
+	}
+	data := mustMarshal(t, Uncompressed, "", map[string]int32{"x": 1, "y": 2, "z": 3})
+
+	var e Entity
+	if err := UnmarshalBytes(Uncompressed, data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e != (Entity{X: 1}) {
+		t.Errorf("Decoded %#v.", e)
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.DisallowUnknownFields(true)
+	if err := dec.Decode(&e); err == nil {
+		t.Error("No error for a field that matches only an unexported one, but one was expected!")
+	}
+
+	encoded, err := MarshalBytes(Uncompressed, "", Entity{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]int32
+	if err := UnmarshalBytes(Uncompressed, encoded, &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]int32{"x": 1}) {
+		t.Errorf("Encoded %v.", m)
+	}
+}
+
+func TestDecodeNamed(t *testing.T) {
+	var encoded bytes.Buffer
+	enc := NewEncoder(Uncompressed, &encoded)
+	if err := enc.Encode("Level", RoundTripNested{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode("", RoundTripNested{Name: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	encoded.Write([]byte{byte(TagCompound), 0, 5, 'C', 'h'}) // Cut off in the name.
+
+	dec := NewDecoder(Uncompressed, &encoded)
+	for _, expected := range []string{"Level", ""} {
+		var v RoundTripNested
+		name, err := dec.DecodeNamed(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != expected {
+			t.Errorf("Root tag is named %q, but expected %q.", name, expected)
+		}
+	}
+	var v RoundTripNested
+	if _, err := dec.DecodeNamed(&v); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected %v, but got %v.", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestSignedByteArray(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagByteArray), 0, 6, 'B', 'l', 'o', 'c', 'k', 's', 0, 0, 0, 4, 1, 0x7f, 0x80, 0xff,
+		byte(TagList), 0, 4, 'L', 'i', 's', 't', byte(TagByte), 0, 0, 0, 2, 0xfe, 2,
+		byte(TagEnd),
+	}
+
+	var slices struct {
+		Blocks []int8
+		List   []int8
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &slices); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(slices.Blocks, []int8{1, 127, -128, -1}) {
+		t.Errorf("Blocks is %v.", slices.Blocks)
+	}
+	if !reflect.DeepEqual(slices.List, []int8{-2, 2}) {
+		t.Errorf("List is %v.", slices.List)
+	}
+
+	var arrays struct {
+		Blocks [5]int8
+	}
+	arrays.Blocks[4] = 9
+	if err := UnmarshalBytes(Uncompressed, data, &arrays); err != nil {
+		t.Fatal(err)
+	}
+	if arrays.Blocks != [5]int8{1, 127, -128, -1, 9} {
+		t.Errorf("Blocks is %v.", arrays.Blocks)
+	}
+}
+
+func TestLongListDecode(t *testing.T) {
+	doubles := make([]float64, 3000)
+	names := make([]string, 3000)
+	for i := range doubles {
+		doubles[i] = float64(i) / 3
+		names[i] = fmt.Sprint(i)
+	}
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "", struct {
+		Doubles []float64
+		Names   []string
+	}{doubles, names}); err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Doubles []float64
+		Names   []string
+	}
+	if err := UnmarshalBytes(Uncompressed, encoded.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Doubles, doubles) || !reflect.DeepEqual(result.Names, names) {
+		t.Error("Lists were not decoded correctly.")
+	}
+}
+
+func TestByteArrayDecodeReuse(t *testing.T) {
+	data := []byte{byte(TagByteArray), 0, 0, 0, 0, 0, 3, 1, 2, 3}
+
+	for mode, decode := range map[string]func(*[]byte) error{
+		"Unmarshal":      func(b *[]byte) error { return Unmarshal(Uncompressed, bytes.NewReader(data), b) },
+		"UnmarshalBytes": func(b *[]byte) error { return UnmarshalBytes(Uncompressed, data, b) },
+	} {
+		b := make([]byte, 0, 64)
+		if err := decode(&b); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if len(b) != 3 || !bytes.Equal(b, []byte{1, 2, 3}) || cap(b) != 64 {
+			t.Errorf("%s: Found %v with length %d and capacity %d.", mode, b, len(b), cap(b))
+		}
+
+		// A longer slice is shortened, not left at its old length.
+		b = b[:10]
+		if err := decode(&b); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if len(b) != 3 {
+			t.Errorf("%s: Found %v with length %d.", mode, b, len(b))
+		}
+	}
+}
+
+func TestListDecodeReuse(t *testing.T) {
+	data := []byte{
+		byte(TagList), 0, 0, byte(TagCompound), 0, 0, 0, 1, // TAG_List "": 1 TAG_Compound
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 1, 'x', // TAG_String "name": "x"
+		byte(TagEnd),
+	}
+
+	backing := []Server{{Name: "old", IP: "127.0.0.1"}, {Name: "older"}}
+	servers := backing[:0]
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &servers); err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0] != (Server{Name: "x"}) {
+		t.Errorf("Found %#v.", servers)
+	}
+
+	ints := make([]int32, 0, 8)
+	data = []byte{byte(TagList), 0, 0, byte(TagInt), 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &ints); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ints, []int32{1, 2}) || cap(ints) != 8 {
+		t.Errorf("Found %v with capacity %d.", ints, cap(ints))
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, name := range []string{"bigtest.nbt", "servers.dat", "Nightgunner5.dat"} {
+		in, err := os.Open(filepath.Join("testcases", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		n, err := Parse(AutoDetect, in)
+		in.Close()
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, order := range []ByteOrder{BigEndian, LittleEndian} {
+			var buf bytes.Buffer
+			enc := NewEncoder(Uncompressed, &buf)
+			enc.SetByteOrder(order)
+			if err := enc.EncodeNode(n.Name, n); err != nil {
+				f.Fatal(err)
+			}
+			f.Add(uint8(order), buf.Bytes())
+		}
+	}
+	f.Add(uint8(BigEndian), []byte{byte(TagCompound), 0, 0, byte(TagEnd)})
+	f.Add(uint8(BigEndian), []byte{byte(TagList), 0, 0, byte(TagInt), 0, 0, 0, 1, 0, 0, 0, 7})
+	f.Add(uint8(BigEndian), []byte{byte(TagString), 0, 1, 'a', 0, 2, 0xc0, 0x80})
+	f.Add(uint8(NetworkLittleEndian), []byte{
+		byte(TagCompound), 0,
+		byte(TagInt), 1, 'v', 0xdf, 0xc5, 0x08,
+		byte(TagString), 1, 'n', 5, 'S', 't', 'e', 'v', 'e',
+		byte(TagList), 1, 'l', byte(TagInt), 0x04, 0x01, 0xe0, 0xc5, 0x08,
+		byte(TagEnd),
+	})
+	f.Add(uint8(NetworkLittleEndian), []byte{byte(TagString), 0xfe, 0xff, 0xff, 0xff, 0x0f})
+
+	f.Fuzz(func(t *testing.T, order uint8, data []byte) {
+		// Reading from an io.Reader and from memory must agree exactly.
+		dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+		dec.SetByteOrder(ByteOrder(order % 3))
+		var fromReader map[string]interface{}
+		readerErr := dec.Decode(&fromReader)
+
+		d := decodeState{maxDepth: DefaultMaxDepth, order: ByteOrder(order % 3)}
+		if err := d.initBytes(Uncompressed, data); err != nil {
+			t.Fatal(err)
+		}
+		var fromBytes map[string]interface{}
+		bytesErr := d.offsetError(d.unmarshal(&fromBytes))
+
+		if fmt.Sprint(readerErr) != fmt.Sprint(bytesErr) {
+			t.Fatalf("Reading from an io.Reader gave %v, but from memory gave %v.", readerErr, bytesErr)
+		}
+		// %#v prints NaN, which DeepEqual would find unequal to itself.
+		if a, b := fmt.Sprintf("%#v", fromReader), fmt.Sprintf("%#v", fromBytes); a != b {
+			t.Fatalf("Reading from an io.Reader gave %s, but from memory gave %s.", a, b)
+		}
+	})
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	data := []byte{byte(TagCompound), 0, 0, byte(TagShort), 0, 1, 'S', 0, 7, byte(TagEnd)}
+	var v struct {
+		S string
+	}
+	err := UnmarshalBytes(Uncompressed, data, &v)
+	var unsupported *UnsupportedTypeError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Expected an UnsupportedTypeError, but got %v.", err)
+	}
+	if unsupported.Tag != TagShort || unsupported.Type != reflect.TypeOf("") {
+		t.Errorf("Error is for %s and %v.", unsupported.Tag, unsupported.Type)
+	}
+
+	_, err = MarshalBytes(Uncompressed, "", struct{ N int }{})
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Expected an UnsupportedTypeError, but got %v.", err)
+	}
+	if unsupported.Tag != TagEnd || unsupported.Type != reflect.TypeOf(0) {
+		t.Errorf("Error is for %s and %v.", unsupported.Tag, unsupported.Type)
+	}
+}
+
+func BenchmarkDecodeStructList(b *testing.B) {
+	list := make([]ItemStack, 10000)
+	for i := range list {
+		list[i] = ItemStack{ID: "minecraft:stone", Count: int8(i % 64), Slot: uint8(i % 36)}
+	}
+	data, err := MarshalBytes(Uncompressed, "", map[string]interface{}{"Items": list})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var inventory struct {
+			Items []ItemStack
+		}
+		if err := UnmarshalBytes(Uncompressed, data, &inventory); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type poolSection struct {
+	Y           int8
+	BlockStates []int64
+	Palette     []struct{ Name string }
+	Properties  map[string]string
+	Lit         *int8
+}
+
+type poolChunk struct {
+	Sections []poolSection
+}
+
+func poolChunkData(tb testing.TB) []byte {
+	var c poolChunk
+	for y := -4; y < 20; y++ {
+		lit := int8(1)
+		c.Sections = append(c.Sections, poolSection{
+			Y:           int8(y),
+			BlockStates: make([]int64, 256),
+			Palette:     []struct{ Name string }{{"minecraft:air"}, {"minecraft:stone"}},
+			Properties:  map[string]string{"biome": "plains"},
+			Lit:         &lit,
+		})
+	}
+	data, err := MarshalBytes(Uncompressed, "", c)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
+
+func TestListElementReuse(t *testing.T) {
+	data := poolChunkData(t)
+	var c poolChunk
+	if err := UnmarshalBytes(Uncompressed, data, &c); err != nil {
+		t.Fatal(err)
+	}
+	states := &c.Sections[3].BlockStates[0]
+	palette := &c.Sections[3].Palette[0]
+
+	// What is left in the elements of a slice that is reused must not show,
+	// but their own slices are filled again rather than replaced.
+	c.Sections[3].Y = 100
+	c.Sections[3].Palette[1].Name = "old"
+	c.Sections[3].Properties["old"] = "yes"
+	c.Sections[5] = poolSection{Y: 5, BlockStates: make([]int64, 0, 512)}
+	if err := UnmarshalBytes(Uncompressed, data, &c); err != nil {
+		t.Fatal(err)
+	}
+	if &c.Sections[3].BlockStates[0] != states || &c.Sections[3].Palette[0] != palette {
+		t.Error("The slices of a reused element were replaced.")
+	}
+	if cap(c.Sections[5].BlockStates) != 512 {
+		t.Errorf("Block states have capacity %d, but expected 512.", cap(c.Sections[5].BlockStates))
+	}
+
+	var fresh poolChunk
+	if err := UnmarshalBytes(Uncompressed, data, &fresh); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, fresh) {
+		t.Errorf("Decoded %#v after reuse, but expected %#v.", c.Sections[3], fresh.Sections[3])
+	}
+
+	// Fields that are missing are cleared, including pointers.
+	data, err := MarshalBytes(Uncompressed, "", map[string]interface{}{
+		"Sections": []map[string]int8{{"Y": 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmarshalBytes(Uncompressed, data, &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Sections) != 1 || c.Sections[0].Y != 1 || len(c.Sections[0].BlockStates) != 0 || len(c.Sections[0].Palette) != 0 || len(c.Sections[0].Properties) != 0 || c.Sections[0].Lit != nil {
+		t.Errorf("Found %#v.", c.Sections)
+	}
+}
+
+func BenchmarkDecodePooledSlice(b *testing.B) {
+	data := poolChunkData(b)
+	var c poolChunk
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := UnmarshalBytes(Uncompressed, data, &c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeFreshSlice(b *testing.B) {
+	data := poolChunkData(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var c poolChunk
+		if err := UnmarshalBytes(Uncompressed, data, &c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEmptyCompoundsInList(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 5, 'I', 't', 'e', 'm', 's', byte(TagCompound), 0, 0, 0, 4,
+		byte(TagEnd),
+		byte(TagString), 0, 2, 'i', 'd', 0, 1, 'a', byte(TagByte), 0, 5, 'C', 'o', 'u', 'n', 't', 3, byte(TagEnd),
+		byte(TagEnd),
+		byte(TagByte), 0, 5, 'C', 'o', 'u', 'n', 't', 9, byte(TagEnd),
+		byte(TagInt), 0, 5, 'A', 'f', 't', 'e', 'r', 0, 0, 0, 42,
+		byte(TagEnd),
+	}
+
+	var v struct {
+		Items []ItemStack
+		After int32
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ItemStack{{}, {ID: "a", Count: 3}, {}, {Count: 9}}
+	if !reflect.DeepEqual(v.Items, expected) || v.After != 42 {
+		t.Errorf("Found %#v and %d, but expected %#v and 42.", v.Items, v.After, expected)
+	}
+
+	// Empty compounds must not keep what was in a reused slice.
+	v.Items = []ItemStack{{ID: "old", Count: 1}, {}, {ID: "old", Slot: 2}, {}}
+	if err := UnmarshalBytes(Uncompressed, data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Items, expected) {
+		t.Errorf("Found %#v after reuse, but expected %#v.", v.Items, expected)
+	}
+
+	var m map[string]interface{}
+	if err := UnmarshalBytes(Uncompressed, data, &m); err != nil {
+		t.Fatal(err)
+	}
+	items := m["Items"].([]interface{})
+	if len(items) != 4 || len(items[0].(map[string]interface{})) != 0 || len(items[2].(map[string]interface{})) != 0 || m["After"] != int32(42) {
+		t.Errorf("Found %#v.", m)
+	}
+
+	n, err := Parse(Uncompressed, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := n.Get("Items")
+	if list.Len() != 4 || list.Index(0).Len() != 0 || list.Index(1).Len() != 2 {
+		t.Errorf("Items is %#v.", list)
+	}
+	if after, _ := n.Get("After"); after.Int() != 42 {
+		t.Errorf("After is %#v.", after)
+	}
+}
+
+func TestTypedScalars(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagByte), 0, 1, 'b', 20,
+		byte(TagDouble), 0, 1, 'd', 0x3f, 0xf0, 0, 0, 0, 0, 0, 0,
+		byte(TagFloat), 0, 1, 'f', 0x3f, 0x80, 0, 0,
+		byte(TagInt), 0, 1, 'i', 0, 0, 0, 20,
+		byte(TagList), 0, 1, 'l', byte(TagShort), 0, 0, 0, 2, 0, 1, 0, 2,
+		byte(TagLong), 0, 1, 'n', 0, 0, 0, 0, 0, 0, 0, 20,
+		byte(TagEnd),
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.TypedScalars(true)
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"b": Byte(20),
+		"d": Double(1),
+		"f": Float(1),
+		"i": Int(20),
+		"l": []interface{}{Short(1), Short(2)},
+		"n": Long(20),
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", m, expected)
+	}
+
+	encoded, err := MarshalBytes(Uncompressed, "", m)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(encoded, data) {
+		t.Errorf("Encoded %v, but expected %v.", encoded, data)
+	}
+
+	// Typed fields are unaffected.
+	dec = NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.TypedScalars(true)
+	var v struct {
+		B int8        `nbt:"b"`
+		I interface{} `nbt:"i"`
+	}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	} else if v.B != 20 || v.I != Int(20) {
+		t.Errorf("Decoded %#v.", v)
+	}
+}
+
+func TestDecoderMore(t *testing.T) {
+	for _, compression := range []Compression{Uncompressed, GZip} {
+		var stream []byte
+		for i := int32(1); i <= 3; i++ {
+			data, err := MarshalBytes(compression, "", map[string]int32{"N": i})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream = append(stream, data...)
+		}
+
+		dec := NewDecoder(compression, bytes.NewReader(stream))
+		var found []int32
+		for dec.More() {
+			var v struct{ N int32 }
+			if err := dec.Decode(&v); err != nil {
+				t.Fatalf("%v: %v", compression, err)
+			}
+			found = append(found, v.N)
+		}
+		if !reflect.DeepEqual(found, []int32{1, 2, 3}) {
+			t.Errorf("%v: Decoded %v, but expected [1 2 3].", compression, found)
+		}
+		var v interface{}
+		if err := dec.Decode(&v); err != io.EOF {
+			t.Errorf("%v: Expected io.EOF after the last document, but got %v.", compression, err)
+		}
+	}
+
+	for _, compression := range []Compression{Uncompressed, GZip, AutoDetect} {
+		if NewDecoder(compression, bytes.NewReader(nil)).More() {
+			t.Errorf("%v: More is true for empty input.", compression)
+		}
+	}
+
+	// A broken gzip member is left for Decode to report.
+	data, err := MarshalBytes(GZip, "", map[string]int32{"N": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecoder(GZip, bytes.NewReader(append(data, "not gzip"...)))
+	var v interface{}
+	if !dec.More() || dec.Decode(&v) != nil {
+		t.Fatal("Could not decode the first document.")
+	}
+	if !dec.More() {
+		t.Error("More is false for a broken gzip member.")
+	} else if err := dec.Decode(&v); err == nil || err == io.EOF {
+		t.Errorf("Expected an error for a broken gzip member, but got %v.", err)
+	}
+}
+
+type EntityCommon struct {
+	ID     string `nbt:"id"`
+	Health float32
+	Name   string // Hidden by EmbeddingEntity.Name.
+}
+
+type EntityMotion struct {
+	Motion []float64
+	Health int16 // Ties with EntityCommon.Health, so neither is used...
+}
+
+type EntityTagged struct {
+	Tagged int8 `nbt:"Motion2"`
+	Color  int8 `nbt:"Color"` // ...but a tagged field wins a tie.
+}
+
+type EntityColor struct {
+	Color string
+}
+
+type EmbeddingEntity struct {
+	EntityCommon
+	EntityMotion
+	EntityTagged
+	EntityColor
+	Name string
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagString), 0, 2, 'i', 'd', 0, 3, 'p', 'i', 'g',
+		byte(TagFloat), 0, 6, 'H', 'e', 'a', 'l', 't', 'h', 0x41, 0x20, 0, 0,
+		byte(TagString), 0, 4, 'N', 'a', 'm', 'e', 0, 3, 'B', 'o', 'b',
+		byte(TagList), 0, 6, 'M', 'o', 't', 'i', 'o', 'n', byte(TagDouble), 0, 0, 0, 1, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0,
+		byte(TagByte), 0, 5, 'C', 'o', 'l', 'o', 'r', 3,
+		byte(TagEnd),
+	}
+
+	var entity EmbeddingEntity
+	if err := UnmarshalBytes(Uncompressed, data, &entity); err != nil {
+		t.Fatal(err)
+	}
+	expected := EmbeddingEntity{
+		EntityCommon: EntityCommon{ID: "pig"},
+		EntityMotion: EntityMotion{Motion: []float64{1}},
+		EntityTagged: EntityTagged{Color: 3},
+		Name:         "Bob",
+	}
+	if !reflect.DeepEqual(entity, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", entity, expected)
+	}
+
+	// Health is left out, as is EntityColor.Color and EntityCommon.Name.
+	names := make([]string, 0)
+	for _, f := range structFields(reflect.TypeOf(entity)) {
+		names = append(names, f.name)
+	}
+	if expected := []string{"id", "Motion", "Motion2", "Color", "Name"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Fields are %q, but expected %q.", names, expected)
+	}
+
+	encoded, err := MarshalBytes(Uncompressed, "", entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result EmbeddingEntity
+	if err := UnmarshalBytes(Uncompressed, encoded, &result); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, entity) {
+		t.Errorf("Round trip gave %#v, but expected %#v.", result, entity)
+	}
+}
+
+func TestUnmatchedCompound(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 4, 'L', 'i', 's', 't', byte(TagCompound), 0, 0, 0, 2,
+		byte(TagString), 0, 1, 's', 0, 2, 'h', 'i',
+		byte(TagCompound), 0, 1, 'c', byte(TagIntArray), 0, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 5, byte(TagEnd),
+		byte(TagEnd),
+		byte(TagList), 0, 1, 'l', byte(TagLong), 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1,
+		byte(TagEnd),
+		byte(TagInt), 0, 5, 'A', 'f', 't', 'e', 'r', 0, 0, 0, 7,
+		byte(TagEnd),
+	}
+
+	var empty struct {
+		List  []struct{}
+		After int32
+	}
+	if err := UnmarshalBytes(Uncompressed, data, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if len(empty.List) != 2 || empty.After != 7 {
+		t.Errorf("Decoded %#v.", empty)
+	}
+
+	var hidden struct {
+		List []struct {
+			S string  `nbt:"-"`
+			L []int64 `nbt:"-"`
+		}
+		After int32
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &hidden); err != nil {
+		t.Fatal(err)
+	}
+	if len(hidden.List) != 2 || hidden.List[0].S != "" || hidden.List[1].L != nil || hidden.After != 7 {
+		t.Errorf("Decoded %#v.", hidden)
+	}
+
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.DisallowUnknownFields(true)
+	if err := dec.Decode(&empty); err == nil {
+		t.Error("No error with unknown fields disallowed, but one was expected!")
+	}
+}
+
+func TestBoolArray(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagByteArray), 0, 5, 'F', 'l', 'a', 'g', 's', 0, 0, 0, 4, 0, 1, 2, 0,
+		byte(TagEnd),
+	}
+	expected := []bool{false, true, true, false}
+
+	var v struct {
+		Flags []bool
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v.Flags, expected) {
+		t.Errorf("Decoded %v, but expected %v.", v.Flags, expected)
+	}
+
+	// A slice that is big enough is reused, and one that isn't is replaced.
+	for _, old := range [][]bool{{true, true, true, true, true}, {true}} {
+		v.Flags = old
+		if err := UnmarshalBytes(Uncompressed, data, &v); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(v.Flags, expected) {
+			t.Errorf("Decoded %v over %v, but expected %v.", v.Flags, old, expected)
+		}
+		if len(old) >= len(expected) && &v.Flags[0] != &old[0] {
+			t.Error("The slice was not reused.")
+		}
+	}
+
+	var array struct {
+		Flags [5]bool
+	}
+	array.Flags[4] = true
+	if err := UnmarshalBytes(Uncompressed, data, &array); err != nil {
+		t.Fatal(err)
+	} else if array.Flags != [5]bool{false, true, true, false, true} {
+		t.Errorf("Decoded %v.", array.Flags)
+	}
+}
+
+func TestInvalidUnmarshal(t *testing.T) {
+	data := []byte{byte(TagCompound), 0, 0, byte(TagEnd)}
+	var m map[string]interface{}
+	var nilMap *map[string]interface{}
+	for _, c := range []struct {
+		v        interface{}
+		expected string
+	}{
+		{m, "nbt: Unmarshal requires a non-nil pointer, got map[string]interface {}"},
+		{nilMap, "nbt: Unmarshal requires a non-nil pointer, got nil *map[string]interface {}"},
+		{nil, "nbt: Unmarshal requires a non-nil pointer, got nil"},
+	} {
+		for _, err := range []error{
+			Unmarshal(Uncompressed, bytes.NewReader(data), c.v),
+			UnmarshalBytes(Uncompressed, data, c.v),
+		} {
+			var invalid *InvalidUnmarshalError
+			if !errors.As(err, &invalid) || invalid.Type != reflect.TypeOf(c.v) {
+				t.Errorf("Expected an InvalidUnmarshalError for %T, but got %v.", c.v, err)
+			} else if err.Error() != c.expected {
+				t.Errorf("Expected %q, but got %q.", c.expected, err)
+			}
+		}
+	}
+
+	// Nothing is read, so the Decoder can still be used.
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	if err := dec.Decode(m); err == nil {
+		t.Error("No error for a map, but one was expected!")
+	}
+	if err := dec.Decode(&m); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWidenFloats(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagFloat), 0, 1, 'F', 0x3e, 0xaa, 0xaa, 0xab, // float32(1.0 / 3)
+		byte(TagDouble), 0, 1, 'D', 0x3f, 0xd5, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, // 1.0 / 3
+		byte(TagList), 0, 1, 'L', byte(TagFloat), 0, 0, 0, 1, 0x3f, 0xc0, 0, 0, // [1.5]
+		byte(TagEnd),
+	}
+	type Wide struct {
+		F float64
+		L []float64
+	}
+	type Narrow struct {
+		D float32
+	}
+
+	var wide Wide
+	if err := UnmarshalBytes(Uncompressed, data, &wide); err == nil {
+		t.Error("No error for a TAG_Float in a float64 without WidenFloats, but one was expected!")
+	}
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.WidenFloats(true)
+	if err := dec.Decode(&wide); err != nil {
+		t.Fatal(err)
+	}
+	if wide.F != float64(float32(1.0/3)) || !reflect.DeepEqual(wide.L, []float64{1.5}) {
+		t.Errorf("Decoded %#v.", wide)
+	}
+
+	// Widening floats does not allow doubles to be narrowed.
+	var narrow Narrow
+	dec = NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.WidenFloats(true)
+	if err := dec.Decode(&narrow); err == nil {
+		t.Error("No error for a TAG_Double in a float32 without NarrowFloats, but one was expected!")
+	}
+	dec = NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.NarrowFloats(true)
+	if err := dec.Decode(&narrow); err != nil {
+		t.Fatal(err)
+	} else if narrow.D != float32(1.0/3) {
+		t.Errorf("Decoded %#v.", narrow)
+	}
+
+	huge := []byte{byte(TagDouble), 0, 0, 0x7f, 0xef, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	var f float32
+	dec = NewDecoder(Uncompressed, bytes.NewReader(huge))
+	dec.NarrowFloats(true)
+	if err := dec.Decode(&f); err == nil || err.Error() != "nbt: at offset 11: value 1.7976931348623157e+308 out of range for float32" {
+		t.Errorf("Expected an out of range error, but got %v.", err)
+	}
+}
+
+func TestStrictBools(t *testing.T) {
+	type Entity struct {
+		OnGround bool
+		Flags    []bool
+	}
+	data := mustMarshal(t, Uncompressed, "", map[string]interface{}{
+		"OnGround": int8(2),
+		"Flags":    []int8{0, 1},
+	})
+
+	var e Entity
+	if err := UnmarshalBytes(Uncompressed, data, &e); err != nil {
+		t.Fatal(err)
+	} else if !e.OnGround {
+		t.Errorf("Decoded %#v.", e)
+	}
+
+	e = Entity{}
+	dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.StrictBools(true)
+	if err := dec.Decode(&e); err == nil || err.Error() != "nbt: at offset 30: TAG_Byte 2 is not a valid bool\n\t\tat struct field \"OnGround\"" {
+		t.Errorf("Expected an invalid bool error, but got %v.", err)
+	}
+
+	data = mustMarshal(t, Uncompressed, "", map[string]interface{}{
+		"OnGround": int8(1),
+		"Flags":    []int8{0, 1, -1},
+	})
+	dec = NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.StrictBools(true)
+	if err := dec.Decode(&e); err == nil || !strings.HasSuffix(err.Error(), ": TAG_Byte -1 is not a valid bool\n\t\tat list index 2\n\t\tat struct field \"Flags\"") {
+		t.Errorf("Expected an invalid bool error, but got %v.", err)
+	}
+
+	data = mustMarshal(t, Uncompressed, "", map[string]interface{}{
+		"OnGround": int8(0),
+		"Flags":    []int8{0, 1, 1},
+	})
+	dec = NewDecoder(Uncompressed, bytes.NewReader(data))
+	dec.StrictBools(true)
+	if err := dec.Decode(&e); err != nil {
+		t.Fatal(err)
+	} else if e.OnGround || !reflect.DeepEqual(e.Flags, []bool{false, true, true}) {
+		t.Errorf("Decoded %#v.", e)
+	}
+}
+
+// Fails to read anything before start.
+type guardedReaderAt struct {
+	*bytes.Reader
+	start int64
+}
+
+func (r guardedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.start {
+		return 0, fmt.Errorf("read at %d, before %d", off, r.start)
+	}
+	return r.Reader.ReadAt(p, off)
+}
+
+func TestUnmarshalAt(t *testing.T) {
+	type Chunk struct {
+		DataVersion int32
+		Status      string
+	}
+	chunk := Chunk{3465, "minecraft:full"}
+
+	for _, compression := range []Compression{Uncompressed, GZip, ZLib} {
+		data := mustMarshal(t, compression, "", chunk)
+		file := append(bytes.Repeat([]byte{0xff}, 4096), data...)
+		file = append(file, "trailing"...)
+		r := guardedReaderAt{bytes.NewReader(file), 4096}
+
+		var decoded Chunk
+		if err := UnmarshalAt(compression, r, 4096, &decoded); err != nil {
+			t.Errorf("%v: %v", compression, err)
+		} else if decoded != chunk {
+			t.Errorf("%v: Decoded %#v, but expected %#v.", compression, decoded, chunk)
+		}
+	}
+
+	// Offsets in errors are from the start of the document.
+	data := mustMarshal(t, Uncompressed, "", chunk)
+	file := append(bytes.Repeat([]byte{0xff}, 100), data[:20]...)
+	var decoded Chunk
+	err := UnmarshalAt(Uncompressed, bytes.NewReader(file), 100, &decoded)
+	if err == nil || !strings.HasPrefix(err.Error(), "nbt: at offset 20: ") {
+		t.Errorf("Expected an error at offset 20, but got %v.", err)
+	}
+}
+
+func TestDecodeField(t *testing.T) {
+	data, err := MarshalBytes(Uncompressed, "", struct {
+		Sections    []int64
+		DataVersion int32
+		Level       map[string]string
+	}{make([]int64, 10000), 3465, map[string]string{"Status": "full"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Anything after the field is never read.
+	data = append(data[:len(data)-20:len(data)-20], "garbage"...)
+
+	var version int32
+	if err := DecodeField(Uncompressed, bytes.NewReader(data), "DataVersion", &version); err != nil {
+		t.Fatal(err)
+	} else if version != 3465 {
+		t.Errorf("DataVersion is %d, but expected 3465.", version)
+	}
+
+	small := []byte{byte(TagCompound), 0, 0, byte(TagByte), 0, 1, 'b', 1, byte(TagEnd)}
+	err = DecodeField(Uncompressed, bytes.NewReader(small), "DataVersion", &version)
+	if !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("Expected ErrFieldNotFound, but got %v.", err)
+	}
+	if err := DecodeField(Uncompressed, bytes.NewReader(small), "b", &version); err == nil {
+		t.Error("No error for a TAG_Byte in an int32, but one was expected!")
+	}
+	if err := DecodeField(Uncompressed, bytes.NewReader([]byte{byte(TagInt), 0, 0, 0, 0, 0, 1}), "b", &version); err == nil {
+		t.Error("No error for a root TAG_Int, but one was expected!")
+	}
+}
+
+func TestReadDataVersion(t *testing.T) {
+	type Section struct {
+		Y           int8
+		BlockStates struct {
+			Palette []struct{ Name string }
+			Data    []int64 `nbt:"data"`
+		} `nbt:"block_states"`
+	}
+	data := mustMarshal(t, ZLib, "", struct {
+		Status      string
+		XPos        int32     `nbt:"xPos"`
+		ZPos        int32     `nbt:"zPos"`
+		Sections    []Section `nbt:"sections"`
+		DataVersion int32
+	}{"minecraft:full", 3, -7, make([]Section, 24), 3465})
+
+	version, err := ReadDataVersion(ZLib, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 3465 {
+		t.Errorf("Read DataVersion %d, but expected 3465.", version)
+	}
+
+	// Chunks from before 1.9 have only a Level compound.
+	data = mustMarshal(t, ZLib, "", struct {
+		Level struct {
+			XPos int32 `nbt:"xPos"`
+			ZPos int32 `nbt:"zPos"`
+		}
+	}{})
+	if _, err := ReadDataVersion(ZLib, bytes.NewReader(data)); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("Expected ErrFieldNotFound, but got %v.", err)
+	}
+}
+
+func TestNestedLists(t *testing.T) {
+	// A TAG_List of TAG_List, with an empty inner list written the way
+	// Minecraft writes it, with TAG_End as the element type.
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 1, 'l', byte(TagList), 0, 0, 0, 4,
+		byte(TagInt), 0, 0, 0, 3, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3,
+		byte(TagEnd), 0, 0, 0, 0,
+		byte(TagInt), 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff,
+		byte(TagInt), 0, 0, 0, 2, 0, 0, 0, 4, 0, 0, 0, 5,
+		byte(TagEnd),
+	}
+	expected := [][]int32{{1, 2, 3}, {}, {-1}, {4, 5}}
+
+	var result struct {
+		L [][]int32 `nbt:"l"`
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.L, expected) {
+		t.Errorf("Found %v, but expected %v.", result.L, expected)
+	}
+
+	// Decoding again over slices that are longer than the new ones.
+	result.L = [][]int32{{9, 9, 9, 9}, {9, 9}, {9, 9, 9}, {9}, {9}}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.L, expected) {
+		t.Errorf("Found %v over old values, but expected %v.", result.L, expected)
+	}
+
+	var arrays struct {
+		L [4][]int32 `nbt:"l"`
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &arrays); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(arrays.L[:], expected) {
+		t.Errorf("Found %v in an array, but expected %v.", arrays.L, expected)
+	}
+
+	var buf bytes.Buffer
+	if err := Marshal(Uncompressed, &buf, "", result); err != nil {
+		t.Fatal(err)
+	}
+	var again struct {
+		L [][]int32 `nbt:"l"`
+	}
+	if err := Unmarshal(Uncompressed, &buf, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.L, expected) {
+		t.Errorf("Found %v after a round trip, but expected %v.", again.L, expected)
+	}
+}
+
+func TestUnknownTagError(t *testing.T) {
+	for _, test := range []struct {
+		data   []byte
+		offset int64
+	}{
+		{[]byte{20, 0, 0}, 0},
+		{[]byte{byte(TagCompound), 0, 0, byte(TagInt), 0, 1, 'i', 0, 0, 0, 1, 20, 0, 1, 'x', 0}, 11},
+		{[]byte{byte(TagCompound), 0, 0, byte(TagList), 0, 1, 'l', 20, 0, 0, 0, 1, 0, byte(TagEnd)}, 7},
+	} {
+		var v interface{}
+		err := Unmarshal(Uncompressed, bytes.NewReader(test.data), &v)
+		var unknown *UnknownTagError
+		if !errors.As(err, &unknown) {
+			t.Errorf("Expected an UnknownTagError for % x, but got %v.", test.data, err)
+			continue
+		}
+		if unknown.Tag != 20 || unknown.Offset != test.offset {
+			t.Errorf("Found %s at offset %d in % x, but expected Unknown(20) at offset %d.", unknown.Tag, unknown.Offset, test.data, test.offset)
+		}
+
+		if err := UnmarshalBytes(Uncompressed, test.data, &v); !errors.As(err, &unknown) || unknown.Offset != test.offset {
+			t.Errorf("UnmarshalBytes: Expected an UnknownTagError at offset %d for % x, but got %v.", test.offset, test.data, err)
+		}
+		if err := Valid(Uncompressed, bytes.NewReader(test.data)); !errors.As(err, &unknown) {
+			t.Errorf("Valid: Expected an UnknownTagError for % x, but got %v.", test.data, err)
+		}
+	}
+
+	// A type mismatch is not an unknown tag.
+	var i int32
+	err := Unmarshal(Uncompressed, bytes.NewReader([]byte{byte(TagString), 0, 0, 0, 0}), &i)
+	var unknown *UnknownTagError
+	if err == nil || errors.As(err, &unknown) {
+		t.Errorf("Expected a type mismatch, but got %v.", err)
+	}
+}
+
+type Packet struct {
+	ID      int32
+	Message string
+}
+
+func TestDecoderReset(t *testing.T) {
+	first, err := MarshalBytes(GZip, "", Packet{1, "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := MarshalBytes(GZip, "", Packet{2, "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := MarshalBytes(ZLib, "", Packet{3, "zlib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(AutoDetect, bytes.NewReader(first))
+	dec.DisallowTrailingData(true)
+	for i, test := range []struct {
+		data     []byte
+		expected Packet
+	}{
+		{first, Packet{1, "hello"}},
+		{second, Packet{2, "world"}},
+		{third, Packet{3, "zlib"}},
+		{first, Packet{1, "hello"}},
+	} {
+		decompressor := dec.state.decompressor
+		if i != 0 {
+			dec.Reset(bytes.NewReader(test.data))
+		}
+		// Only the second document has the same format as the one before.
+		if i == 1 && dec.state.decompressor != decompressor {
+			t.Errorf("Document %d: The gzip reader was not reused.", i)
+		}
+		var p Packet
+		if err := dec.Decode(&p); err != nil {
+			t.Errorf("Document %d: %v", i, err)
+		} else if p != test.expected {
+			t.Errorf("Document %d: Found %#v, but expected %#v.", i, p, test.expected)
+		}
+	}
+
+	// A bad header is returned by Decode, and the next Reset recovers.
+	dec.Reset(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0}))
+	var p Packet
+	if err := dec.Decode(&p); err == nil {
+		t.Error("No error for a bad gzip header, but one was expected!")
+	}
+	dec.Reset(bytes.NewReader(second))
+	if err := dec.Decode(&p); err != nil {
+		t.Error(err)
+	} else if p != (Packet{2, "world"}) {
+		t.Errorf("Found %#v after a bad header, but expected the second packet.", p)
+	}
+
+	// Offsets start again from zero.
+	dec.Reset(bytes.NewReader([]byte{byte(TagCompound), 0, 0, byte(TagInt), 0, 2, 'I', 'D', 0}))
+	if err := dec.Decode(&p); err == nil || !strings.HasPrefix(err.Error(), "nbt: at offset 9:") {
+		t.Errorf("Expected an error at offset 9, but got %v.", err)
+	}
+}
+
+func benchPacket(b *testing.B) []byte {
+	data, err := MarshalBytes(GZip, "", Packet{42, "The quick brown fox jumps over the lazy dog."})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkDecoderNew(b *testing.B) {
+	data := benchPacket(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var p Packet
+		if err := NewDecoder(GZip, bytes.NewReader(data)).Decode(&p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderReset(b *testing.B) {
+	data := benchPacket(b)
+	r := bytes.NewReader(data)
+	dec := NewDecoder(GZip, r)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		dec.Reset(r)
+		var p Packet
+		if err := dec.Decode(&p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type Dimension string
+
+type GameMode int32
+
+const (
+	Survival GameMode = iota
+	Creative
+	Adventure
+	Spectator
+)
+
+func (m GameMode) Valid() bool {
+	return m >= Survival && m <= Spectator
+}
+
+type Difficulty int8
+
+func (d *Difficulty) Valid() bool {
+	return *d >= 0 && *d <= 3
+}
+
+func TestEnums(t *testing.T) {
+	type Player struct {
+		Dimension  Dimension
+		GameMode   GameMode
+		Difficulty Difficulty
+		Previous   []GameMode
+		ByName     map[string]GameMode
+	}
+	expected := Player{
+		Dimension:  "minecraft:the_nether",
+		GameMode:   Creative,
+		Difficulty: 2,
+		Previous:   []GameMode{Survival, Spectator},
+		ByName:     map[string]GameMode{"Notch": Adventure},
+	}
+	data := mustMarshal(t, Uncompressed, "", expected)
+
+	var p Player
+	if err := UnmarshalBytes(Uncompressed, data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Found %#v, but expected %#v.", p, expected)
+	}
+
+	for _, test := range []struct {
+		v       interface{}
+		message string
+	}{
+		{struct{ GameMode int32 }{7}, "nbt: at offset 18: 7 is not a valid nbt.GameMode\n\t\tat struct field \"GameMode\""},
+		{struct{ Difficulty int8 }{-1}, "nbt: at offset 17: -1 is not a valid nbt.Difficulty\n\t\tat struct field \"Difficulty\""},
+		{struct{ Previous []int32 }{[]int32{1, 4}}, "nbt: at offset 27: 4 is not a valid nbt.GameMode\n\t\tat list index 1\n\t\tat struct field \"Previous\""},
+		{struct{ ByName map[string]int32 }{map[string]int32{"jeb_": -1}}, "nbt: at offset 23: -1 is not a valid nbt.GameMode\n\t\tat struct field \"jeb_\"\n\t\tat struct field \"ByName\""},
+	} {
+		var p Player
+		err := UnmarshalBytes(Uncompressed, mustMarshal(t, Uncompressed, "", test.v), &p)
+		if err == nil || err.Error() != test.message {
+			t.Errorf("Expected %q for %+v, but got %v.", test.message, test.v, err)
+		}
+	}
+
+	// A bare GameMode is checked too.
+	var mode GameMode
+	if err := UnmarshalBytes(Uncompressed, mustMarshal(t, Uncompressed, "", int32(9)), &mode); err == nil {
+		t.Error("No error for an invalid root GameMode, but one was expected!")
+	}
+
+	// So are fields with a type option, both by value and by pointer.
+	var overridden struct {
+		GameMode   GameMode    `nbt:"GameMode,type=byte"`
+		Difficulty *Difficulty `nbt:"Difficulty,type=short"`
+	}
+	data = mustMarshal(t, Uncompressed, "", struct {
+		GameMode   int8
+		Difficulty int16
+	}{9, 2})
+	if err := UnmarshalBytes(Uncompressed, data, &overridden); err == nil || err.Error() != "nbt: at offset 15: 9 is not a valid nbt.GameMode\n\t\tat struct field \"GameMode\"" {
+		t.Errorf("Expected an invalid GameMode error, but got %v.", err)
+	}
+	data = mustMarshal(t, Uncompressed, "", struct {
+		GameMode   int8
+		Difficulty int16
+	}{1, 5})
+	if err := UnmarshalBytes(Uncompressed, data, &overridden); err == nil || !strings.HasSuffix(err.Error(), ": 5 is not a valid nbt.Difficulty\n\t\tat struct field \"Difficulty\"") {
+		t.Errorf("Expected an invalid Difficulty error, but got %v.", err)
+	}
+	data = mustMarshal(t, Uncompressed, "", struct {
+		GameMode   int8
+		Difficulty int16
+	}{1, 3})
+	if err := UnmarshalBytes(Uncompressed, data, &overridden); err != nil {
+		t.Fatal(err)
+	} else if overridden.GameMode != Creative || *overridden.Difficulty != 3 {
+		t.Errorf("Decoded %#v.", overridden)
+	}
+}
+
+type BlockEntity interface {
+	BlockEntityID() string
+}
+
+type Chest struct {
+	ID    string `nbt:"id"`
+	Items []struct{ Slot int8 }
+}
+
+func (c *Chest) BlockEntityID() string { return c.ID }
+
+// A chest minecart, which is an entity with the same id as a chest.
+type ChestMinecart struct {
+	ID     string `nbt:"id"`
+	Motion []float64
+}
+
+func (c ChestMinecart) EntityID() string { return c.ID }
+
+func TestRegisterInterfaceType(t *testing.T) {
+	RegisterInterfaceType((*BlockEntity)(nil), "minecraft:chest", (*Chest)(nil))
+	RegisterInterfaceType((*Entity)(nil), "minecraft:chest", ChestMinecart{})
+	// The interface's own registration wins over a global one.
+	RegisterType("minecraft:zombie", (*Zombie)(nil))
+	RegisterInterfaceType((*BlockEntity)(nil), "minecraft:zombie", (*Chest)(nil))
+
+	data := mustMarshal(t, Uncompressed, "", map[string]interface{}{
+		"block_entities": []map[string]interface{}{
+			{"id": "minecraft:chest", "Items": []map[string]int8{{"Slot": 3}}},
+			{"id": "minecraft:zombie"},
+		},
+		"Entities": []map[string]interface{}{
+			{"id": "minecraft:chest", "Motion": []float64{0, -1, 0}},
+			{"id": "minecraft:zombie", "Health": float32(4)},
+		},
+		"Lone": map[string]interface{}{"id": "minecraft:chest"},
+	})
+
+	var chunk struct {
+		BlockEntities []BlockEntity `nbt:"block_entities,union=id"`
+		Entities      []Entity      `nbt:"Entities,union=id"`
+		Lone          BlockEntity   `nbt:",union=id"`
+	}
+	if err := UnmarshalBytes(Uncompressed, data, &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := chunk.BlockEntities[0].(*Chest); !ok || c.ID != "minecraft:chest" || len(c.Items) != 1 || c.Items[0].Slot != 3 {
+		t.Errorf("Found %#v, but expected a chest.", chunk.BlockEntities[0])
+	}
+	if _, ok := chunk.BlockEntities[1].(*Chest); !ok {
+		t.Errorf("Found %#v, but expected the zombie registered for BlockEntity.", chunk.BlockEntities[1])
+	}
+	if c, ok := chunk.Entities[0].(ChestMinecart); !ok || !reflect.DeepEqual(c.Motion, []float64{0, -1, 0}) {
+		t.Errorf("Found %#v, but expected a chest minecart.", chunk.Entities[0])
+	}
+	if z, ok := chunk.Entities[1].(*Zombie); !ok || z.Health != 4 {
+		t.Errorf("Found %#v, but expected a zombie.", chunk.Entities[1])
+	}
+	if _, ok := chunk.Lone.(*Chest); !ok {
+		t.Errorf("Found %#v, but expected a chest.", chunk.Lone)
+	}
+
+	for _, test := range []struct {
+		iface, prototype interface{}
+	}{
+		{(*Entity)(nil), (*Chest)(nil)},
+		{Entity(nil), ChestMinecart{}},
+		{(*Entity)(nil), nil},
+		{(*Entity)(nil), ArmorStand{}}, // Already registered as something else.
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("No panic registering %T for %T, but one was expected!", test.prototype, test.iface)
+				}
+			}()
+			RegisterInterfaceType(test.iface, "minecraft:chest", test.prototype)
+		}()
+	}
+}
+
+func TestDisallowDuplicateKeys(t *testing.T) {
+	duplicate := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagInt), 0, 1, 'x', 0, 0, 0, 1,
+		byte(TagInt), 0, 1, 'y', 0, 0, 0, 2,
+		byte(TagInt), 0, 1, 'x', 0, 0, 0, 3,
+		byte(TagEnd),
+	}
+	// The same names in different compounds, including the elements of a
+	// list, are fine.
+	distinct := mustMarshal(t, Uncompressed, "", struct {
+		X     int32 `nbt:"x"`
+		Inner struct {
+			X int32 `nbt:"x"`
+		}
+		List []struct {
+			X int32 `nbt:"x"`
+		}
+	}{List: make([]struct {
+		X int32 `nbt:"x"`
+	}, 3)})
+	// A duplicate in a compound that is skipped.
+	skipped := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagCompound), 0, 1, 's',
+		byte(TagByte), 0, 1, 'b', 1,
+		byte(TagByte), 0, 1, 'b', 2,
+		byte(TagEnd),
+		byte(TagEnd),
+	}
+
+	var s struct {
+		X int32 `nbt:"x"`
+		Y int32 `nbt:"y"`
+	}
+	var m map[string]int32
+	if err := Unmarshal(Uncompressed, bytes.NewReader(duplicate), &s); err != nil {
+		t.Fatal(err)
+	} else if s.X != 3 {
+		t.Errorf("Without the option, x is %d, but expected the last one.", s.X)
+	}
+	var i interface{}
+	var o OrderedCompound
+	var n Node
+	for _, v := range []interface{}{&s, &m, &i, &o, &n} {
+		decode := func(data []byte, disallow bool) error {
+			dec := NewDecoder(Uncompressed, bytes.NewReader(data))
+			dec.DisallowDuplicateKeys(disallow)
+			if n, ok := v.(*Node); ok {
+				var err error
+				*n, err = dec.DecodeNode()
+				return err
+			}
+			return dec.Decode(v)
+		}
+
+		if err := decode(duplicate, false); err != nil {
+			t.Errorf("%T: %v", v, err)
+		}
+		err := decode(duplicate, true)
+		expected := "nbt: at offset 23: Duplicate key \"x\" in compound"
+		if err == nil || err.Error() != expected {
+			t.Errorf("%T: Expected %q, but got %v.", v, expected, err)
+		}
+		if _, ok := v.(*map[string]int32); !ok {
+			if err := decode(distinct, true); err != nil {
+				t.Errorf("%T: %v", v, err)
+			}
+		}
+		if err := decode(skipped, true); err == nil {
+			t.Errorf("%T: No error for a duplicate in a skipped compound, but one was expected!", v)
+		}
+	}
+}
+
+type NestedFlags struct {
+	Invulnerable bool
+	Silent       bool
+}
+
+type EntityRotation struct {
+	Rotation []float32
+}
+
+type PointerEmbeddingEntity struct {
+	ID string `nbt:"id"`
+	*NestedFlags
+	*EntityRotation
+	*PointerEmbeddingEntity // Refers back to itself, and has no fields of its own.
+}
+
+func TestEmbeddedPointerStructs(t *testing.T) {
+	data := mustMarshal(t, Uncompressed, "", map[string]interface{}{
+		"id":     "minecraft:bat",
+		"Silent": true,
+	})
+
+	var entity PointerEmbeddingEntity
+	if err := UnmarshalBytes(Uncompressed, data, &entity); err != nil {
+		t.Fatal(err)
+	}
+	if entity.ID != "minecraft:bat" || entity.NestedFlags == nil || !entity.Silent || entity.Invulnerable {
+		t.Errorf("Decoded %#v with flags %#v.", entity, entity.NestedFlags)
+	}
+	// Nothing was read into it, so it was never allocated.
+	if entity.EntityRotation != nil {
+		t.Errorf("EntityRotation was allocated as %#v.", entity.EntityRotation)
+	}
+
+	names := make([]string, 0)
+	for _, f := range structFields(reflect.TypeOf(entity)) {
+		names = append(names, f.name)
+	}
+	if expected := []string{"id", "Invulnerable", "Silent", "Rotation"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Fields are %q, but expected %q.", names, expected)
+	}
+
+	// The nil pointer's fields are left out.
+	encoded, err := MarshalBytes(Uncompressed, "", entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := UnmarshalBytes(Uncompressed, encoded, &m); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]interface{}{"id": "minecraft:bat", "Silent": int8(1), "Invulnerable": int8(0)}; !reflect.DeepEqual(m, expected) {
+		t.Errorf("Encoded %v, but expected %v.", m, expected)
+	}
+
+	entity.EntityRotation = &EntityRotation{Rotation: []float32{90, 0}}
+	encoded, err = MarshalBytes(Uncompressed, "", entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result PointerEmbeddingEntity
+	if err := UnmarshalBytes(Uncompressed, encoded, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, entity) {
+		t.Errorf("Round trip gave %#v, but expected %#v.", result, entity)
+	}
+}
+
+func TestRangeError(t *testing.T) {
+	type Wide struct {
+		Time int64
+	}
+	type Narrow struct {
+		Time int32 `nbt:"Time,type=long"`
+	}
+
+	data, err := MarshalBytes(Uncompressed, "", Wide{-24000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var narrow Narrow
+	if err := UnmarshalBytes(Uncompressed, data, &narrow); err != nil {
+		t.Fatal(err)
+	} else if narrow.Time != -24000 {
+		t.Errorf("Decoded %#v.", narrow)
+	}
+
+	data, err = MarshalBytes(Uncompressed, "", Wide{1 << 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UnmarshalBytes(Uncompressed, data, &narrow)
+	var rangeErr *RangeError
+	if !errors.As(err, &rangeErr) || rangeErr.Value != int64(1<<40) || rangeErr.Type != reflect.TypeOf(int32(0)) {
+		t.Fatalf("Expected a *RangeError, but got %v.", err)
+	}
+	if err.Error() != "nbt: at offset 18: value 1099511627776 out of range for int32\n\t\tat struct field \"Time\"" {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
@@ -1,195 +1,486 @@
 package nbt
 
 import (
-	"compress/gzip"
-	"compress/zlib"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"sort"
 )
 
-func Marshal(compression Compression, out io.Writer, v interface{}) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if s, ok := r.(string); ok {
-				err = fmt.Errorf(s)
-			} else {
-				err = r.(error)
-			}
+// Writes v to out as an NBT root tag with the given name. The rules for
+// choosing a tag for each Go type are the same ones Unmarshal uses when
+// reading, so anything written by Marshal can be read back by Unmarshal.
+//
+// Struct fields are written in the order they are declared in, the tags of an
+// OrderedCompound in slice order, and the keys of maps in sorted order,
+// unless an Encoder is told otherwise with SortMapKeys.
+//
+// Struct fields tagged `nbt:"Name,omitempty"` are left out when they hold
+// false, 0, a nil pointer or interface, or an empty string, slice, map or
+// array, the same values encoding/json considers empty.
+//
+// A number field tagged `nbt:"Air,type=short"` is written as the given tag,
+// one of byte, short, int, long, float or double, rather than the one its Go
+// type would get, and it is an error for the value not to fit.
+//
+// A time.Time is written as a TAG_Long of milliseconds since the Unix epoch,
+// and a UUID as a TAG_Int_Array of four ints. Types that implement Marshaler
+// write themselves.
+//
+// The elements of a slice of interfaces must all have the same tag, as a
+// TAG_List can only hold one type of element; an UnsupportedValueError is
+// returned for one that doesn't.
+func Marshal(compression Compression, out io.Writer, name string, v interface{}) error {
+	enc := NewEncoder(compression, out)
+	if err := enc.Encode(name, v); err != nil {
+		enc.Close()
+		return err
+	}
+	return enc.Close()
+}
+
+// Like Marshal, but returns the output instead of writing it anywhere.
+func MarshalBytes(compression Compression, name string, v interface{}) ([]byte, error) {
+	var out bytes.Buffer
+	if err := Marshal(compression, &out, name, v); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Marshaler is implemented by types that know how to encode themselves.
+// MarshalNBT returns the type of tag to write and its payload, which is
+// everything that follows the tag's name, in big endian byte order unless the
+// Encoder was told otherwise.
+type Marshaler interface {
+	MarshalNBT() (Tag, []byte, error)
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// Returns v as a Marshaler if it is one, or if a pointer to it is one and v
+// is addressable.
+func marshalerOf(v reflect.Value) (Marshaler, bool) {
+	if v.Type().Implements(marshalerType) {
+		return v.Interface().(Marshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(marshalerType) {
+		return v.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
+// Calls MarshalNBT, checking that the tag it returns can be written.
+func marshal(m Marshaler) (Tag, []byte) {
+	tag, payload, err := m.MarshalNBT()
+	if err != nil {
+		panic(err)
+	}
+	if tag == TagEnd || tag > TagLongArray {
+		panic(fmt.Errorf("nbt: MarshalNBT of %T returned %s", m, tag))
+	}
+	return tag, payload
+}
+
+// Returns the number of bytes MarshalBytes would return for v and name with
+// no compression, without keeping the output anywhere.
+func PayloadSize(name string, v interface{}) (int, error) {
+	var size byteCounter
+	if err := NewEncoder(Uncompressed, &size).Encode(name, v); err != nil {
+		return 0, err
+	}
+	return int(size), nil
+}
+
+// A writer that throws away what it is given, counting the bytes.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// An Encoder writes NBT values to an output stream. Unlike Marshal, an
+// Encoder can write several root tags one after the other into the same
+// compressed stream.
+//
+// When writing compressed output, Close must be called once everything has
+// been written, or the end of the output will be missing.
+type Encoder struct {
+	state encodeState
+	c     io.WriteCloser
+	err   error
+}
+
+// Returns an Encoder that writes to out, compressing it if needed.
+func NewEncoder(compression Compression, out io.Writer) *Encoder {
+	enc := new(Encoder)
+	enc.state.order = binary.BigEndian
+	enc.state.sortMapKeys = true
+	enc.err = enc.init(compression, out)
+	return enc
+}
+
+// Sets whether the keys of maps are sorted before they are written, which
+// they are by default so that the same map is always written the same way.
+// Turning it off saves the sorting, at the cost of the fields of each
+// compound coming out in a different order every time. Struct fields are
+// always written in the order they are declared in.
+func (enc *Encoder) SortMapKeys(sorted bool) {
+	enc.state.sortMapKeys = sorted
+}
+
+// Sets the byte order numbers and lengths are written in. The default is
+// BigEndian. NetworkLittleEndian cannot be written, and choosing it makes
+// every later call fail.
+func (enc *Encoder) SetByteOrder(order ByteOrder) {
+	switch order {
+	case BigEndian:
+		enc.state.order = binary.BigEndian
+	case LittleEndian:
+		enc.state.order = binary.LittleEndian
+	default:
+		if enc.err == nil {
+			enc.err = fmt.Errorf("nbt: Cannot write byte order %d", order)
 		}
-	}()
+	}
+}
+
+type encodeState struct {
+	out   io.Writer
+	order binary.ByteOrder
+
+	sortMapKeys bool
+}
 
+func (enc *Encoder) init(compression Compression, out io.Writer) error {
 	if out == nil {
-		panic(fmt.Errorf("nbt: Output stream is nil"))
-	}
-
-	switch compression {
-	case Uncompressed:
-		break
-	case GZip:
-		w := gzip.NewWriter(out)
-		defer w.Close()
-		out = w
-	case ZLib:
-		w := zlib.NewWriter(out)
-		defer w.Close()
-		out = w
-	default:
-		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+		return fmt.Errorf("nbt: Output stream is nil")
+	}
+
+	c, err := newCompressor(compression, out)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		enc.state.out = out
+		return nil
 	}
+	enc.c = c
+	enc.state.out = enc.c
 
-	writeRootTag(out, reflect.ValueOf(v))
+	return nil
+}
 
+// Writes v as an NBT root tag with the given name. See Marshal for how Go
+// types are matched up with tags.
+func (enc *Encoder) Encode(name string, v interface{}) (err error) {
+	if enc.err != nil {
+		return enc.err
+	}
+	defer recoverError(&err)
+	enc.state.writeRootTag(name, reflect.ValueOf(v))
 	return
 }
 
-func writeRootTag(out io.Writer, v reflect.Value) {
-	writeTag(out, "", v)
+// Flushes and closes the compressor, if there is one. The output stream
+// itself is not closed.
+func (enc *Encoder) Close() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if enc.c == nil {
+		return nil
+	}
+	// Closing flushes whatever the compressor is still holding on to.
+	return enc.c.Close()
+}
+
+// Returned when a value can't be written because NBT has no way to hold it,
+// such as a list whose elements would need different tags.
+type UnsupportedValueError struct {
+	Value reflect.Value // The list.
+	Index int           // The first element whose tag differs from the first one's.
+	Tag   Tag           // The tag of that element.
+	Elem  Tag           // The tag of the first element.
 }
 
-func w(out io.Writer, v interface{}) {
-	err := binary.Write(out, binary.BigEndian, v)
+func (e *UnsupportedValueError) Error() string {
+	return fmt.Sprintf("nbt: List element %d is a %s, but element 0 is a %s", e.Index, e.Tag, e.Elem)
+}
+
+// Returned when a Go type can't be used: by Unmarshal when Tag can't be
+// stored in a Type, and by Marshal, which leaves Tag as TagEnd, when Type
+// has no tag to be written as.
+type UnsupportedTypeError struct {
+	Tag  Tag
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	switch {
+	case e.Type.Kind() == reflect.Int || e.Type.Kind() == reflect.Uint:
+		return "nbt: int and uint types are not supported for portability reasons. Try int32 or uint32."
+	case e.Tag == TagEnd:
+		return fmt.Sprintf("nbt: Unhandled type: %v", e.Type)
+	case e.Tag == TagCompound && e.Type.Kind() == reflect.Map:
+		return fmt.Sprintf("nbt: Tag is %s, but I don't know how to put that in a map with %s keys!", e.Tag, e.Type.Key())
+	}
+	return fmt.Sprintf("nbt: Tag is %s, but I don't know how to put that in a %s!", e.Tag, e.Type)
+}
+
+// Converts a panic from inside the encoder back into an error. Whatever the
+// panic was called with, the result is always an error.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		switch r := r.(type) {
+		case error:
+			*err = r
+		case string:
+			*err = errors.New(r)
+		default:
+			*err = fmt.Errorf("nbt: %v", r)
+		}
+	}
+}
+
+// Adds context to a value recovered from a panic, which stays an error that
+// errors.As can find if it was one to begin with.
+func annotate(r interface{}, context string) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("%w%s", err, context)
+	}
+	return fmt.Errorf("%v%s", r, context)
+}
+
+func (e *encodeState) writeRootTag(name string, v reflect.Value) {
+	e.writeTag(name, v)
+}
+
+func (e *encodeState) write(b []byte) {
+	if _, err := e.out.Write(b); err != nil {
+		panic(err)
+	}
+}
+
+func (e *encodeState) w(v interface{}) {
+	err := binary.Write(e.out, e.order, v)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func writeTag(out io.Writer, name string, v reflect.Value) {
-	v = reflect.Indirect(v)
+func (e *encodeState) writeTag(name string, v reflect.Value) {
 	defer func() {
 		if r := recover(); r != nil {
-			panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
+			panic(annotate(r, fmt.Sprintf("\n\t\tat struct field %#v", name)))
 		}
 	}()
-	for v.Kind() == reflect.Interface {
+	v = indirect(v)
+	if m, ok := marshalerOf(v); ok {
+		tag, payload := marshal(m)
+		e.w(tag)
+		e.writeValue(TagString, name)
+		e.write(payload)
+		return
+	}
+	e.w(tagOf(v))
+	e.writeValue(TagString, name)
+	e.writePayload(v)
+}
+
+// Follows pointers and interfaces to the value they hold.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			panic(fmt.Errorf("nbt: Cannot write a nil %v", v.Type()))
+		}
 		v = v.Elem()
 	}
+	return v
+}
+
+// Returns the tag v is written as, which must not be a pointer or interface.
+func tagOf(v reflect.Value) Tag {
+	switch v.Type() {
+	case timeType:
+		return TagLong
+	case uuidType:
+		return TagIntArray
+	case orderedCompoundType:
+		return TagCompound
+	case rawMessageType:
+		raw := v.Bytes()
+		if len(raw) == 0 {
+			panic(fmt.Errorf("nbt: RawMessage is empty"))
+		}
+		return Tag(raw[0])
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Uint:
+		panic(&UnsupportedTypeError{Type: v.Type()})
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return TagByte
+	case reflect.Int16, reflect.Uint16:
+		return TagShort
+	case reflect.Int32, reflect.Uint32:
+		return TagInt
+	case reflect.Int64, reflect.Uint64:
+		return TagLong
+	case reflect.Float32:
+		return TagFloat
+	case reflect.Float64:
+		return TagDouble
+	case reflect.String:
+		return TagString
+	case reflect.Array:
+		switch v.Type().Elem().Kind() {
+		case reflect.Uint8:
+			return TagByteArray
+		case reflect.Int32, reflect.Uint32:
+			return TagIntArray
+		case reflect.Int64, reflect.Uint64:
+			return TagLongArray
+		}
+		panic(&UnsupportedTypeError{Type: v.Type()})
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return TagByteArray
+		}
+		return TagList
+	case reflect.Map:
+		// Names are strings, as they are when decoding.
+		if v.Type().Key().Kind() != reflect.String {
+			panic(&UnsupportedTypeError{Type: v.Type()})
+		}
+		return TagCompound
+	case reflect.Struct:
+		return TagCompound
+	}
+	panic(&UnsupportedTypeError{Type: v.Type()})
+}
+
+// Writes the payload of v, which must not be a pointer or interface, as the
+// tag tagOf gives it.
+func (e *encodeState) writePayload(v reflect.Value) {
+	switch v.Type() {
+	case timeType:
+		e.writeTime(v)
+		return
+	case uuidType:
+		e.writeUUID(v)
+		return
+	case orderedCompoundType:
+		e.writeOrderedCompound(v)
+		return
+	case rawMessageType:
+		_, err := e.out.Write(v.Bytes()[1:])
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
 	switch v.Kind() {
 	case reflect.Bool:
-		w(out, tagByte)
-		writeValue(out, tagString, name)
 		if v.Bool() {
-			writeValue(out, tagByte, byte(1))
+			e.writeValue(TagByte, byte(1))
 		} else {
-			writeValue(out, tagByte, byte(0))
+			e.writeValue(TagByte, byte(0))
 		}
 
 	case reflect.Int8:
-		w(out, tagByte)
-		writeValue(out, tagString, name)
-		writeValue(out, tagByte, int8(v.Int()))
+		e.writeValue(TagByte, int8(v.Int()))
 
 	case reflect.Uint8:
-		w(out, tagByte)
-		writeValue(out, tagString, name)
-		writeValue(out, tagByte, uint8(v.Uint()))
+		e.writeValue(TagByte, uint8(v.Uint()))
 
 	case reflect.Int16:
-		w(out, tagShort)
-		writeValue(out, tagString, name)
-		writeValue(out, tagShort, int16(v.Int()))
+		e.writeValue(TagShort, int16(v.Int()))
 
 	case reflect.Uint16:
-		w(out, tagShort)
-		writeValue(out, tagString, name)
-		writeValue(out, tagShort, uint16(v.Uint()))
+		e.writeValue(TagShort, uint16(v.Uint()))
 
 	case reflect.Int32:
-		w(out, tagInt)
-		writeValue(out, tagString, name)
-		writeValue(out, tagInt, int32(v.Int()))
+		e.writeValue(TagInt, int32(v.Int()))
 
 	case reflect.Uint32:
-		w(out, tagInt)
-		writeValue(out, tagString, name)
-		writeValue(out, tagInt, uint32(v.Uint()))
+		e.writeValue(TagInt, uint32(v.Uint()))
 
 	case reflect.Int64:
-		w(out, tagLong)
-		writeValue(out, tagString, name)
-		writeValue(out, tagLong, v.Int())
+		e.writeValue(TagLong, v.Int())
 
 	case reflect.Uint64:
-		w(out, tagLong)
-		writeValue(out, tagString, name)
-		writeValue(out, tagLong, v.Uint())
+		e.writeValue(TagLong, v.Uint())
 
 	case reflect.Float32:
-		w(out, tagFloat)
-		writeValue(out, tagString, name)
-		writeValue(out, tagFloat, float32(v.Float()))
+		e.writeValue(TagFloat, float32(v.Float()))
 
 	case reflect.Float64:
-		w(out, tagDouble)
-		writeValue(out, tagString, name)
-		writeValue(out, tagDouble, v.Float())
+		e.writeValue(TagDouble, v.Float())
 
 	case reflect.String:
-		w(out, tagString)
-		writeValue(out, tagString, name)
-		writeValue(out, tagString, v.String())
+		e.writeValue(TagString, v.String())
 
 	case reflect.Array:
 		switch v.Type().Elem().Kind() {
 		case reflect.Uint8:
-			w(out, tagByteArray)
-			writeValue(out, tagString, name)
-			writeValue(out, tagByteArray, v.Slice(0, v.Len()).Bytes())
+			value := make([]byte, v.Len())
+			for i := range value {
+				value[i] = byte(v.Index(i).Uint())
+			}
+			e.writeValue(TagByteArray, value)
 
 		case reflect.Int32, reflect.Uint32:
-			w(out, tagIntArray)
-			writeValue(out, tagString, name)
+			e.w(uint32(v.Len()))
 			for i := 0; i < v.Len(); i++ {
-				writeValue(out, tagInt, v.Index(i).Interface())
+				e.writeValue(TagInt, v.Index(i).Interface())
 			}
 
 		case reflect.Int64, reflect.Uint64:
-			w(out, tagLongArray)
-			writeValue(out, tagString, name)
+			e.w(uint32(v.Len()))
 			for i := 0; i < v.Len(); i++ {
-				writeValue(out, tagLong, v.Index(i).Interface())
+				e.writeValue(TagLong, v.Index(i).Interface())
 			}
-
-		default:
-			panic(fmt.Errorf("nbt: Unhandled array type: %v", v.Type().Elem()))
 		}
 
 	case reflect.Slice:
-		w(out, tagList)
-		writeValue(out, tagString, name)
-		writeList(out, v)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeValue(TagByteArray, v.Bytes())
+			break
+		}
+		e.writeList(v)
 
 	case reflect.Map:
-		w(out, tagCompound)
-		writeValue(out, tagString, name)
-		writeMap(out, v)
+		e.writeMap(v)
 
 	case reflect.Struct:
-		w(out, tagCompound)
-		writeValue(out, tagString, name)
-		writeCompound(out, v)
-
-	default:
-		panic(fmt.Errorf("nbt: Unhandled type: %v (%v)", v.Type(), v.Interface()))
+		e.writeCompound(v)
 	}
 }
 
-func writeValue(out io.Writer, tag Tag, v interface{}) {
+func (e *encodeState) writeValue(tag Tag, v interface{}) {
 	switch tag {
-	case tagByte, tagShort, tagInt, tagLong, tagFloat, tagDouble:
-		w(out, v)
+	case TagByte, TagShort, TagInt, TagLong, TagFloat, TagDouble:
+		e.w(v)
 
-	case tagString:
-		w(out, uint16(len(v.(string))))
-		_, err := out.Write([]byte(v.(string)))
+	case TagString:
+		value := encodeMUTF8(v.(string))
+		if len(value) > math.MaxUint16 {
+			panic(fmt.Errorf("nbt: String is %d bytes long in modified UTF-8, but at most %d fit in a TAG_String", len(value), math.MaxUint16))
+		}
+		e.w(uint16(len(value)))
+		_, err := e.out.Write(value)
 		if err != nil {
 			panic(err)
 		}
 
-	case tagByteArray:
-		w(out, uint32(len(v.([]byte))))
-		_, err := out.Write(v.([]byte))
+	case TagByteArray:
+		e.w(uint32(len(v.([]byte))))
+		_, err := e.out.Write(v.([]byte))
 		if err != nil {
 			panic(err)
 		}
@@ -199,118 +490,279 @@ func writeValue(out io.Writer, tag Tag, v interface{}) {
 	}
 }
 
-func writeList(out io.Writer, v reflect.Value) {
+func (e *encodeState) writeList(v reflect.Value) {
+	if t := v.Type().Elem(); t.Kind() == reflect.Interface || t == orderedCompoundType || t == rawMessageType || t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		e.writeDynamicList(v)
+		return
+	}
+
 	var tag Tag
 	mustConvertBool := false
 	mustConvertMap := false
 	switch v.Type().Elem().Kind() {
+	case reflect.Int, reflect.Uint:
+		panic(&UnsupportedTypeError{Type: v.Type().Elem()})
+
 	case reflect.Bool:
 		mustConvertBool = true
 		fallthrough
 	case reflect.Int8, reflect.Uint8:
-		tag = tagByte
+		tag = TagByte
 
 	case reflect.Int16, reflect.Uint16:
-		tag = tagShort
+		tag = TagShort
 
 	case reflect.Int32, reflect.Uint32:
-		tag = tagInt
+		tag = TagInt
 
 	case reflect.Int64, reflect.Uint64:
-		tag = tagLong
+		tag = TagLong
 
 	case reflect.Float32:
-		tag = tagFloat
+		tag = TagFloat
 
 	case reflect.Float64:
-		tag = tagDouble
+		tag = TagDouble
 
 	case reflect.String:
-		tag = tagString
+		tag = TagString
 
 	case reflect.Array:
 		switch v.Type().Elem().Elem().Kind() {
 		case reflect.Uint8:
-			tag = tagByteArray
+			tag = TagByteArray
 
 		case reflect.Int32, reflect.Uint32:
-			tag = tagIntArray
+			tag = TagIntArray
 
 		case reflect.Int64, reflect.Uint64:
-			tag = tagLongArray
+			tag = TagLongArray
 
 		default:
-			panic(fmt.Errorf("nbt: Unhandled array type: %v", v.Type().Elem().Elem()))
+			panic(&UnsupportedTypeError{Type: v.Type().Elem()})
 		}
 
 	case reflect.Slice:
-		tag = tagList
+		tag = TagList
+		if v.Type().Elem().Elem().Kind() == reflect.Uint8 {
+			tag = TagByteArray
+		}
 
 	case reflect.Map:
+		if v.Type().Elem().Key().Kind() != reflect.String {
+			panic(&UnsupportedTypeError{Type: v.Type().Elem()})
+		}
 		mustConvertMap = true
 		fallthrough
 	case reflect.Struct:
-		tag = tagCompound
+		tag = TagCompound
 
-	case reflect.Ptr: // TODO: Is there ever a case where tagCompound would be wrong here?
-		tag = tagCompound
+	case reflect.Ptr: // TODO: Is there ever a case where TagCompound would be wrong here?
+		tag = TagCompound
 
 	default:
-		panic(fmt.Errorf("nbt: Unhandled list element type: %v", v.Type().Elem()))
+		panic(&UnsupportedTypeError{Type: v.Type().Elem()})
+	}
+	switch v.Type().Elem() {
+	case timeType:
+		tag = TagLong
+	case uuidType:
+		tag = TagIntArray
 	}
-	w(out, tag)
-	w(out, uint32(v.Len()))
+	e.w(tag)
+	e.w(uint32(v.Len()))
 
 	var i int
 	defer func() {
 		if r := recover(); r != nil {
-			panic(fmt.Errorf("%v\n\t\tat list index %d", r, i))
+			panic(annotate(r, fmt.Sprintf("\n\t\tat list index %d", i)))
 		}
 	}()
 	for i = 0; i < v.Len(); i++ {
-		if mustConvertBool {
+		if v.Type().Elem() == timeType {
+			e.writeTime(v.Index(i))
+		} else if v.Type().Elem() == uuidType {
+			e.writeUUID(v.Index(i))
+		} else if mustConvertBool {
 			if v.Index(i).Bool() {
-				writeValue(out, tagByte, uint8(1))
+				e.writeValue(TagByte, uint8(1))
 			} else {
-				writeValue(out, tagByte, uint8(0))
+				e.writeValue(TagByte, uint8(0))
 			}
-		} else if tag == tagCompound {
+		} else if tag == TagCompound {
 			if mustConvertMap {
-				writeMap(out, v.Index(i))
+				e.writeMap(v.Index(i))
 			} else {
-				writeCompound(out, reflect.Indirect(v.Index(i)))
+				e.writeCompound(reflect.Indirect(v.Index(i)))
 			}
-		} else if tag == tagList {
-			writeList(out, v.Index(i))
-		} else if tag == tagByteArray {
-			writeValue(out, tag, v.Index(i).Bytes())
-		} else if tag == tagIntArray {
+		} else if tag == TagList {
+			e.writeList(v.Index(i))
+		} else if tag == TagByteArray {
+			e.writeValue(tag, v.Index(i).Bytes())
+		} else if tag == TagIntArray {
+			e.w(uint32(v.Index(i).Len()))
 			for j := 0; j < v.Index(i).Len(); j++ {
-				writeValue(out, tagInt, v.Index(i).Index(j).Interface())
+				e.writeValue(TagInt, v.Index(i).Index(j).Interface())
 			}
-		} else if tag == tagLongArray {
+		} else if tag == TagLongArray {
+			e.w(uint32(v.Index(i).Len()))
 			for j := 0; j < v.Index(i).Len(); j++ {
-				writeValue(out, tagLong, v.Index(i).Index(j).Interface())
+				e.writeValue(TagLong, v.Index(i).Index(j).Interface())
 			}
 		} else {
-			writeValue(out, tag, v.Index(i).Interface())
+			e.writeValue(tag, v.Index(i).Interface())
+		}
+	}
+}
+
+// Writes a list whose elements are interfaces or Marshalers, taking the
+// element tag from the first element. An empty list is written as a
+// TAG_List of TAG_End.
+func (e *encodeState) writeDynamicList(v reflect.Value) {
+	elems := make([]reflect.Value, v.Len())
+	marshaled := make([]bool, v.Len())
+	payloads := make([][]byte, v.Len())
+	tag := TagEnd
+	for i := range elems {
+		elems[i] = indirect(v.Index(i))
+		var t Tag
+		if m, ok := marshalerOf(elems[i]); ok {
+			t, payloads[i] = marshal(m)
+			marshaled[i] = true
+		} else {
+			t = tagOf(elems[i])
+		}
+		if i == 0 {
+			tag = t
+		} else if t != tag {
+			panic(&UnsupportedValueError{Value: v, Index: i, Tag: t, Elem: tag})
 		}
 	}
+	e.w(tag)
+	e.w(uint32(len(elems)))
+
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, fmt.Sprintf("\n\t\tat list index %d", i)))
+		}
+	}()
+	for i = range elems {
+		if marshaled[i] {
+			e.write(payloads[i])
+		} else {
+			e.writePayload(elems[i])
+		}
+	}
+}
+
+func (e *encodeState) writeMap(v reflect.Value) {
+	e.writeMapFields(v, nil)
+	e.w(TagEnd)
 }
 
-func writeMap(out io.Writer, v reflect.Value) {
-	for _, name := range v.MapKeys() {
-		writeTag(out, name.String(), reflect.Indirect(v.MapIndex(name)))
+// Writes the entries of map v as compound fields, except for those whose
+// names are in skip.
+func (e *encodeState) writeMapFields(v reflect.Value, skip map[string]structField) {
+	names := v.MapKeys()
+	if e.sortMapKeys {
+		sort.Slice(names, func(i, j int) bool {
+			return names[i].String() < names[j].String()
+		})
+	}
+	for _, name := range names {
+		if _, ok := skip[name.String()]; ok {
+			continue
+		}
+		e.writeTag(name.String(), reflect.Indirect(v.MapIndex(name)))
 	}
-	w(out, tagEnd)
 }
 
-func writeCompound(out io.Writer, v reflect.Value) {
+// Writes the number v as a tag of the given type, for a field with a type
+// option, checking that the value fits.
+func (e *encodeState) writeOverride(name string, tag Tag, v reflect.Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, fmt.Sprintf("\n\t\tat struct field %#v", name)))
+		}
+	}()
+	v = indirect(v)
+
+	var x interface{}
+	fits := true
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if tag == TagFloat {
+			fits = math.IsInf(f, 0) || math.IsNaN(f) || math.Abs(f) <= math.MaxFloat32
+			x = float32(f)
+		} else {
+			x = f
+		}
+
+	default:
+		// Unsigned values keep their bits, as they do in a tag of their
+		// own size.
+		bits := uint(8) << (tag - TagByte)
+		var i int64
+		if v.Kind() >= reflect.Uint8 && v.Kind() <= reflect.Uint64 {
+			u := v.Uint()
+			fits = bits == 64 || u < 1<<bits
+			i = int64(u)
+		} else {
+			i = v.Int()
+			fits = i<<(64-bits)>>(64-bits) == i
+		}
+		switch tag {
+		case TagByte:
+			x = int8(i)
+		case TagShort:
+			x = int16(i)
+		case TagInt:
+			x = int32(i)
+		case TagLong:
+			x = i
+		}
+	}
+	if !fits {
+		panic(fmt.Errorf("nbt: %v does not fit in a %s", v.Interface(), tag))
+	}
+
+	e.w(tag)
+	e.writeValue(TagString, name)
+	e.writeValue(tag, x)
+}
+
+func (e *encodeState) writeCompound(v reflect.Value) {
 	v = reflect.Indirect(v)
-	fields := parseStruct(v)
 
-	for name, value := range fields {
-		writeTag(out, name, value)
+	var extra reflect.Value
+	for _, f := range structFields(v.Type()) {
+		value, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue
+		}
+		if f.extra {
+			extra = value
+			continue
+		}
+		if f.omitEmpty && isEmptyValue(value) {
+			continue
+		}
+		// A nil pointer is an optional field that isn't there.
+		if value.Kind() == reflect.Ptr && value.IsNil() {
+			continue
+		}
+		if f.tag != TagEnd {
+			e.writeOverride(f.name, f.tag, value)
+		} else {
+			e.writeTag(f.name, value)
+		}
+	}
+	// Anything in the extra field that a named field has taken the place of
+	// is left out, so that no name is written twice.
+	if extra.IsValid() {
+		e.writeMapFields(extra, structFieldsByName(v.Type()))
 	}
-	w(out, tagEnd)
+	e.w(TagEnd)
 }
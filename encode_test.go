@@ -2,8 +2,13 @@ package nbt
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -79,7 +84,7 @@ func TestEncode(t *testing.T) {
 	}
 
 	var encoded bytes.Buffer
-	err = Marshal(GZip, &encoded, reference)
+	err = Marshal(GZip, &encoded, "", reference)
 	if err != nil {
 		t.Error(err)
 	}
@@ -90,14 +95,656 @@ func TestEncode(t *testing.T) {
 		t.Error(err)
 	}
 
-	left, right := parseStruct(reflect.ValueOf(result)), parseStruct(reflect.ValueOf(reference))
-	for field, l := range left {
-		r := right[field]
+	info, err := cachedStructInfo(reflect.TypeOf(result))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range info.fields {
+		l, _ := fieldByIndex(reflect.ValueOf(result), f.index)
+		r, _ := fieldByIndex(reflect.ValueOf(reference), f.index)
 
 		if !reflect.DeepEqual(l.Interface(), r.Interface()) {
-			t.Errorf("Field %s differs:", field)
+			t.Errorf("Field %s differs:", f.name)
 			t.Logf("Found   : %#v", l)
 			t.Logf("Expected: %#v", r)
 		}
 	}
 }
+
+type RoundTrip struct {
+	Byte   int8
+	UByte  uint8
+	Short  int16
+	UShort uint16
+	Int    int32
+	UInt   uint32
+	Long   int64
+	ULong  uint64
+	Float  float32
+	Double float64
+	Bool   bool
+	String string
+
+	Bytes  [4]byte
+	Ints   [3]int32
+	Longs  [2]int64
+	List   []string
+	Nested []RoundTripNested
+	Map    map[string]interface{}
+}
+
+type RoundTripNested struct {
+	Name string `nbt:"name"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, compression := range []Compression{Uncompressed, GZip, ZLib, Zstd} {
+		reference := RoundTrip{
+			Byte:   -12,
+			UByte:  200,
+			Short:  -1234,
+			UShort: 60000,
+			Int:    -4321,
+			UInt:   4000000000,
+			Long:   -1 << 40,
+			ULong:  1 << 63,
+			Float:  0.25,
+			Double: -1e100,
+			Bool:   true,
+			String: "Hello, ☃!",
+			Bytes:  [4]byte{1, 2, 3, 4},
+			Ints:   [3]int32{5, -6, 7},
+			Longs:  [2]int64{-8, 9},
+			List:   []string{"a", "b", "c"},
+			Nested: []RoundTripNested{{Name: "first"}, {Name: "second"}},
+			Map:    map[string]interface{}{"key": "value", "number": int16(42)},
+		}
+
+		var encoded bytes.Buffer
+		err := Marshal(compression, &encoded, "root", reference)
+		if err != nil {
+			t.Error(err)
+		}
+
+		var result RoundTrip
+		err = Unmarshal(compression, &encoded, &result)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !reflect.DeepEqual(result, reference) {
+			t.Errorf("Round trip with compression %d differs:", compression)
+			t.Logf("Found   : %#v", result)
+			t.Logf("Expected: %#v", reference)
+		}
+	}
+}
+
+// A trivial codec that flips every bit, so that it is obvious whether it was
+// used.
+type flipCodec struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (f flipCodec) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0xff
+	}
+	return n, err
+}
+
+func (f flipCodec) Write(p []byte) (int, error) {
+	flipped := make([]byte, len(p))
+	for i := range p {
+		flipped[i] = p[i] ^ 0xff
+	}
+	return f.w.Write(flipped)
+}
+
+func (f flipCodec) Close() error {
+	return nil
+}
+
+func TestRegisterCompression(t *testing.T) {
+	const flip Compression = 100
+	RegisterCompression(flip, func(r io.Reader) (io.Reader, error) {
+		return flipCodec{r: r}, nil
+	}, func(w io.Writer) (io.WriteCloser, error) {
+		return flipCodec{w: w}, nil
+	})
+
+	reference := RoundTripNested{Name: "flipped"}
+	var encoded bytes.Buffer
+	if err := Marshal(flip, &encoded, "root", reference); err != nil {
+		t.Fatal(err)
+	}
+	if encoded.Bytes()[0] != ^byte(TagCompound) {
+		t.Errorf("Output starts with %#x, so it was not compressed.", encoded.Bytes()[0])
+	}
+
+	var result RoundTripNested
+	if err := Unmarshal(flip, &encoded, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != reference {
+		t.Errorf("Found %#v, but expected %#v.", result, reference)
+	}
+
+	if err := Marshal(flip+1, &encoded, "root", reference); err == nil || err.Error() != "nbt: Unknown compression type: 101" {
+		t.Errorf("Expected an unknown compression type, but got %v.", err)
+	}
+}
+
+func TestMarshalModifiedUTF8(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "\x00", "a\x00\U0001F600"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		byte(TagString), 0, 2, 0xc0, 0x80,
+		0, 9, 'a', 0xc0, 0x80, 0xed, 0xa0, 0xbd, 0xed, 0xb8, 0x80,
+	}
+	if !bytes.Equal(encoded.Bytes(), expected) {
+		t.Errorf("Found % x, but expected % x.", encoded.Bytes(), expected)
+	}
+}
+
+func TestModifiedUTF8RoundTrip(t *testing.T) {
+	type Sign struct {
+		Text  string
+		Lines []string
+		Names map[string]int8
+	}
+	// Each astral character is four bytes of UTF-8 but six of modified
+	// UTF-8, so the length prefixes differ from len.
+	reference := Sign{
+		Text:  "Steve \U0001F600\U0001F3F3\uFE0F\u200D\U0001F308",
+		Lines: []string{"\x00", "\U00010000\x00\U0010FFFF", "plain", ""},
+		Names: map[string]int8{"\U0001F480": 1, "a\x00b": 2},
+	}
+
+	data, err := MarshalBytes(Uncompressed, "\U0001F4DC", reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.IndexByte(data[3:], 0xf0) != -1 {
+		t.Errorf("Four-byte UTF-8 was written: % x", data)
+	}
+	if size, err := PayloadSize("\U0001F4DC", reference); err != nil || size != len(data) {
+		t.Errorf("PayloadSize is %d, %v, but %d bytes were written.", size, err, len(data))
+	}
+
+	var result Sign
+	name, err := NewDecoder(Uncompressed, bytes.NewReader(data)).DecodeNamed(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "\U0001F4DC" {
+		t.Errorf("Root tag is named %q.", name)
+	}
+	if !reflect.DeepEqual(result, reference) {
+		t.Errorf("Found %#v, but expected %#v.", result, reference)
+	}
+
+	n, err := Parse(Uncompressed, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := n.Get("Text")
+	if text.String() != reference.Text {
+		t.Errorf("Node holds %q, but expected %q.", text, reference.Text)
+	}
+	var again bytes.Buffer
+	if err := Write(Uncompressed, &again, n.Name, n); err != nil {
+		t.Fatal(err)
+	}
+	if again.Len() != len(data) {
+		t.Errorf("Writing the Node gave %d bytes, but expected %d.", again.Len(), len(data))
+	}
+}
+
+func TestLongString(t *testing.T) {
+	type Book struct {
+		Title string
+		Pages []string
+	}
+	// U+0000 takes two bytes, so len is one less than the encoded length.
+	for _, test := range []struct {
+		book    Book
+		message string
+	}{
+		{Book{Title: strings.Repeat("a", 65535), Pages: []string{}}, ""},
+		{Book{Pages: []string{strings.Repeat("a", 65533) + "\x00"}}, ""},
+		{Book{Title: strings.Repeat("a", 65536)}, "nbt: String is 65536 bytes long in modified UTF-8, but at most 65535 fit in a TAG_String\n\t\tat struct field \"Title\"\n\t\tat struct field \"\""},
+		{Book{Pages: []string{"", strings.Repeat("a", 65534) + "\x00"}}, "nbt: String is 65536 bytes long in modified UTF-8, but at most 65535 fit in a TAG_String\n\t\tat list index 1\n\t\tat struct field \"Pages\"\n\t\tat struct field \"\""},
+	} {
+		data, err := MarshalBytes(Uncompressed, "", test.book)
+		if test.message != "" {
+			if err == nil || err.Error() != test.message {
+				t.Errorf("Expected %q, but got %q.", test.message, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var result Book
+		if err := UnmarshalBytes(Uncompressed, data, &result); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(result, test.book) {
+			t.Error("A string at the limit did not survive a round trip.")
+		}
+	}
+}
+
+func TestMarshalBytes(t *testing.T) {
+	reference := RoundTripNested{Name: "in memory"}
+	for _, compression := range []Compression{Uncompressed, GZip, ZLib, Zstd} {
+		data, err := MarshalBytes(compression, "root", reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var result RoundTripNested
+		if err := UnmarshalBytes(compression, data, &result); err != nil {
+			t.Errorf("Compression %v: %v", compression, err)
+		} else if result != reference {
+			t.Errorf("Compression %v: Found %#v, but expected %#v.", compression, result, reference)
+		}
+	}
+
+	if _, err := MarshalBytes(Uncompressed, "", 1); err == nil {
+		t.Error("No error for an int, but one was expected!")
+	}
+}
+
+func TestMarshalSortedMap(t *testing.T) {
+	m := make(map[string]int8)
+	for _, name := range []string{"c", "a", "b", "e", "d", "f", "h", "g"} {
+		m[name] = int8(name[0])
+	}
+
+	first, err := MarshalBytes(Uncompressed, "", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		again, err := MarshalBytes(Uncompressed, "", m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, again) {
+			t.Fatalf("Marshalling the same map gave % x and then % x.", first, again)
+		}
+	}
+
+	n, err := Parse(Uncompressed, bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []byte
+	for i := 3; i < len(first)-1; i += 5 {
+		order = append(order, first[i+3])
+	}
+	if string(order) != "abcdefgh" || n.Len() != len(m) {
+		t.Errorf("Keys were written in the order %q.", order)
+	}
+
+	var out bytes.Buffer
+	enc := NewEncoder(Uncompressed, &out)
+	enc.SortMapKeys(false)
+	if err := enc.Encode("", m); err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]int8
+	if err := Unmarshal(Uncompressed, &out, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, m) {
+		t.Errorf("Found %v, but expected %v.", result, m)
+	}
+}
+
+func TestMarshalInt(t *testing.T) {
+	var encoded bytes.Buffer
+	err := Marshal(Uncompressed, &encoded, "", struct{ Value int }{42})
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: int and uint types are not supported for portability reasons. Try int32 or uint32.\n\t\tat struct field \"Value\"\n\t\tat struct field \"\"" {
+		t.Error(err)
+	}
+}
+
+func TestMarshalNonStringKeys(t *testing.T) {
+	for _, v := range []interface{}{
+		map[int32]string{1: "a", 2: "b"},
+		struct{ Scores map[int8]int32 }{map[int8]int32{1: 10}},
+		struct{ Scores []map[int8]int32 }{[]map[int8]int32{{1: 10}}},
+		struct{ Scores []interface{} }{[]interface{}{map[int8]int32{1: 10}}},
+	} {
+		_, err := MarshalBytes(Uncompressed, "", v)
+		var unsupported *UnsupportedTypeError
+		if !errors.As(err, &unsupported) || unsupported.Type.Kind() != reflect.Map {
+			t.Errorf("Encoding %T: Expected an UnsupportedTypeError for the map, but got %v.", v, err)
+		}
+	}
+}
+
+func TestRecoverError(t *testing.T) {
+	for _, p := range []interface{}{
+		errors.New("nbt: an error"),
+		"nbt: a string",
+		42,
+	} {
+		err := func() (err error) {
+			defer recoverError(&err)
+			panic(p)
+		}()
+		if err == nil {
+			t.Errorf("Panic with %#v did not produce an error.", p)
+		}
+	}
+
+	err := func() (err error) {
+		defer recoverError(&err)
+		panic(42)
+	}()
+	if err == nil || err.Error() != "nbt: 42" {
+		t.Errorf("Expected \"nbt: 42\", but got %v.", err)
+	}
+}
+
+func TestEncoderClose(t *testing.T) {
+	reference := RoundTripNested{Name: "first"}
+
+	var encoded bytes.Buffer
+	enc := NewEncoder(GZip, &encoded)
+	for i := 0; i < 3; i++ {
+		err := enc.Encode("", reference)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Without Close, the compressor is still holding on to the data.
+	var result RoundTripNested
+	err := Unmarshal(GZip, bytes.NewReader(encoded.Bytes()), &result)
+	if err == nil {
+		t.Error("No error before Close, but one was expected!")
+	}
+
+	err = enc.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	dec := NewDecoder(GZip, &encoded)
+	for i := 0; i < 3; i++ {
+		result = RoundTripNested{}
+		err = dec.Decode(&result)
+		if err != nil {
+			t.Error(err)
+		}
+		if result != reference {
+			t.Errorf("Decoded %#v, but expected %#v.", result, reference)
+		}
+	}
+}
+
+type OmitEmpty struct {
+	Name  string
+	Score int32 `nbt:"Score,omitempty"`
+}
+
+func TestOmitEmpty(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "", OmitEmpty{Name: "Steve"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagString), 0, 4, 'N', 'a', 'm', 'e', 0, 5, 'S', 't', 'e', 'v', 'e', // TAG_String "Name": "Steve"
+		byte(TagEnd),
+	}
+	if !bytes.Equal(encoded.Bytes(), expected) {
+		t.Errorf("Score of 0 was not omitted: % x", encoded.Bytes())
+	}
+
+	encoded.Reset()
+	if err := Marshal(Uncompressed, &encoded, "", OmitEmpty{Name: "Steve", Score: 5}); err != nil {
+		t.Fatal(err)
+	}
+	var result OmitEmpty
+	if err := Unmarshal(Uncompressed, &encoded, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Score != 5 {
+		t.Errorf("Score is %d, but expected 5.", result.Score)
+	}
+}
+
+func TestIsEmptyValue(t *testing.T) {
+	for _, v := range []interface{}{false, int8(0), int32(0), uint16(0), float32(0), 0.0, "", []byte(nil), []int32{}, map[string]int32{}, [0]int32{}, (*int32)(nil)} {
+		if !isEmptyValue(reflect.ValueOf(v)) {
+			t.Errorf("%#v should be empty.", v)
+		}
+	}
+	for _, v := range []interface{}{true, int8(1), float64(-1), "x", []byte{0}, [1]int32{}, new(int32), struct{}{}} {
+		if isEmptyValue(reflect.ValueOf(v)) {
+			t.Errorf("%#v should not be empty.", v)
+		}
+	}
+}
+
+func TestEncodeByteSlice(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, "", struct{ B []byte }{[]byte{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		byte(TagCompound), 0, 0, // TAG_Compound ""
+		byte(TagByteArray), 0, 1, 'B', 0, 0, 0, 2, 1, 2, // TAG_Byte_Array "B": [1, 2]
+		byte(TagEnd),
+	}
+	if !bytes.Equal(encoded.Bytes(), expected) {
+		t.Errorf("[]byte was not written as a TAG_Byte_Array: % x", encoded.Bytes())
+	}
+}
+
+func TestMarshalInterfaceList(t *testing.T) {
+	data, err := MarshalBytes(Uncompressed, "", map[string]interface{}{
+		"List":  []interface{}{int32(1), int32(2)},
+		"Empty": []interface{}{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]interface{}
+	if err := UnmarshalBytes(Uncompressed, data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := result["List"].([]interface{}); !reflect.DeepEqual(list, []interface{}{int32(1), int32(2)}) {
+		t.Errorf("List is %#v.", result["List"])
+	}
+
+	_, err = MarshalBytes(Uncompressed, "", map[string]interface{}{
+		"Mixed": []interface{}{int32(1), "two"},
+	})
+	var unsupported *UnsupportedValueError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Expected an UnsupportedValueError, but got %v.", err)
+	}
+	if unsupported.Index != 1 || unsupported.Tag != TagString || unsupported.Elem != TagInt {
+		t.Errorf("Error is for index %d, %s and %s.", unsupported.Index, unsupported.Tag, unsupported.Elem)
+	}
+}
+
+func (c Coordinates) MarshalNBT() (Tag, []byte, error) {
+	s := fmt.Sprintf("%d,%d", c.X, c.Z)
+	return TagString, append([]byte{0, byte(len(s))}, s...), nil
+}
+
+type Direction int8
+
+func (d *Direction) MarshalNBT() (Tag, []byte, error) {
+	if *d < 0 || *d > 3 {
+		return TagEnd, nil, fmt.Errorf("Direction %d is out of range", *d)
+	}
+	return TagString, append([]byte{0, 1}, "NESW"[*d]), nil
+}
+
+func TestMarshaler(t *testing.T) {
+	waypoint := Waypoint{Name: "home", Position: Coordinates{12, -5}}
+	data, err := MarshalBytes(Uncompressed, "", waypoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagString), 0, 4, 'n', 'a', 'm', 'e', 0, 4, 'h', 'o', 'm', 'e',
+		byte(TagString), 0, 3, 'p', 'o', 's', 0, 5, '1', '2', ',', '-', '5',
+		byte(TagEnd),
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Encoded %v, but expected %v.", data, expected)
+	}
+
+	var result Waypoint
+	if err := UnmarshalBytes(Uncompressed, data, &result); err != nil {
+		t.Fatal(err)
+	} else if result != waypoint {
+		t.Errorf("Decoded %#v, but expected %#v.", result, waypoint)
+	}
+
+	// Pointer receivers are used for addressable values, including the
+	// elements of a list.
+	data, err = MarshalBytes(Uncompressed, "", &struct {
+		Facing []Direction
+	}{[]Direction{0, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 6, 'F', 'a', 'c', 'i', 'n', 'g', byte(TagString), 0, 0, 0, 2, 0, 1, 'N', 0, 1, 'S',
+		byte(TagEnd),
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Encoded %v, but expected %v.", data, expected)
+	}
+
+	_, err = MarshalBytes(Uncompressed, "", &struct{ Facing Direction }{7})
+	if err == nil || err.Error() != "Direction 7 is out of range\n\t\tat struct field \"Facing\"\n\t\tat struct field \"\"" {
+		t.Errorf("Expected an out of range error, but got %v.", err)
+	}
+}
+
+func TestPayloadSize(t *testing.T) {
+	for _, v := range []interface{}{
+		int8(1),
+		"a string with ÅÄÖ and \x00",
+		[]int64{1, 2, 3},
+		[]interface{}{},
+		map[string]interface{}{"a": []string{"x", "yz"}, "b": map[string]float32{"c": 1}},
+		RoundTripNested{Name: "sized"},
+		Waypoint{Name: "home", Position: Coordinates{1, 2}},
+		RawMessage{byte(TagShort), 0, 7},
+	} {
+		data, err := MarshalBytes(Uncompressed, "root", v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size, err := PayloadSize("root", v); err != nil {
+			t.Errorf("%T: %v", v, err)
+		} else if size != len(data) {
+			t.Errorf("%T: PayloadSize is %d, but MarshalBytes wrote %d bytes.", v, size, len(data))
+		}
+	}
+
+	if _, err := PayloadSize("", 1); err == nil {
+		t.Error("No error for an int, but one was expected!")
+	}
+}
+
+type TypeOverride struct {
+	Air   int32   `nbt:"Air,type=short"`
+	Ratio float64 `nbt:"Ratio,type=float"`
+	Flags uint32  `nbt:",type=byte"`
+	Fuse  *int64  `nbt:"Fuse,type=int,omitempty"`
+}
+
+func TestTypeOverride(t *testing.T) {
+	fuse := int64(-30)
+	v := TypeOverride{Air: 300, Ratio: 0.5, Flags: 255, Fuse: &fuse}
+	data, err := MarshalBytes(Uncompressed, "", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagShort), 0, 3, 'A', 'i', 'r', 0x01, 0x2c,
+		byte(TagFloat), 0, 5, 'R', 'a', 't', 'i', 'o', 0x3f, 0, 0, 0,
+		byte(TagByte), 0, 5, 'F', 'l', 'a', 'g', 's', 0xff,
+		byte(TagInt), 0, 4, 'F', 'u', 's', 'e', 0xff, 0xff, 0xff, 0xe2,
+		byte(TagEnd),
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Encoded %v, but expected %v.", data, expected)
+	}
+
+	var result TypeOverride
+	if err := UnmarshalBytes(Uncompressed, data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Air != 300 || result.Ratio != 0.5 || result.Flags != 255 || result.Fuse == nil || *result.Fuse != -30 {
+		t.Errorf("Decoded %#v.", result)
+	}
+
+	v.Air = 40000
+	if _, err := MarshalBytes(Uncompressed, "", v); err == nil || err.Error() != "nbt: 40000 does not fit in a TAG_Short\n\t\tat struct field \"Air\"\n\t\tat struct field \"\"" {
+		t.Errorf("Expected an overflow error, but got %v.", err)
+	}
+	v.Air, v.Flags = 0, 256
+	if _, err := MarshalBytes(Uncompressed, "", v); err == nil {
+		t.Error("No error for 256 in a TAG_Byte, but one was expected!")
+	}
+
+	// NaN is a float like any other, but a double too big isn't.
+	v.Flags, v.Ratio = 0, math.NaN()
+	if data, err := MarshalBytes(Uncompressed, "", v); err != nil {
+		t.Error(err)
+	} else if err := UnmarshalBytes(Uncompressed, data, &result); err != nil {
+		t.Error(err)
+	} else if !math.IsNaN(result.Ratio) {
+		t.Errorf("Decoded a Ratio of %v, but expected NaN.", result.Ratio)
+	}
+	v.Ratio = math.MaxFloat64
+	if _, err := MarshalBytes(Uncompressed, "", v); err == nil {
+		t.Error("No error for math.MaxFloat64 in a TAG_Float, but one was expected!")
+	}
+	v.Ratio = 0.5
+
+	// A TAG_Short of 300 doesn't fit in an int8, and a TAG_Int is not a
+	// TAG_Short.
+	var small struct {
+		Air int8 `nbt:"Air,type=short"`
+	}
+	if err := UnmarshalBytes(Uncompressed, data, &small); err == nil || err.Error() != "nbt: at offset 11: value 300 out of range for int8\n\t\tat struct field \"Air\"" {
+		t.Errorf("Expected an overflow error, but got %v.", err)
+	}
+	var wrong struct {
+		Fuse int64 `nbt:"Fuse,type=short"`
+	}
+	if err := UnmarshalBytes(Uncompressed, data, &wrong); err == nil {
+		t.Error("No error for a TAG_Int read as a short, but one was expected!")
+	}
+
+	if _, err := MarshalBytes(Uncompressed, "", struct {
+		S string `nbt:",type=short"`
+	}{}); err == nil {
+		t.Error("No error for a string field given a type, but one was expected!")
+	}
+}
@@ -0,0 +1,321 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Reads an NBT root tag from in and returns it as JSON. Every tag becomes an
+// object giving its type along with its value, so that nothing is lost:
+//
+//	{"name": "hello world", "type": "compound", "value": {
+//		"name": {"type": "string", "value": "Bananrama"}
+//	}}
+//
+// The type is the tag's name in lower case without the TAG_ prefix, such as
+// "byte", "int_array" or "compound". Only the root has a name; the names of
+// the tags in a compound are the keys of its value. The values are:
+//
+//	byte, short, int          a number
+//	long                      a string holding the number, as JavaScript
+//	                          can't hold every int64 in a number
+//	float, double             a number, or "NaN", "Infinity" or "-Infinity"
+//	string                    a string
+//	byte_array, int_array     an array of numbers, with bytes being signed
+//	long_array                an array of strings, like long
+//	list                      an array of tagged objects, with the type of
+//	                          the elements also given as "elem"
+//	compound                  an object of tagged objects
+func ToJSON(compression Compression, in io.Reader) ([]byte, error) {
+	n, err := Parse(compression, in)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
+// Reads JSON in the form ToJSON writes and returns the Node it describes.
+// Every tag must give its type, and lists must also give "elem", so that the
+// result has exactly the tags that were asked for. The result can be saved
+// with Write.
+func FromJSON(in io.Reader) (Node, error) {
+	var n Node
+	err := json.NewDecoder(in).Decode(&n)
+	return n, err
+}
+
+// The JSON form of a Node, as described by ToJSON.
+type jsonNode struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Elem  string      `json:"elem,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// Returns the name of tag as it appears in JSON.
+func jsonType(tag Tag) string {
+	return strings.ToLower(strings.TrimPrefix(tag.String(), "TAG_"))
+}
+
+// Encodes n in the form described by ToJSON.
+func (n Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSON(n))
+}
+
+// Decodes n from the form described by ToJSON.
+func (n *Node) UnmarshalJSON(data []byte) error {
+	node, err := fromJSON(data)
+	if err != nil {
+		return err
+	}
+	*n = node
+	return nil
+}
+
+// Returns the tag with the given JSON name.
+func parseJSONType(name string) (Tag, error) {
+	for tag := TagEnd; tag <= TagLongArray; tag++ {
+		if jsonType(tag) == name {
+			return tag, nil
+		}
+	}
+	if name == "" {
+		return TagEnd, fmt.Errorf("nbt: JSON tag has no type")
+	}
+	return TagEnd, fmt.Errorf("nbt: Unknown JSON tag type %q", name)
+}
+
+// Like json.Unmarshal, but refuses keys that v has no place for.
+func unmarshalJSONStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func fromJSON(data []byte) (Node, error) {
+	var j struct {
+		Name  string          `json:"name"`
+		Type  string          `json:"type"`
+		Elem  string          `json:"elem"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := unmarshalJSONStrict(data, &j); err != nil {
+		return Node{}, fmt.Errorf("nbt: %w", err)
+	}
+
+	tag, err := parseJSONType(j.Type)
+	if err != nil {
+		return Node{}, err
+	}
+	if tag == TagEnd {
+		return Node{}, fmt.Errorf("nbt: TAG_End cannot be used as a value")
+	}
+	if j.Elem != "" && tag != TagList {
+		return Node{}, fmt.Errorf("nbt: %s has an element type", tag)
+	}
+	if len(j.Value) == 0 || string(j.Value) == "null" {
+		return Node{}, fmt.Errorf("nbt: %s has no value", tag)
+	}
+
+	n := Node{Tag: tag, Name: j.Name}
+	switch tag {
+	case TagByte:
+		var v int8
+		err = json.Unmarshal(j.Value, &v)
+		n.Value = v
+	case TagShort:
+		var v int16
+		err = json.Unmarshal(j.Value, &v)
+		n.Value = v
+	case TagInt:
+		var v int32
+		err = json.Unmarshal(j.Value, &v)
+		n.Value = v
+	case TagLong:
+		n.Value, err = jsonLong(j.Value)
+	case TagFloat:
+		var v float64
+		v, err = jsonNumber(j.Value, 32)
+		n.Value = float32(v)
+	case TagDouble:
+		n.Value, err = jsonNumber(j.Value, 64)
+	case TagString:
+		var v string
+		err = json.Unmarshal(j.Value, &v)
+		n.Value = v
+
+	case TagByteArray:
+		var v []int8
+		err = json.Unmarshal(j.Value, &v)
+		array := make([]byte, len(v))
+		for i := range v {
+			array[i] = byte(v[i])
+		}
+		n.Value = array
+
+	case TagIntArray:
+		v := []int32{}
+		err = json.Unmarshal(j.Value, &v)
+		n.Value = v
+
+	case TagLongArray:
+		var raw []json.RawMessage
+		err = json.Unmarshal(j.Value, &raw)
+		array := make([]int64, len(raw))
+		for i := 0; err == nil && i < len(raw); i++ {
+			array[i], err = jsonLong(raw[i])
+		}
+		n.Value = array
+
+	case TagList:
+		if j.Elem == "" {
+			return n, fmt.Errorf("nbt: TAG_List has no element type")
+		}
+		if n.Elem, err = parseJSONType(j.Elem); err != nil {
+			return n, err
+		}
+
+		var raw []json.RawMessage
+		if err := json.Unmarshal(j.Value, &raw); err != nil {
+			return n, fmt.Errorf("nbt: %w", err)
+		}
+		if n.Elem == TagEnd && len(raw) != 0 {
+			return n, fmt.Errorf("nbt: TAG_List of TAG_End has elements")
+		}
+		list := make(List, len(raw))
+		for i := range raw {
+			if list[i], err = fromJSON(raw[i]); err == nil && list[i].Tag != n.Elem {
+				err = fmt.Errorf("nbt: %s in a TAG_List of %s", list[i].Tag, n.Elem)
+			}
+			if err != nil {
+				return n, fmt.Errorf("%w\n\t\tat list index %d", err, i)
+			}
+		}
+		n.Value = list
+
+	case TagCompound:
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(j.Value, &raw); err != nil {
+			return n, fmt.Errorf("nbt: %w", err)
+		}
+		compound := make(Compound, len(raw))
+		for name := range raw {
+			child, err := fromJSON(raw[name])
+			if err != nil {
+				return n, fmt.Errorf("%w\n\t\tat struct field %#v", err, name)
+			}
+			child.Name = name
+			compound[name] = child
+		}
+		n.Value = compound
+	}
+	if err != nil {
+		return n, fmt.Errorf("nbt: Invalid %s: %w", tag, err)
+	}
+
+	return n, nil
+}
+
+// Reads a TAG_Long, written as a string or, less safely, as a number.
+func jsonLong(data json.RawMessage) (int64, error) {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	var v int64
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// Reads a TAG_Float or TAG_Double, which may be "NaN", "Infinity" or
+// "-Infinity".
+func jsonNumber(data json.RawMessage, bits int) (float64, error) {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		switch s {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(n), bits)
+}
+
+func toJSON(n Node) jsonNode {
+	j := jsonNode{Name: n.Name, Type: jsonType(n.Tag)}
+
+	switch v := n.Value.(type) {
+	case int64:
+		j.Value = strconv.FormatInt(v, 10)
+
+	case float32:
+		j.Value = jsonFloat(float64(v), 32)
+
+	case float64:
+		j.Value = jsonFloat(v, 64)
+
+	case []byte:
+		array := make([]int8, len(v))
+		for i := range v {
+			array[i] = int8(v[i])
+		}
+		j.Value = array
+
+	case []int64:
+		array := make([]string, len(v))
+		for i := range v {
+			array[i] = strconv.FormatInt(v[i], 10)
+		}
+		j.Value = array
+
+	case List:
+		j.Elem = jsonType(n.Elem)
+		list := make([]jsonNode, len(v))
+		for i := range v {
+			list[i] = toJSON(v[i])
+			list[i].Name = ""
+		}
+		j.Value = list
+
+	case Compound:
+		compound := make(map[string]jsonNode, len(v))
+		for name, child := range v {
+			c := toJSON(child)
+			c.Name = ""
+			compound[name] = c
+		}
+		j.Value = compound
+
+	default:
+		j.Value = v
+	}
+
+	return j
+}
+
+// Formats f with as few digits as float32 or float64 needs to read it back.
+// JSON has no way to write NaN or the infinities as numbers.
+func jsonFloat(f float64, bits int) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, bits))
+}
@@ -0,0 +1,183 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 1, 'R', // TAG_Compound "R"
+		byte(TagByte), 0, 1, 'b', 0xff, // TAG_Byte "b": -1
+		byte(TagShort), 0, 1, 's', 1, 0, // TAG_Short "s": 256
+		byte(TagInt), 0, 1, 'i', 0, 0, 0, 20, // TAG_Int "i": 20
+		byte(TagLong), 0, 1, 'l', 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // TAG_Long "l": 9223372036854775807
+		byte(TagFloat), 0, 1, 'f', 0x3d, 0xcc, 0xcc, 0xcd, // TAG_Float "f": 0.1
+		byte(TagDouble), 0, 1, 'd', 0x7f, 0xf0, 0, 0, 0, 0, 0, 0, // TAG_Double "d": +Inf
+		byte(TagString), 0, 3, 's', 't', 'r', 0, 2, 'h', 'i', // TAG_String "str": "hi"
+		byte(TagByteArray), 0, 2, 'b', 'a', 0, 0, 0, 2, 1, 0x80, // TAG_Byte_Array "ba": [1, -128]
+		byte(TagIntArray), 0, 2, 'i', 'a', 0, 0, 0, 1, 0, 0, 0, 3, // TAG_Int_Array "ia": [3]
+		byte(TagLongArray), 0, 2, 'l', 'a', 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 4, // TAG_Long_Array "la": [4]
+		byte(TagList), 0, 4, 'l', 'i', 's', 't', byte(TagCompound), 0, 0, 0, 1, // TAG_List "list": 1 TAG_Compound
+		byte(TagEnd),
+		byte(TagList), 0, 5, 'e', 'm', 'p', 't', 'y', byte(TagEnd), 0, 0, 0, 0, // TAG_List "empty": 0 TAG_End
+		byte(TagEnd),
+	}
+
+	encoded, err := ToJSON(Uncompressed, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"R","type":"compound","value":{` +
+		`"b":{"type":"byte","value":-1},` +
+		`"ba":{"type":"byte_array","value":[1,-128]},` +
+		`"d":{"type":"double","value":"Infinity"},` +
+		`"empty":{"type":"list","elem":"end","value":[]},` +
+		`"f":{"type":"float","value":0.1},` +
+		`"i":{"type":"int","value":20},` +
+		`"ia":{"type":"int_array","value":[3]},` +
+		`"l":{"type":"long","value":"9223372036854775807"},` +
+		`"la":{"type":"long_array","value":["4"]},` +
+		`"list":{"type":"list","elem":"compound","value":[{"type":"compound","value":{}}]},` +
+		`"s":{"type":"short","value":256},` +
+		`"str":{"type":"string","value":"hi"}}}`
+	if string(encoded) != expected {
+		t.Errorf("Found    %s", encoded)
+		t.Logf("Expected %s", expected)
+	}
+}
+
+func TestToJSONBigTest(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	encoded, err := ToJSON(GZip, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Name  string
+		Value map[string]struct {
+			Type  string
+			Value interface{}
+		}
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "Level" {
+		t.Errorf("Root is named %#v.", decoded.Name)
+	}
+	if f := decoded.Value["floatTest"]; f.Type != "float" || math.Abs(f.Value.(float64)-0.49823147) > 1e-9 {
+		t.Errorf("floatTest is %#v.", f)
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	expected, err := ToJSON(GZip, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := FromJSON(bytes.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded bytes.Buffer
+	if err := Write(Uncompressed, &encoded, n.Name, n); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ToJSON(Uncompressed, &encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, expected) {
+		t.Errorf("Found    %s", result)
+		t.Logf("Expected %s", expected)
+	}
+}
+
+func TestFromJSONEmptyList(t *testing.T) {
+	data := mustMarshal(t, Uncompressed, "", map[string]interface{}{
+		"Items":  []interface{}{},
+		"Nested": []interface{}{[]interface{}{}},
+	})
+	expected, err := ToJSON(Uncompressed, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(expected), `"elem":"end"`) {
+		t.Fatalf("Empty lists are not lists of TAG_End in %s", expected)
+	}
+
+	n, err := FromJSON(bytes.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded bytes.Buffer
+	if err := Write(Uncompressed, &encoded, n.Name, n); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded.Bytes(), data) {
+		t.Errorf("Wrote % x, but expected % x", encoded.Bytes(), data)
+	}
+}
+
+func TestFromJSONTypes(t *testing.T) {
+	n, err := FromJSON(strings.NewReader(`{"type":"compound","value":{
+		"b":{"type":"byte","value":1},
+		"i":{"type":"int","value":1},
+		"l":{"type":"long","value":1},
+		"nan":{"type":"float","value":"NaN"},
+		"e":{"type":"list","elem":"end","value":[]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := n.Get("b"); b.Value != int8(1) {
+		t.Errorf("b is %#v.", b.Value)
+	}
+	if i, _ := n.Get("i"); i.Value != int32(1) {
+		t.Errorf("i is %#v.", i.Value)
+	}
+	if l, _ := n.Get("l"); l.Value != int64(1) {
+		t.Errorf("l is %#v.", l.Value)
+	}
+	if nan, _ := n.Get("nan"); !math.IsNaN(float64(nan.Value.(float32))) {
+		t.Errorf("nan is %#v.", nan.Value)
+	}
+
+	for s, expected := range map[string]string{
+		`{"value":1}`:                       "nbt: JSON tag has no type",
+		`{"type":"bignum","value":1}`:       `nbt: Unknown JSON tag type "bignum"`,
+		`{"type":"byte","value":300}`:       "nbt: Invalid TAG_Byte: json: cannot unmarshal number 300 into Go value of type int8",
+		`{"type":"int","value":1.5}`:        "nbt: Invalid TAG_Int: json: cannot unmarshal number 1.5 into Go value of type int32",
+		`{"type":"int"}`:                    "nbt: TAG_Int has no value",
+		`{"type":"list","value":[]}`:        "nbt: TAG_List has no element type",
+		`{"type":"int","value":1,"size":4}`: `nbt: json: unknown field "size"`,
+		`{"type":"end","value":0}`:          "nbt: TAG_End cannot be used as a value",
+		`{"type":"double","value":"lots"}`:  `nbt: Invalid TAG_Double: "lots" is not a number`,
+		`{"type":"list","elem":"int","value":[{"type":"short","value":1}]}`:      "nbt: TAG_Short in a TAG_List of TAG_Int\n\t\tat list index 0",
+		`{"type":"compound","value":{"x":{"type":"long_array","value":["1x"]}}}`: "nbt: Invalid TAG_Long_Array: strconv.ParseInt: parsing \"1x\": invalid syntax\n\t\tat struct field \"x\"",
+	} {
+		_, err := FromJSON(strings.NewReader(s))
+		if err == nil {
+			t.Errorf("No error for %s.", s)
+		} else if err.Error() != expected {
+			t.Errorf("Expected %q for %s, but got %q.", expected, s, err)
+		}
+	}
+}
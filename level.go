@@ -0,0 +1,33 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Reads a world's level.dat from in, which is gzipped and holds everything of
+// interest in a compound named "Data" inside the root compound, and decodes
+// that compound into v, which must be a non-nil pointer.
+func UnmarshalLevelDat(in io.Reader, v interface{}) error {
+	if err := checkUnmarshalTarget(v); err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+
+	level := reflect.New(reflect.StructOf([]reflect.StructField{{
+		Name: "Data",
+		Type: rv.Type(),
+		Tag:  `nbt:"Data"`,
+	}}))
+	if err := Unmarshal(GZip, in, level.Interface()); err != nil {
+		return err
+	}
+
+	data := level.Elem().Field(0)
+	if data.IsNil() {
+		return fmt.Errorf("nbt: level.dat has no Data compound")
+	}
+	rv.Elem().Set(data.Elem())
+	return nil
+}
@@ -0,0 +1,51 @@
+package nbt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type LevelData struct {
+	LevelName   string
+	DataVersion int32
+	SpawnX      int32
+	SpawnY      int32
+	SpawnZ      int32
+	GameRules   map[string]string
+}
+
+func TestUnmarshalLevelDat(t *testing.T) {
+	expected := LevelData{
+		LevelName:   "New World",
+		DataVersion: 3465,
+		SpawnX:      -48,
+		SpawnY:      64,
+		SpawnZ:      112,
+		GameRules:   map[string]string{"doDaylightCycle": "true"},
+	}
+	data, err := MarshalBytes(GZip, "", map[string]interface{}{"Data": expected})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var level LevelData
+	if err := UnmarshalLevelDat(bytes.NewReader(data), &level); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(level, expected) {
+		t.Errorf("Decoded %#v, but expected %#v.", level, expected)
+	}
+
+	data, err = MarshalBytes(GZip, "", expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmarshalLevelDat(bytes.NewReader(data), &level); err == nil || err.Error() != "nbt: level.dat has no Data compound" {
+		t.Errorf("Expected an error for a missing Data compound, but got %v.", err)
+	}
+
+	if err := UnmarshalLevelDat(bytes.NewReader(data), level); err == nil {
+		t.Error("No error for a non-pointer, but one was expected!")
+	}
+}
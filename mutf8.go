@@ -0,0 +1,86 @@
+package nbt
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// NBT strings are in Java's modified UTF-8, which differs from UTF-8 in two
+// ways: U+0000 is written as the two bytes C0 80, so that no string holds a
+// zero byte, and characters outside the Basic Multilingual Plane are written
+// as a UTF-16 surrogate pair with each half taking three bytes.
+
+// Decodes a modified UTF-8 string. Plain UTF-8, including bare zero bytes
+// and four byte sequences, is also accepted, since not everything that
+// writes NBT gets this right. Surrogates without their other half and other
+// malformed bytes become U+FFFD.
+func decodeMUTF8(b []byte) string {
+	// Modified UTF-8's special forms are never valid UTF-8, so strings
+	// without them can be used as they are.
+	if utf8.Valid(b) {
+		return string(b)
+	}
+
+	s := make([]rune, 0, len(b))
+	for len(b) > 0 {
+		r, size := decodeMUTF8Rune(b)
+		b = b[size:]
+		if utf16.IsSurrogate(r) {
+			next, nextSize := decodeMUTF8Rune(b)
+			if pair := utf16.DecodeRune(r, next); pair != utf8.RuneError {
+				r = pair
+				b = b[nextSize:]
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		s = append(s, r)
+	}
+	return string(s)
+}
+
+// Decodes the first character of a modified UTF-8 string, which may be half
+// of a surrogate pair.
+func decodeMUTF8Rune(b []byte) (rune, int) {
+	switch {
+	case len(b) >= 2 && b[0] == 0xc0 && b[1] == 0x80:
+		return 0, 2
+	case len(b) >= 3 && b[0] == 0xed && b[1]&0xe0 == 0xa0 && b[2]&0xc0 == 0x80:
+		// A surrogate, which utf8.DecodeRune refuses.
+		return rune(b[0]&0x0f)<<12 | rune(b[1]&0x3f)<<6 | rune(b[2]&0x3f), 3
+	}
+	return utf8.DecodeRune(b)
+}
+
+// Returns s in modified UTF-8.
+func encodeMUTF8(s string) []byte {
+	special := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 || s[i] >= 0xf0 {
+			special = true
+			break
+		}
+	}
+	if !special {
+		return []byte(s)
+	}
+
+	b := make([]byte, 0, len(s)+len(s)/2)
+	for _, r := range s {
+		switch {
+		case r == 0:
+			b = append(b, 0xc0, 0x80)
+		case r > 0xffff:
+			high, low := utf16.EncodeRune(r)
+			b = appendSurrogate(appendSurrogate(b, high), low)
+		default:
+			b = utf8.AppendRune(b, r)
+		}
+	}
+	return b
+}
+
+// Appends half of a surrogate pair the way modified UTF-8 writes it.
+func appendSurrogate(b []byte, r rune) []byte {
+	return append(b, 0xe0|byte(r>>12), 0x80|byte(r>>6)&0x3f, 0x80|byte(r)&0x3f)
+}
@@ -0,0 +1,344 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A Node is a single tag read without a Go type to put it in, for programs
+// that need to look at or edit tags they don't know about in advance.
+//
+// Value holds the payload using the same Go types Unmarshal picks for an
+// interface{}, except that a TAG_List holds a List and a TAG_Compound holds
+// a Compound.
+type Node struct {
+	Tag  Tag
+	Name string // Empty for list elements.
+
+	// The tag of the elements, for a TAG_List. When writing, it may be left
+	// as TagEnd if the list has elements, in which case the first element
+	// decides. An empty List is written with TagEnd as its element type, the
+	// way Minecraft writes empty lists, but a list whose Value is nil has no
+	// element type at all and must set Elem.
+	Elem Tag
+
+	Value interface{}
+}
+
+// The payload of a TAG_Compound Node, keyed by name. Every Node in it has
+// its Name set to its key.
+type Compound map[string]Node
+
+// The payload of a TAG_List Node.
+type List []Node
+
+// Reads an NBT root tag from in without needing a Go type to store it in.
+func Parse(compression Compression, in io.Reader) (Node, error) {
+	return NewDecoder(compression, in).DecodeNode()
+}
+
+// Writes n to out as an NBT root tag with the given name, which takes the
+// place of n.Name.
+func Write(compression Compression, out io.Writer, name string, n Node) error {
+	enc := NewEncoder(compression, out)
+	if err := enc.EncodeNode(name, n); err != nil {
+		enc.Close()
+		return err
+	}
+	return enc.Close()
+}
+
+// Reads the next NBT root tag as a Node.
+func (dec *Decoder) DecodeNode() (Node, error) {
+	if dec.err != nil {
+		return Node{}, dec.err
+	}
+	n, err := dec.state.parse()
+	return n, dec.state.offsetError(err)
+}
+
+func (d *decodeState) parse() (Node, error) {
+	d.root = d.offset()
+	var name string
+	var tag Tag
+	var err error
+	if d.networkRoot {
+		tag, err = d.readTagID()
+	} else {
+		name, tag, err = d.readTag()
+	}
+	if err != nil {
+		return Node{}, err
+	}
+	n, err := d.readNode(tag, name)
+	if err == nil {
+		err = d.end()
+	}
+	return n, err
+}
+
+func (d *decodeState) readNode(tag Tag, name string) (Node, error) {
+	n := Node{Tag: tag, Name: name}
+
+	switch tag {
+	case TagList:
+		if err := d.enter(); err != nil {
+			return n, err
+		}
+		defer d.leave()
+
+		elem, length, err := d.readListHeader()
+		n.Elem = elem
+		if err != nil {
+			return n, err
+		}
+
+		list := make(List, 0, initialCapacity(length))
+		for i := 0; i < int(length); i++ {
+			if err := d.tick(1); err != nil {
+				return n, err
+			}
+			elem, err := d.readNode(n.Elem, "")
+			if err != nil {
+				return n, fmt.Errorf("%w\n\t\tat list index %d", err, i)
+			}
+			list = append(list, elem)
+		}
+		n.Value = list
+
+	case TagCompound:
+		if err := d.enter(); err != nil {
+			return n, err
+		}
+		defer d.leave()
+
+		compound := make(Compound)
+		seen := d.seenKeys()
+		for {
+			name, tag, err := d.readTag()
+			if err != nil {
+				return n, err
+			}
+			if tag == TagEnd {
+				break
+			}
+			if err := seen.add(name); err != nil {
+				return n, err
+			}
+
+			child, err := d.readNode(tag, name)
+			if err != nil {
+				return n, fmt.Errorf("%w\n\t\tat struct field %#v", err, name)
+			}
+			compound[name] = child
+		}
+		n.Value = compound
+
+	default:
+		value, err := d.allocate(tag)
+		if err != nil {
+			return n, err
+		}
+		if err := d.readValue(tag, value); err != nil {
+			return n, err
+		}
+		n.Value = value.Interface()
+	}
+
+	return n, nil
+}
+
+// Writes n as an NBT root tag with the given name.
+func (enc *Encoder) EncodeNode(name string, n Node) (err error) {
+	if enc.err != nil {
+		return enc.err
+	}
+	defer recoverError(&err)
+	enc.state.writeNode(name, n)
+	return
+}
+
+func (e *encodeState) writeNode(name string, n Node) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, fmt.Sprintf("\n\t\tat struct field %#v", name)))
+		}
+	}()
+	e.w(n.Tag)
+	e.writeValue(TagString, name)
+	e.writeNodePayload(n)
+}
+
+func (e *encodeState) writeNodePayload(n Node) {
+	ok := true
+	switch n.Tag {
+	case TagByte:
+		_, ok = n.Value.(int8)
+	case TagShort:
+		_, ok = n.Value.(int16)
+	case TagInt:
+		_, ok = n.Value.(int32)
+	case TagLong:
+		_, ok = n.Value.(int64)
+	case TagFloat:
+		_, ok = n.Value.(float32)
+	case TagDouble:
+		_, ok = n.Value.(float64)
+	case TagByteArray:
+		_, ok = n.Value.([]byte)
+	case TagString:
+		_, ok = n.Value.(string)
+	case TagIntArray:
+		_, ok = n.Value.([]int32)
+	case TagLongArray:
+		_, ok = n.Value.([]int64)
+	case TagList:
+		_, ok = n.Value.(List)
+		ok = ok || n.Value == nil
+	case TagCompound:
+		_, ok = n.Value.(Compound)
+		ok = ok || n.Value == nil
+	default:
+		panic(fmt.Errorf("nbt: Unhandled tag: %s", n.Tag))
+	}
+	if !ok {
+		panic(fmt.Errorf("nbt: %s node holds a %T", n.Tag, n.Value))
+	}
+
+	switch v := n.Value.(type) {
+	case []byte, string:
+		e.writeValue(n.Tag, v)
+
+	case []int32:
+		e.w(uint32(len(v)))
+		e.w(v)
+
+	case []int64:
+		e.w(uint32(len(v)))
+		e.w(v)
+
+	case List:
+		if v == nil && n.Elem == TagEnd {
+			panic(fmt.Errorf("nbt: Empty TAG_List has no element type"))
+		}
+		e.writeNodeList(n.Elem, v)
+
+	case Compound:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			e.writeNode(name, v[name])
+		}
+		e.w(TagEnd)
+
+	case nil:
+		if n.Tag == TagCompound {
+			e.w(TagEnd)
+			break
+		}
+		if n.Elem == TagEnd {
+			panic(fmt.Errorf("nbt: Empty TAG_List has no element type"))
+		}
+		e.writeNodeList(n.Elem, nil)
+
+	default:
+		e.w(v)
+	}
+}
+
+func (e *encodeState) writeNodeList(elem Tag, list List) {
+	if elem == TagEnd && len(list) != 0 {
+		elem = list[0].Tag
+	}
+	e.w(elem)
+	e.w(uint32(len(list)))
+
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, fmt.Sprintf("\n\t\tat list index %d", i)))
+		}
+	}()
+	for i = range list {
+		if list[i].Tag != elem {
+			panic(fmt.Errorf("nbt: %s in a TAG_List of %s", list[i].Tag, elem))
+		}
+		e.writeNodePayload(list[i])
+	}
+}
+
+// Returns the child of a TAG_Compound with the given name. The second result
+// is false if there is no such child or n is not a compound.
+func (n Node) Get(name string) (Node, bool) {
+	compound, _ := n.Value.(Compound)
+	child, ok := compound[name]
+	return child, ok
+}
+
+// Returns the ith element of a TAG_List. It panics if i is out of range.
+func (n Node) Index(i int) Node {
+	return n.Value.(List)[i]
+}
+
+// Returns the number of elements in a list or array, the number of children
+// of a compound, or the length in bytes of a string. It panics for any other
+// tag.
+func (n Node) Len() int {
+	switch v := n.Value.(type) {
+	case List:
+		return len(v)
+	case Compound:
+		return len(v)
+	case []byte:
+		return len(v)
+	case []int32:
+		return len(v)
+	case []int64:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	panic(fmt.Errorf("nbt: Len of %s node", n.Tag))
+}
+
+// Returns the value of a TAG_Byte, TAG_Short, TAG_Int or TAG_Long. It panics
+// for any other tag.
+func (n Node) Int() int64 {
+	switch v := n.Value.(type) {
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	}
+	panic(fmt.Errorf("nbt: Int of %s node", n.Tag))
+}
+
+// Returns the value of a TAG_Float or TAG_Double. It panics for any other
+// tag.
+func (n Node) Float() float64 {
+	switch v := n.Value.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	panic(fmt.Errorf("nbt: Float of %s node", n.Tag))
+}
+
+// Returns the value of a TAG_String. Like reflect.Value, any other tag gives
+// a description of the node instead of panicking, so that Nodes print
+// sensibly.
+func (n Node) String() string {
+	if s, ok := n.Value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("<%s Node>", n.Tag)
+}
@@ -0,0 +1,214 @@
+package nbt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte{
+		byte(TagCompound), 0, 4, 'R', 'o', 'o', 't', // TAG_Compound "Root"
+		byte(TagByte), 0, 4, 'F', 'l', 'a', 'g', 1, // TAG_Byte "Flag": 1
+		byte(TagShort), 0, 1, 'S', 0xff, 0xfe, // TAG_Short "S": -2
+		byte(TagLong), 0, 1, 'L', 0, 0, 0, 1, 0, 0, 0, 0, // TAG_Long "L": 1<<32
+		byte(TagDouble), 0, 1, 'D', 0x3f, 0xe0, 0, 0, 0, 0, 0, 0, // TAG_Double "D": 0.5
+		byte(TagString), 0, 4, 'N', 'a', 'm', 'e', 0, 5, 'S', 't', 'e', 'v', 'e', // TAG_String "Name": "Steve"
+		byte(TagList), 0, 3, 'P', 'o', 's', byte(TagInt), 0, 0, 0, 2, // TAG_List "Pos": 2 TAG_Int
+		0, 0, 0, 7, // 7
+		0xff, 0xff, 0xff, 0xff, // -1
+		byte(TagList), 0, 5, 'E', 'm', 'p', 't', 'y', byte(TagEnd), 0, 0, 0, 0, // TAG_List "Empty": 0 TAG_End
+		byte(TagCompound), 0, 5, 'I', 'n', 'n', 'e', 'r', // TAG_Compound "Inner"
+		byte(TagIntArray), 0, 1, 'A', 0, 0, 0, 1, 0, 0, 0, 3, // TAG_Int_Array "A": [3]
+		byte(TagEnd),
+		byte(TagEnd),
+	}
+
+	root, err := Parse(Uncompressed, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Tag != TagCompound || root.Name != "Root" || root.Len() != 8 {
+		t.Fatalf("Root is %s %#v with %d children.", root.Tag, root.Name, root.Len())
+	}
+
+	for name, expected := range map[string]int64{"Flag": 1, "S": -2, "L": 1 << 32} {
+		if n, ok := root.Get(name); !ok {
+			t.Errorf("%s is missing.", name)
+		} else if n.Name != name || n.Int() != expected {
+			t.Errorf("%s is %d, but expected %d.", name, n.Int(), expected)
+		}
+	}
+	if d, _ := root.Get("D"); d.Tag != TagDouble || d.Float() != 0.5 {
+		t.Errorf("D is %s %v.", d.Tag, d.Value)
+	}
+	if n, _ := root.Get("Name"); n.String() != "Steve" {
+		t.Errorf("Name is %q.", n.String())
+	}
+
+	pos, _ := root.Get("Pos")
+	if pos.Tag != TagList || pos.Elem != TagInt || pos.Len() != 2 {
+		t.Fatalf("Pos is %s of %d %s.", pos.Tag, pos.Len(), pos.Elem)
+	}
+	if pos.Index(0).Int() != 7 || pos.Index(1).Int() != -1 {
+		t.Errorf("Pos is %v, %v.", pos.Index(0).Value, pos.Index(1).Value)
+	}
+	if empty, _ := root.Get("Empty"); empty.Elem != TagEnd || empty.Len() != 0 {
+		t.Errorf("Empty has %d %s.", empty.Len(), empty.Elem)
+	}
+
+	inner, _ := root.Get("Inner")
+	if a, ok := inner.Get("A"); !ok || a.Tag != TagIntArray || a.Len() != 1 || a.Value.([]int32)[0] != 3 {
+		t.Errorf("Inner.A is %s %#v.", a.Tag, a.Value)
+	}
+	if _, ok := inner.Get("Missing"); ok {
+		t.Error("Inner.Missing exists.")
+	}
+	if _, ok := pos.Get("A"); ok {
+		t.Error("Get on a list found something.")
+	}
+	if s := pos.String(); s != "<TAG_List Node>" {
+		t.Errorf("String of a list is %q.", s)
+	}
+}
+
+func TestParseBigTest(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	root, err := Parse(GZip, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != "Level" {
+		t.Errorf("Root is named %#v.", root.Name)
+	}
+	nested, _ := root.Get("nested compound test")
+	egg, _ := nested.Get("egg")
+	if name, _ := egg.Get("name"); name.String() != "Eggbert" {
+		t.Errorf("Egg is named %q.", name.String())
+	}
+	if long, _ := root.Get("longTest"); long.Int() != 9223372036854775807 {
+		t.Errorf("longTest is %d.", long.Int())
+	}
+}
+
+func TestWriteNode(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	expected, err := Parse(GZip, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compound := expected.Value.(Compound)
+	compound["empty"] = Node{Tag: TagList, Name: "empty", Value: List{}}
+	compound["longs"] = Node{Tag: TagLongArray, Name: "longs", Value: []int64{1, -1}}
+
+	for _, compression := range []Compression{Uncompressed, GZip} {
+		var encoded bytes.Buffer
+		if err := Write(compression, &encoded, expected.Name, expected); err != nil {
+			t.Fatal(err)
+		}
+		result, err := Parse(compression, &encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Trees differ after writing with %v.", compression)
+			t.Logf("Found   : %#v", result)
+			t.Logf("Expected: %#v", expected)
+		}
+	}
+}
+
+func TestWriteNodeEmptyList(t *testing.T) {
+	// Minecraft writes empty lists as lists of TAG_End, including inside
+	// other lists.
+	data := []byte{
+		byte(TagCompound), 0, 0,
+		byte(TagList), 0, 1, 'l', byte(TagEnd), 0, 0, 0, 0, // TAG_List "l": 0 TAG_End
+		byte(TagList), 0, 6, 'n', 'e', 's', 't', 'e', 'd', byte(TagList), 0, 0, 0, 1, // TAG_List "nested": 1 TAG_List
+		byte(TagEnd), 0, 0, 0, 0, // 0 TAG_End
+		byte(TagEnd),
+	}
+	n, err := Parse(Uncompressed, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded bytes.Buffer
+	if err := Write(Uncompressed, &encoded, n.Name, n); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded.Bytes(), data) {
+		t.Errorf("Wrote % x, but expected % x", encoded.Bytes(), data)
+	}
+	again, err := Parse(Uncompressed, &encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, n) {
+		t.Errorf("Parsed %#v after writing, but expected %#v.", again, n)
+	}
+
+	// Marshal writes empty lists the same way.
+	marshaled, err := MarshalBytes(Uncompressed, "", map[string]interface{}{
+		"l":      []interface{}{},
+		"nested": []interface{}{[]interface{}{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(marshaled, data) {
+		t.Errorf("Marshaled % x, but expected % x", marshaled, data)
+	}
+}
+
+func TestWriteNodeList(t *testing.T) {
+	var encoded bytes.Buffer
+	list := Node{Tag: TagList, Value: List{{Tag: TagShort, Value: int16(1)}, {Tag: TagShort, Value: int16(2)}}}
+	if err := Write(Uncompressed, &encoded, "", list); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		byte(TagList), 0, 0, byte(TagShort), 0, 0, 0, 2, // TAG_List "": 2 TAG_Short
+		0, 1, // 1
+		0, 2, // 2
+	}
+	if !bytes.Equal(encoded.Bytes(), expected) {
+		t.Errorf("Element type was not taken from the first element: % x", encoded.Bytes())
+	}
+
+	typed := Node{Tag: TagList, Elem: TagString}
+	encoded.Reset()
+	if err := Write(Uncompressed, &encoded, "", typed); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(encoded.Bytes(), []byte{byte(TagList), 0, 0, byte(TagString), 0, 0, 0, 0}) {
+		t.Errorf("Typed empty list was written as % x", encoded.Bytes())
+	}
+
+	for _, c := range []struct {
+		node Node
+		err  string
+	}{
+		{Node{Tag: TagList}, "nbt: Empty TAG_List has no element type\n\t\tat struct field \"\""},
+		{Node{Tag: TagList, Value: List(nil)}, "nbt: Empty TAG_List has no element type\n\t\tat struct field \"\""},
+		{Node{Tag: TagList, Value: List{{Tag: TagInt, Value: int32(1)}, {Tag: TagString, Value: "2"}}}, "nbt: TAG_String in a TAG_List of TAG_Int\n\t\tat list index 1\n\t\tat struct field \"\""},
+		{Node{Tag: TagCompound, Value: Compound{"x": {Tag: TagInt, Value: int64(1)}}}, "nbt: TAG_Int node holds a int64\n\t\tat struct field \"x\"\n\t\tat struct field \"\""},
+	} {
+		err := Write(Uncompressed, ioutil.Discard, "", c.node)
+		if err == nil {
+			t.Errorf("No error writing %#v.", c.node)
+		} else if err.Error() != c.err {
+			t.Errorf("Expected %q, but got %q.", c.err, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package nbt
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A TAG_Compound that keeps its tags in the order they were read, for
+// programs that need to write a compound back out exactly as they found it.
+// Marshal writes the tags in slice order.
+//
+// Values are read the way Unmarshal reads into an interface{}, except that
+// compounds nested inside, including those in lists, are OrderedCompounds as
+// well. Note that an empty list cannot keep its element type this way, and
+// is written back with TAG_End as its element type.
+type OrderedCompound []OrderedField
+
+// One tag of an OrderedCompound.
+type OrderedField struct {
+	Name  string
+	Value interface{}
+}
+
+var orderedCompoundType = reflect.TypeOf(OrderedCompound(nil))
+
+// Returns the value of the first tag with the given name, and whether there
+// was one.
+func (c OrderedCompound) Get(name string) (interface{}, bool) {
+	for _, f := range c {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Replaces the value of the first tag with the given name, or adds the tag
+// at the end if there is none.
+func (c *OrderedCompound) Set(name string, value interface{}) {
+	for i := range *c {
+		if (*c)[i].Name == name {
+			(*c)[i].Value = value
+			return
+		}
+	}
+	*c = append(*c, OrderedField{name, value})
+}
+
+// Returns the names of the tags, in order.
+func (c OrderedCompound) Keys() []string {
+	keys := make([]string, len(c))
+	for i, f := range c {
+		keys[i] = f.Name
+	}
+	return keys
+}
+
+// Reads the tags of a TAG_Compound into v, an OrderedCompound, reusing its
+// storage.
+func (d *decodeState) readOrderedCompound(v reflect.Value) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	// Struct field options don't reach inside the compound.
+	defer func(ordered bool, union string) { d.ordered, d.union = ordered, union }(d.ordered, d.union)
+	d.ordered, d.union = true, ""
+
+	c := v.Addr().Interface().(*OrderedCompound)
+	*c = (*c)[:0]
+	seen := d.seenKeys()
+	for {
+		name, tag, err := d.readTag()
+		if err != nil {
+			return err
+		}
+		if tag == TagEnd {
+			return nil
+		}
+		if err := seen.add(name); err != nil {
+			return err
+		}
+		var value interface{}
+		if err := d.readValue(tag, reflect.ValueOf(&value).Elem()); err != nil {
+			return fmt.Errorf("%w\n\t\tat struct field %#v", err, name)
+		}
+		*c = append(*c, OrderedField{name, value})
+	}
+}
+
+func (e *encodeState) writeOrderedCompound(v reflect.Value) {
+	for _, f := range v.Interface().(OrderedCompound) {
+		e.writeTag(f.Name, reflect.ValueOf(&f.Value).Elem())
+	}
+	e.w(TagEnd)
+}
@@ -0,0 +1,112 @@
+package nbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestOrderedCompound(t *testing.T) {
+	for _, file := range []string{"testcases/bigtest.nbt", "testcases/servers.dat", "testcases/Nightgunner5.dat"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// servers.dat is not compressed.
+		if r, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+			if data, err = ioutil.ReadAll(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		testOrderedRoundTrip(t, file, data)
+	}
+
+	// Keys that are not in sorted order, nested compounds, and a list of
+	// compounds.
+	data := mustMarshal(t, Uncompressed, "", struct {
+		Zebra  int32
+		Apple  string
+		Nested struct{ Y, X int8 }
+		List   []struct{ B, A int16 }
+		Empty  []interface{}
+	}{List: []struct{ B, A int16 }{{1, 2}, {3, 4}}, Empty: []interface{}{}})
+	c := testOrderedRoundTrip(t, "unsorted", data)
+
+	if keys := c.Keys(); !reflect.DeepEqual(keys, []string{"Zebra", "Apple", "Nested", "List", "Empty"}) {
+		t.Errorf("Keys are %q.", keys)
+	}
+	if nested, _ := c.Get("Nested"); !reflect.DeepEqual(nested, OrderedCompound{{"Y", int8(0)}, {"X", int8(0)}}) {
+		t.Errorf("Nested compound is %#v.", nested)
+	}
+	if list, _ := c.Get("List"); !reflect.DeepEqual(list, []interface{}{
+		OrderedCompound{{"B", int16(1)}, {"A", int16(2)}},
+		OrderedCompound{{"B", int16(3)}, {"A", int16(4)}},
+	}) {
+		t.Errorf("List is %#v.", list)
+	}
+	if _, ok := c.Get("Missing"); ok {
+		t.Error("Found a tag that is not there.")
+	}
+
+	c.Set("Apple", "pie")
+	c.Set("Banana", int8(1))
+	if keys := c.Keys(); !reflect.DeepEqual(keys, []string{"Zebra", "Apple", "Nested", "List", "Empty", "Banana"}) {
+		t.Errorf("Keys after Set are %q.", keys)
+	}
+	if apple, _ := c.Get("Apple"); apple != "pie" {
+		t.Errorf("Apple is %#v after Set.", apple)
+	}
+
+	// As a struct field and as the elements of a typed slice.
+	var s struct {
+		Nested OrderedCompound
+		List   []OrderedCompound
+	}
+	if err := UnmarshalBytes(Uncompressed, data, &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.List) != 2 || !reflect.DeepEqual(s.List[1].Keys(), []string{"B", "A"}) {
+		t.Errorf("List is %#v.", s.List)
+	}
+	again, err := MarshalBytes(Uncompressed, "", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back struct {
+		Nested struct{ Y, X int8 }
+		List   []struct{ B, A int16 }
+	}
+	if err := UnmarshalBytes(Uncompressed, again, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.List[1].B != 3 || back.List[1].A != 4 {
+		t.Errorf("Read back %#v.", back)
+	}
+
+	// A field with nothing in it gets the same error as a nil interface
+	// anywhere else.
+	_, err = MarshalBytes(Uncompressed, "", OrderedCompound{{"Missing", nil}})
+	if err == nil || err.Error() != "nbt: Cannot write a nil interface {}\n\t\tat struct field \"Missing\"\n\t\tat struct field \"\"" {
+		t.Errorf("Expected a nil interface error, but got %v.", err)
+	}
+}
+
+// Decodes data into an OrderedCompound and checks that writing it gives
+// exactly the same bytes.
+func testOrderedRoundTrip(t *testing.T, what string, data []byte) OrderedCompound {
+	var c OrderedCompound
+	name, err := NewDecoder(Uncompressed, bytes.NewReader(data)).DecodeNamed(&c)
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	again, err := MarshalBytes(Uncompressed, name, c)
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("%s: Writing the OrderedCompound gave\n% x\nbut expected\n% x", what, again, data)
+	}
+	return c
+}
@@ -0,0 +1,160 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// One step of a path given to DecodePath.
+type pathStep struct {
+	key   string
+	index uint32
+	list  bool   // Whether this is an index rather than a key.
+	path  string // The path up to and including this step, for errors.
+}
+
+// Splits a path such as "Level.Sections[3].Palette" into its steps.
+func parsePath(path string) ([]pathStep, error) {
+	var steps []pathStep
+	rest := path
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("nbt: Path %q has an unclosed [", path)
+			}
+			index, err := strconv.ParseUint(rest[1:end], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("nbt: Path %q has a bad index %q", path, rest[1:end])
+			}
+			rest = rest[end+1:]
+			steps = append(steps, pathStep{index: uint32(index), list: true, path: path[:len(path)-len(rest)]})
+			if rest != "" && rest[0] != '.' && rest[0] != '[' {
+				return nil, fmt.Errorf("nbt: Path %q needs a . after ]", path)
+			}
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("nbt: Path %q has an empty key", path)
+			}
+			key := rest[:end]
+			rest = rest[end:]
+			steps = append(steps, pathStep{key: key, path: path[:len(path)-len(rest)]})
+		}
+
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" || rest[0] == '[' {
+				return nil, fmt.Errorf("nbt: Path %q has an empty key", path)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// Reads the tag at path in the root tag in in and stores it in v, following
+// the same rules as Unmarshal. Everything on the way there is skipped over
+// without being decoded, and nothing after it is read.
+//
+// A path is a list of steps from the root tag: compound fields are named by
+// their key, separated by dots, and list elements by their index in square
+// brackets, as in "Level.Sections[3].Palette" or "[0].id". The empty path is
+// the root tag itself. Keys holding dots or square brackets cannot be used.
+// If a field is missing or an index is out of range, the error wraps
+// ErrFieldNotFound.
+func DecodePath(compression Compression, in io.Reader, path string, v interface{}) error {
+	steps, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	dec := NewDecoder(compression, in)
+	if dec.err != nil {
+		return dec.err
+	}
+	return dec.state.offsetError(dec.state.decodePath(steps, v))
+}
+
+func (d *decodeState) decodePath(steps []pathStep, v interface{}) error {
+	if err := checkUnmarshalTarget(v); err != nil {
+		return err
+	}
+	d.root = d.offset()
+	_, tag, err := d.readTag()
+	if err != nil {
+		return err
+	}
+	return d.readPath(tag, steps, reflect.ValueOf(v).Elem())
+}
+
+// Reads the payload of a tag, following steps to the tag to store in v.
+func (d *decodeState) readPath(tag Tag, steps []pathStep, v reflect.Value) error {
+	if len(steps) == 0 {
+		return d.readValue(tag, v)
+	}
+	step := steps[0]
+
+	if step.list {
+		if tag != TagList {
+			return fmt.Errorf("nbt: %q needs a TAG_List, but found a %s", step.path, tag)
+		}
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+
+		elem, length, err := d.readListHeader()
+		if err != nil {
+			return err
+		}
+		if step.index >= length {
+			return fmt.Errorf("%w: %q is past the end of a list of %d elements", ErrFieldNotFound, step.path, length)
+		}
+		for i := uint32(0); i < step.index; i++ {
+			if err := d.tick(1); err != nil {
+				return err
+			}
+			if err := d.skipValue(elem); err != nil {
+				return fmt.Errorf("%w\n\t\tat list index %d", err, i)
+			}
+		}
+		if err := d.readPath(elem, steps[1:], v); err != nil {
+			return fmt.Errorf("%w\n\t\tat list index %d", err, step.index)
+		}
+		return nil
+	}
+
+	if tag != TagCompound {
+		return fmt.Errorf("nbt: %q needs a TAG_Compound, but found a %s", step.path, tag)
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	for {
+		name, tag, err := d.readTag()
+		if err != nil {
+			return err
+		}
+		if tag == TagEnd {
+			return fmt.Errorf("%w: %q", ErrFieldNotFound, step.path)
+		}
+		if name == step.key {
+			err = d.readPath(tag, steps[1:], v)
+		} else {
+			err = d.skipValue(tag)
+		}
+		if err != nil {
+			return fmt.Errorf("%w\n\t\tat struct field %#v", err, name)
+		}
+		if name == step.key {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package nbt
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	for path, expected := range map[string][]pathStep{
+		"": nil,
+		"Level.Sections[3].Palette": {
+			{key: "Level", path: "Level"},
+			{key: "Sections", path: "Level.Sections"},
+			{index: 3, list: true, path: "Level.Sections[3]"},
+			{key: "Palette", path: "Level.Sections[3].Palette"},
+		},
+		"[0][12].id": {
+			{index: 0, list: true, path: "[0]"},
+			{index: 12, list: true, path: "[0][12]"},
+			{key: "id", path: "[0][12].id"},
+		},
+		"minecraft:stone": {{key: "minecraft:stone", path: "minecraft:stone"}},
+	} {
+		steps, err := parsePath(path)
+		if err != nil {
+			t.Errorf("%q: %v", path, err)
+		} else if !reflect.DeepEqual(steps, expected) {
+			t.Errorf("%q: Found %+v, but expected %+v.", path, steps, expected)
+		}
+	}
+
+	for _, path := range []string{".a", "a.", "a..b", "a.[0]", "a[", "a[x]", "a[-1]", "a[0]b", "[]"} {
+		if _, err := parsePath(path); err == nil {
+			t.Errorf("No error for %q, but one was expected!", path)
+		}
+	}
+}
+
+func TestDecodePath(t *testing.T) {
+	type Section struct {
+		Y       int8
+		Palette []struct{ Name string }
+	}
+	data := mustMarshal(t, GZip, "", struct {
+		DataVersion int32
+		Level       struct {
+			Sections []Section
+			Status   string
+		}
+	}{DataVersion: 3465, Level: struct {
+		Sections []Section
+		Status   string
+	}{
+		Sections: []Section{
+			{Y: -1, Palette: []struct{ Name string }{{"minecraft:bedrock"}}},
+			{Y: 0},
+			{Y: 1},
+			{Y: 2, Palette: []struct{ Name string }{{"minecraft:air"}, {"minecraft:stone"}}},
+		},
+		Status: "full",
+	}})
+
+	var palette []map[string]string
+	if err := DecodePath(GZip, bytes.NewReader(data), "Level.Sections[3].Palette", &palette); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(palette, []map[string]string{{"Name": "minecraft:air"}, {"Name": "minecraft:stone"}}) {
+		t.Errorf("Found %v.", palette)
+	}
+
+	var name string
+	if err := DecodePath(GZip, bytes.NewReader(data), "Level.Sections[0].Palette[0].Name", &name); err != nil {
+		t.Fatal(err)
+	} else if name != "minecraft:bedrock" {
+		t.Errorf("Found %q, but expected minecraft:bedrock.", name)
+	}
+
+	var root map[string]interface{}
+	if err := DecodePath(GZip, bytes.NewReader(data), "", &root); err != nil {
+		t.Fatal(err)
+	} else if root["DataVersion"] != int32(3465) {
+		t.Errorf("Found %v at the empty path.", root)
+	}
+
+	for _, path := range []string{"Level.Entities", "Level.Sections[4]", "Level.Sections[1].Palette[0]"} {
+		var v interface{}
+		if err := DecodePath(GZip, bytes.NewReader(data), path, &v); !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("%q: Expected ErrFieldNotFound, but got %v.", path, err)
+		}
+	}
+	for _, path := range []string{"DataVersion.x", "Level[0]", "Level.Sections.Y", "a..b"} {
+		var v interface{}
+		err := DecodePath(GZip, bytes.NewReader(data), path, &v)
+		if err == nil || errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("%q: Expected an error other than ErrFieldNotFound, but got %v.", path, err)
+		}
+	}
+
+	var y int32
+	err := DecodePath(GZip, bytes.NewReader(data), "Level.Sections[2].Y", &y)
+	expected := "nbt: at offset 119: Tag is TAG_Byte, but I don't know how to put that in a int32!\n\t\tat struct field \"Y\"\n\t\tat list index 2\n\t\tat struct field \"Sections\"\n\t\tat struct field \"Level\""
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, but got %q.", expected, err)
+	}
+}
@@ -0,0 +1,25 @@
+package nbt
+
+import (
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// RawMessage holds a value that has not been decoded: the type of its tag as
+// a single byte, followed by its payload exactly as it appeared in the input.
+// Decoding into a RawMessage keeps a subtree around untouched, and Marshal
+// writes it back out as it is. Note that the payload stays in the byte order
+// it was read in.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+func (m *RawMessage) UnmarshalNBT(tag Tag, r io.Reader) error {
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	*m = append(append((*m)[:0], byte(tag)), payload...)
+	return nil
+}
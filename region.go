@@ -0,0 +1,168 @@
+package nbt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The size of a sector of a region file. The header takes up two sectors, and
+// every chunk starts at a sector boundary.
+const sectorSize = 4096
+
+// Returned by Region.Chunk for a chunk that has not been generated.
+var ErrNoChunk = errors.New("nbt: Chunk is not in the region")
+
+// The byte that marks how a chunk in a region file is compressed.
+var chunkCompressions = map[Compression]byte{
+	GZip:         1,
+	ZLib:         2,
+	Uncompressed: 3,
+}
+
+// A region file (.mca or .mcr), which holds the chunks of a 32 by 32 chunk
+// area of a Minecraft world, each one a separately compressed NBT document.
+type Region struct {
+	r io.ReaderAt
+
+	// The location of each chunk: the sector it starts at in the top three
+	// bytes and the number of sectors it takes up in the bottom byte.
+	locations [1024]uint32
+
+	// When each chunk was last saved, in seconds since the Unix epoch.
+	timestamps [1024]uint32
+}
+
+// Reads the header of the region file in r. The chunks are read from r by
+// Region.Chunk, so r must stay open while the Region is in use.
+func OpenRegion(r io.ReaderAt) (*Region, error) {
+	var header [2 * sectorSize]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("nbt: Reading region header: %w", err)
+	}
+
+	region := &Region{r: r}
+	for i := range region.locations {
+		region.locations[i] = binary.BigEndian.Uint32(header[4*i:])
+		region.timestamps[i] = binary.BigEndian.Uint32(header[sectorSize+4*i:])
+	}
+	return region, nil
+}
+
+// Returns the index of chunk x, z in the header. Only the low five bits of
+// each coordinate are used, so x and z may be the chunk's coordinates in the
+// world rather than in the region.
+func chunkIndex(x, z int) int {
+	return x&31 | (z&31)<<5
+}
+
+// Returns the compressed NBT document of chunk x, z along with how it is
+// compressed, ready to be passed to Unmarshal or Parse. It returns ErrNoChunk
+// if the chunk is not in the region.
+func (region *Region) Chunk(x, z int) (io.Reader, Compression, error) {
+	location := region.locations[chunkIndex(x, z)]
+	if location == 0 {
+		return nil, Uncompressed, ErrNoChunk
+	}
+	offset := int64(location>>8) * sectorSize
+	sectors := int64(location & 0xff)
+
+	var header [5]byte
+	if _, err := region.r.ReadAt(header[:], offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, Uncompressed, fmt.Errorf("nbt: Reading chunk %d, %d: %w", x, z, err)
+	}
+	length := int64(binary.BigEndian.Uint32(header[:]))
+	if length == 0 || length+4 > sectors*sectorSize {
+		return nil, Uncompressed, fmt.Errorf("nbt: Chunk %d, %d has length %d, but takes up %d sectors", x, z, length, sectors)
+	}
+
+	compression, known := Uncompressed, false
+	for c, id := range chunkCompressions {
+		if id == header[4] {
+			compression, known = c, true
+		}
+	}
+	if !known {
+		return nil, Uncompressed, fmt.Errorf("nbt: Chunk %d, %d has unknown compression type %d", x, z, header[4])
+	}
+
+	return io.NewSectionReader(region.r, offset+5, length-1), compression, nil
+}
+
+// Returns when chunk x, z was last saved, or the zero Time if the chunk is not
+// in the region.
+func (region *Region) Timestamp(x, z int) time.Time {
+	i := chunkIndex(x, z)
+	if region.locations[i] == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(region.timestamps[i]), 0)
+}
+
+// Writes a region file that Region can read. Chunks are written as they are
+// given, each one starting at a sector boundary, and the header is written by
+// Close.
+type RegionWriter struct {
+	w          io.WriterAt
+	next       uint32 // The first sector after the last chunk written.
+	locations  [1024]uint32
+	timestamps [1024]uint32
+}
+
+// Returns a RegionWriter that writes a region file to w, starting at offset 0.
+func NewRegionWriter(w io.WriterAt) *RegionWriter {
+	return &RegionWriter{w: w, next: 2}
+}
+
+// Writes v as the NBT document of chunk x, z with the given root tag name.
+// Region files only hold chunks compressed with GZip or ZLib, or not
+// compressed at all. If chunk x, z was already written, the new document
+// replaces it, and the sectors the old one used are left unused.
+func (rw *RegionWriter) WriteChunk(x, z int, compression Compression, name string, v interface{}) error {
+	id, ok := chunkCompressions[compression]
+	if !ok {
+		return fmt.Errorf("nbt: Region files cannot hold %v chunks", compression)
+	}
+	data, err := MarshalBytes(compression, name, v)
+	if err != nil {
+		return err
+	}
+
+	sectors := (5 + len(data) + sectorSize - 1) / sectorSize
+	if sectors > 0xff {
+		return fmt.Errorf("nbt: Chunk %d, %d takes up %d sectors, but at most 255 fit in a region file", x, z, sectors)
+	}
+	buf := make([]byte, sectors*sectorSize)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+1))
+	buf[4] = id
+	copy(buf[5:], data)
+	if _, err := rw.w.WriteAt(buf, int64(rw.next)*sectorSize); err != nil {
+		return err
+	}
+
+	i := chunkIndex(x, z)
+	rw.locations[i] = rw.next<<8 | uint32(sectors)
+	rw.timestamps[i] = uint32(time.Now().Unix())
+	rw.next += uint32(sectors)
+	return nil
+}
+
+// Writes the header of the region file. It does not close the underlying
+// writer.
+func (rw *RegionWriter) Close() error {
+	var header [2 * sectorSize]byte
+	for i := range rw.locations {
+		binary.BigEndian.PutUint32(header[4*i:], rw.locations[i])
+		binary.BigEndian.PutUint32(header[sectorSize+4*i:], rw.timestamps[i])
+	}
+	_, err := rw.w.WriteAt(header[:], 0)
+	return err
+}
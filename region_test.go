@@ -0,0 +1,156 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type RegionChunk struct {
+	XPos, ZPos int32
+	Status     string
+}
+
+func TestRegion(t *testing.T) {
+	chunk := RegionChunk{XPos: -31, ZPos: 2, Status: "full"}
+	payload, err := MarshalBytes(ZLib, "", chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chunk at 1, 2 is in the third sector, just after the header.
+	file := make([]byte, 3*sectorSize)
+	i := 1 + 2*32
+	binary.BigEndian.PutUint32(file[4*i:], 2<<8|1)
+	binary.BigEndian.PutUint32(file[sectorSize+4*i:], 1600000000)
+	binary.BigEndian.PutUint32(file[2*sectorSize:], uint32(len(payload)+1))
+	file[2*sectorSize+4] = 2
+	copy(file[2*sectorSize+5:], payload)
+
+	region, err := OpenRegion(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -31 and 1 name the same chunk of the region.
+	r, compression, err := region.Chunk(-31, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compression != ZLib {
+		t.Errorf("Chunk is %v, but expected zlib.", compression)
+	}
+	var result RegionChunk
+	if err := Unmarshal(compression, r, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != chunk {
+		t.Errorf("Found %#v, but expected %#v.", result, chunk)
+	}
+	if ts := region.Timestamp(1, 2); !ts.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("Timestamp is %v.", ts)
+	}
+
+	if _, _, err := region.Chunk(0, 0); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("Expected ErrNoChunk for an empty slot, but got %v.", err)
+	}
+	if ts := region.Timestamp(0, 0); !ts.IsZero() {
+		t.Errorf("Timestamp of an empty slot is %v.", ts)
+	}
+
+	// A chunk that is longer than its sectors.
+	binary.BigEndian.PutUint32(file[2*sectorSize:], sectorSize)
+	region, err = OpenRegion(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := region.Chunk(1, 2); err == nil {
+		t.Error("No error for an oversized chunk, but one was expected!")
+	}
+
+	if _, err := OpenRegion(bytes.NewReader(file[:100])); err == nil {
+		t.Error("No error for a short header, but one was expected!")
+	}
+}
+
+func TestRegionWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "r.0.0.mca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The uncompressed chunk takes up more than one sector.
+	chunks := map[[2]int]RegionChunk{
+		{0, 0}:   {XPos: 0, ZPos: 0, Status: "full"},
+		{31, 0}:  {XPos: 31, ZPos: 0, Status: "features"},
+		{5, 31}:  {XPos: 5, ZPos: 31, Status: "empty"},
+		{12, 12}: {XPos: 12, ZPos: 12, Status: strings.Repeat("large", 2000)},
+	}
+	compressions := map[[2]int]Compression{
+		{0, 0}:   GZip,
+		{31, 0}:  ZLib,
+		{5, 31}:  GZip,
+		{12, 12}: Uncompressed,
+	}
+
+	rw := NewRegionWriter(f)
+	for pos, chunk := range chunks {
+		if err := rw.WriteChunk(pos[0], pos[1], compressions[pos], "", chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.WriteChunk(1, 1, Zstd, "", RegionChunk{}); err == nil {
+		t.Error("No error for a zstd chunk, but one was expected!")
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size()%sectorSize != 0 {
+		t.Errorf("Region file is %d bytes, which is not a whole number of sectors.", info.Size())
+	}
+
+	region, err := OpenRegion(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			r, compression, err := region.Chunk(x, z)
+			expected, ok := chunks[[2]int{x, z}]
+			if !ok {
+				if !errors.Is(err, ErrNoChunk) {
+					t.Errorf("Chunk %d, %d: Expected ErrNoChunk, but got %v.", x, z, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Chunk %d, %d: %v", x, z, err)
+				continue
+			}
+			if compression != compressions[[2]int{x, z}] {
+				t.Errorf("Chunk %d, %d is %v, but expected %v.", x, z, compression, compressions[[2]int{x, z}])
+			}
+
+			var result RegionChunk
+			if err := Unmarshal(compression, r, &result); err != nil {
+				t.Errorf("Chunk %d, %d: %v", x, z, err)
+			} else if result != expected {
+				t.Errorf("Chunk %d, %d: Found %#v, but expected %#v.", x, z, result, expected)
+			}
+			if region.Timestamp(x, z).IsZero() {
+				t.Errorf("Chunk %d, %d has no timestamp.", x, z)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+)
+
+// What a Token is.
+type TokenKind uint8
+
+const (
+	// Any tag that is not a TAG_List or TAG_Compound. Tag and Value are
+	// set.
+	Scalar TokenKind = iota
+
+	// The start of a TAG_Compound. Its tags follow, up to the matching
+	// CompoundEnd.
+	CompoundStart
+
+	// The end of the innermost TAG_Compound.
+	CompoundEnd
+
+	// The start of a TAG_List. Elem and Len are set, and Len elements
+	// follow, up to the matching ListEnd.
+	ListStart
+
+	// The end of the innermost TAG_List.
+	ListEnd
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case Scalar:
+		return "Scalar"
+	case CompoundStart:
+		return "CompoundStart"
+	case CompoundEnd:
+		return "CompoundEnd"
+	case ListStart:
+		return "ListStart"
+	case ListEnd:
+		return "ListEnd"
+	}
+	return fmt.Sprintf("TokenKind(%d)", uint8(k))
+}
+
+// A piece of an NBT document, as returned by Scanner.Next.
+type Token struct {
+	Kind TokenKind
+
+	// The tag the token is part of. It is TagCompound for CompoundStart and
+	// CompoundEnd and TagList for ListStart and ListEnd.
+	Tag Tag
+
+	// The name of the tag, for a tag in a compound or the root tag. List
+	// elements and the ends of lists and compounds have no name.
+	Name string
+
+	// The tag of the elements and the number of them, for ListStart.
+	Elem Tag
+	Len  int
+
+	// The payload of a Scalar, using the Go types Unmarshal picks for an
+	// interface{}.
+	Value interface{}
+}
+
+// Reads an NBT document one token at a time, for programs that need to
+// handle documents too big to hold in memory or to copy them while changing
+// parts along the way.
+type Scanner struct {
+	dec   *Decoder
+	stack []scanFrame
+}
+
+// A list or compound that the Scanner is in.
+type scanFrame struct {
+	list bool
+	elem Tag
+	left uint32 // The elements of a list that are still to be read.
+}
+
+// Returns a Scanner that reads from in, decompressing it if needed.
+func NewScanner(compression Compression, in io.Reader) *Scanner {
+	return &Scanner{dec: NewDecoder(compression, in)}
+}
+
+// Returns the next token. Once a root tag has been read, the next call
+// starts on the one after it, and io.EOF is returned if there is none. Any
+// other error stops the Scanner, and every later call returns it again.
+func (s *Scanner) Next() (Token, error) {
+	if s.dec.err != nil {
+		return Token{}, s.dec.err
+	}
+	t, err := s.next()
+	if err != nil {
+		s.dec.err = s.dec.state.offsetError(err)
+		// Running out of input between root tags is not an error.
+		if err == io.EOF {
+			s.dec.err = io.EOF
+		}
+	}
+	return t, s.dec.err
+}
+
+func (s *Scanner) next() (Token, error) {
+	d := &s.dec.state
+
+	var name string
+	var tag Tag
+	var err error
+	if len(s.stack) == 0 {
+		d.root = d.offset()
+		if name, tag, err = d.readTag(); err != nil {
+			return Token{}, err
+		}
+		if tag == TagEnd {
+			return Token{}, fmt.Errorf("nbt: Root tag is TAG_End")
+		}
+	} else if top := &s.stack[len(s.stack)-1]; top.list {
+		if top.left == 0 {
+			s.pop()
+			return Token{Kind: ListEnd, Tag: TagList}, nil
+		}
+		if err := d.tick(1); err != nil {
+			return Token{}, err
+		}
+		top.left--
+		tag = top.elem
+	} else {
+		if name, tag, err = d.readTag(); err != nil {
+			return Token{}, err
+		}
+		if tag == TagEnd {
+			s.pop()
+			return Token{Kind: CompoundEnd, Tag: TagCompound}, nil
+		}
+	}
+
+	switch tag {
+	case TagCompound:
+		if err := d.enter(); err != nil {
+			return Token{}, err
+		}
+		s.stack = append(s.stack, scanFrame{})
+		return Token{Kind: CompoundStart, Tag: tag, Name: name}, nil
+
+	case TagList:
+		if err := d.enter(); err != nil {
+			return Token{}, err
+		}
+		elem, length, err := d.readListHeader()
+		if err != nil {
+			return Token{}, err
+		}
+		s.stack = append(s.stack, scanFrame{list: true, elem: elem, left: length})
+		return Token{Kind: ListStart, Tag: tag, Name: name, Elem: elem, Len: int(length)}, nil
+	}
+
+	value, err := d.allocate(tag)
+	if err != nil {
+		return Token{}, err
+	}
+	if err := d.readValue(tag, value); err != nil {
+		return Token{}, err
+	}
+	return Token{Kind: Scalar, Tag: tag, Name: name, Value: value.Interface()}, nil
+}
+
+func (s *Scanner) pop() {
+	s.stack = s.stack[:len(s.stack)-1]
+	s.dec.state.leave()
+}
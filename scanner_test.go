@@ -0,0 +1,91 @@
+package nbt
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	data, err := MarshalBytes(GZip, "Data", struct {
+		X     int32 `nbt:"x"`
+		Pos   []float64
+		Items []struct{ ID string }
+		Owner struct{ Name string }
+		Empty []int32
+	}{
+		X:     5,
+		Pos:   []float64{1, 2.5, -3},
+		Items: []struct{ ID string }{{"stone"}, {"dirt"}},
+		Owner: struct{ Name string }{"Notch"},
+		Empty: []int32{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second root tag after the first.
+	data = append(data, mustMarshal(t, GZip, "", int8(7))...)
+
+	expected := []Token{
+		{Kind: CompoundStart, Tag: TagCompound, Name: "Data"},
+		{Kind: Scalar, Tag: TagInt, Name: "x", Value: int32(5)},
+		{Kind: ListStart, Tag: TagList, Name: "Pos", Elem: TagDouble, Len: 3},
+		{Kind: Scalar, Tag: TagDouble, Value: 1.0},
+		{Kind: Scalar, Tag: TagDouble, Value: 2.5},
+		{Kind: Scalar, Tag: TagDouble, Value: -3.0},
+		{Kind: ListEnd, Tag: TagList},
+		{Kind: ListStart, Tag: TagList, Name: "Items", Elem: TagCompound, Len: 2},
+		{Kind: CompoundStart, Tag: TagCompound},
+		{Kind: Scalar, Tag: TagString, Name: "ID", Value: "stone"},
+		{Kind: CompoundEnd, Tag: TagCompound},
+		{Kind: CompoundStart, Tag: TagCompound},
+		{Kind: Scalar, Tag: TagString, Name: "ID", Value: "dirt"},
+		{Kind: CompoundEnd, Tag: TagCompound},
+		{Kind: ListEnd, Tag: TagList},
+		{Kind: CompoundStart, Tag: TagCompound, Name: "Owner"},
+		{Kind: Scalar, Tag: TagString, Name: "Name", Value: "Notch"},
+		{Kind: CompoundEnd, Tag: TagCompound},
+		{Kind: ListStart, Tag: TagList, Name: "Empty", Elem: TagInt},
+		{Kind: ListEnd, Tag: TagList},
+		{Kind: CompoundEnd, Tag: TagCompound},
+		{Kind: Scalar, Tag: TagByte, Value: int8(7)},
+	}
+
+	s := NewScanner(AutoDetect, bytes.NewReader(data))
+	for i, want := range expected {
+		got, err := s.Next()
+		if err != nil {
+			t.Fatalf("Token %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Token %d is %+v, but expected %+v.", i, got, want)
+		}
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last root tag, but got %v.", err)
+	}
+
+	truncated := []byte{byte(TagCompound), 0, 0, byte(TagList), 0, 1, 'l', byte(TagInt), 0, 0, 0, 2, 0, 0, 0, 1}
+	s = NewScanner(Uncompressed, bytes.NewReader(truncated))
+	var n int
+	for ; n < 10; n++ {
+		if _, err = s.Next(); err != nil {
+			break
+		}
+	}
+	if n != 3 || err == nil || err == io.EOF {
+		t.Errorf("Read %d tokens of a truncated list, then %v.", n, err)
+	}
+	if _, again := s.Next(); again != err {
+		t.Errorf("Expected the same error again, but got %v.", again)
+	}
+}
+
+func mustMarshal(t *testing.T, compression Compression, name string, v interface{}) []byte {
+	data, err := MarshalBytes(compression, name, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,627 @@
+package nbt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parses SNBT, the text form of NBT used in Minecraft commands and data
+// packs, such as {Health:20f,Pos:[0.0d,64.0d,0.0d],Name:"Steve"}.
+//
+// Unquoted numbers take their tag from their suffix: b for TAG_Byte, s for
+// TAG_Short, l for TAG_Long, f for TAG_Float and d for TAG_Double, in either
+// case. Without a suffix, whole numbers are TAG_Int and anything with a
+// decimal point or exponent is TAG_Double. true and false are TAG_Bytes.
+// Any other unquoted word, including a number that doesn't fit its tag, is a
+// TAG_String, which is also what Minecraft does.
+func ParseSNBT(s string) (Node, error) {
+	p := &snbtParser{s: s}
+	n, err := p.parseValue()
+	if err != nil {
+		return Node{}, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return Node{}, p.errorf("Unexpected %q after the value", p.s[p.pos:])
+	}
+	return n, nil
+}
+
+// Parses SNBT and stores the result in the value pointed to by v, following
+// the same rules as Unmarshal.
+func UnmarshalSNBT(s string, v interface{}) error {
+	n, err := ParseSNBT(s)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := Write(Uncompressed, &buf, "", n); err != nil {
+		return err
+	}
+	return Unmarshal(Uncompressed, &buf, v)
+}
+
+// Returns the SNBT form of v, which may be a Node or anything Marshal
+// accepts. Numbers are written the way Minecraft writes them, with a suffix
+// for every tag except TAG_Int, and compound keys are sorted and only quoted
+// when they need to be.
+func MarshalSNBT(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	var err error
+	if n, ok := v.(Node); ok {
+		err = Write(Uncompressed, &buf, "", n)
+	} else {
+		err = Marshal(Uncompressed, &buf, "", v)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Reading it back leaves a Node that is known to be well formed.
+	n, err := Parse(Uncompressed, &buf)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	writeSNBT(&b, n)
+	return b.String(), nil
+}
+
+func writeSNBT(b *strings.Builder, n Node) {
+	switch v := n.Value.(type) {
+	case int8:
+		b.WriteString(strconv.FormatInt(int64(v), 10) + "b")
+	case int16:
+		b.WriteString(strconv.FormatInt(int64(v), 10) + "s")
+	case int32:
+		b.WriteString(strconv.FormatInt(int64(v), 10))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10) + "L")
+	case float32:
+		b.WriteString(formatSNBTFloat(float64(v), 32) + "f")
+	case float64:
+		b.WriteString(formatSNBTFloat(v, 64) + "d")
+	case string:
+		b.WriteString(quoteSNBT(v))
+
+	case []byte:
+		b.WriteString("[B;")
+		for i, e := range v {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.FormatInt(int64(int8(e)), 10) + "b")
+		}
+		b.WriteByte(']')
+
+	case []int32:
+		b.WriteString("[I;")
+		for i, e := range v {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.FormatInt(int64(e), 10))
+		}
+		b.WriteByte(']')
+
+	case []int64:
+		b.WriteString("[L;")
+		for i, e := range v {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.FormatInt(e, 10) + "L")
+		}
+		b.WriteByte(']')
+
+	case List:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			writeSNBT(b, e)
+		}
+		b.WriteByte(']')
+
+	case Compound:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		b.WriteByte('{')
+		for i, name := range names {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			if isUnquoted(name) {
+				b.WriteString(name)
+			} else {
+				b.WriteString(quoteSNBT(name))
+			}
+			b.WriteByte(':')
+			writeSNBT(b, v[name])
+		}
+		b.WriteByte('}')
+	}
+}
+
+// Formats f the way Java's Float.toString and Double.toString do, which
+// always include a decimal point.
+func formatSNBTFloat(f float64, bits int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-3 || abs >= 1e7) {
+		s := strconv.FormatFloat(f, 'E', -1, bits)
+		mantissa, exponent := s[:strings.IndexByte(s, 'E')], s[strings.IndexByte(s, 'E')+1:]
+		if !strings.Contains(mantissa, ".") {
+			mantissa += ".0"
+		}
+		exponent = strings.TrimPrefix(exponent, "+")
+		if exponent[0] == '-' {
+			exponent = "-" + strings.TrimLeft(exponent[1:], "0")
+		} else {
+			exponent = strings.TrimLeft(exponent, "0")
+		}
+		return mantissa + "E" + exponent
+	}
+
+	s := strconv.FormatFloat(f, 'f', -1, bits)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// Reports whether s can be written as a compound key without quotes.
+func isUnquoted(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isUnquotedChar(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Quotes s in double quotes, or in single quotes if s contains a double
+// quote before any single quote, escaping only what has to be.
+func quoteSNBT(s string) string {
+	quote := byte('"')
+	if i := strings.IndexAny(s, "\"'"); i != -1 && s[i] == '"' {
+		quote = '\''
+	}
+
+	var b strings.Builder
+	b.WriteByte(quote)
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' || s[i] == quote {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte(quote)
+	return b.String()
+}
+
+type snbtParser struct {
+	s     string
+	pos   int
+	depth int
+}
+
+func (p *snbtParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("nbt: "+format+" at offset %d of SNBT", append(args, p.pos)...)
+}
+
+func (p *snbtParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) != -1 {
+		p.pos++
+	}
+}
+
+// Skips whitespace and then reports whether the next byte is c, consuming
+// it if so.
+func (p *snbtParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *snbtParser) expect(c byte) error {
+	if !p.accept(c) {
+		if p.pos == len(p.s) {
+			return p.errorf("Expected %q but found the end", c)
+		}
+		return p.errorf("Expected %q but found %q", c, p.s[p.pos])
+	}
+	return nil
+}
+
+func isUnquotedChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' ||
+		c == '_' || c == '-' || c == '.' || c == '+'
+}
+
+func (p *snbtParser) readUnquoted() string {
+	start := p.pos
+	for p.pos < len(p.s) && isUnquotedChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// Reads a string in single or double quotes, the opening quote being the
+// next byte.
+func (p *snbtParser) readQuoted() (string, error) {
+	quote := p.s[p.pos]
+	p.pos++
+
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return b.String(), nil
+
+		case '\\':
+			if p.pos == len(p.s) {
+				return "", p.errorf("Unterminated escape sequence")
+			}
+			c = p.s[p.pos]
+			p.pos++
+			switch c {
+			case '\\', '"', '\'':
+				b.WriteByte(c)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'x', 'u', 'U':
+				digits := 2
+				if c == 'u' {
+					digits = 4
+				} else if c == 'U' {
+					digits = 8
+				}
+				if p.pos+digits > len(p.s) {
+					return "", p.errorf("Truncated \\%c escape", c)
+				}
+				r, err := strconv.ParseUint(p.s[p.pos:p.pos+digits], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", p.errorf("Invalid \\%c escape %q", c, p.s[p.pos:p.pos+digits])
+				}
+				p.pos += digits
+				b.WriteRune(rune(r))
+			default:
+				return "", p.errorf("Invalid escape sequence \\%c", c)
+			}
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("Unterminated string")
+}
+
+func (p *snbtParser) readKey() (string, error) {
+	p.skipSpace()
+	if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+		return p.readQuoted()
+	}
+	key := p.readUnquoted()
+	if key == "" {
+		return "", p.errorf("Expected a key")
+	}
+	return key, nil
+}
+
+func (p *snbtParser) parseValue() (Node, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return Node{}, p.errorf("Expected a value but found the end")
+	}
+
+	switch c := p.s[p.pos]; c {
+	case '{':
+		return p.parseCompound()
+	case '[':
+		return p.parseList()
+	case '"', '\'':
+		s, err := p.readQuoted()
+		return Node{Tag: TagString, Value: s}, err
+	}
+
+	word := p.readUnquoted()
+	if word == "" {
+		return Node{}, p.errorf("Unexpected %q", p.s[p.pos])
+	}
+	return parseScalar(word), nil
+}
+
+// Called when starting to parse a list or compound. Every call that
+// returns nil must be followed by a call to leave.
+func (p *snbtParser) enter() error {
+	if p.depth >= DefaultMaxDepth {
+		return p.errorf("Lists and compounds are nested more than %d deep", DefaultMaxDepth)
+	}
+	p.depth++
+	return nil
+}
+
+func (p *snbtParser) leave() {
+	p.depth--
+}
+
+func (p *snbtParser) parseCompound() (Node, error) {
+	if err := p.enter(); err != nil {
+		return Node{}, err
+	}
+	defer p.leave()
+
+	p.pos++ // {
+	compound := make(Compound)
+	if p.accept('}') {
+		return Node{Tag: TagCompound, Value: compound}, nil
+	}
+
+	for {
+		key, err := p.readKey()
+		if err != nil {
+			return Node{}, err
+		}
+		if err := p.expect(':'); err != nil {
+			return Node{}, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return Node{}, err
+		}
+		value.Name = key
+		compound[key] = value
+
+		if p.accept('}') {
+			return Node{Tag: TagCompound, Value: compound}, nil
+		}
+		if err := p.expect(','); err != nil {
+			return Node{}, err
+		}
+	}
+}
+
+func (p *snbtParser) parseList() (Node, error) {
+	if err := p.enter(); err != nil {
+		return Node{}, err
+	}
+	defer p.leave()
+
+	p.pos++ // [
+	if p.pos+1 < len(p.s) && p.s[p.pos+1] == ';' {
+		switch p.s[p.pos] {
+		case 'B':
+			return p.parseArray(TagByteArray, TagByte)
+		case 'I':
+			return p.parseArray(TagIntArray, TagInt)
+		case 'L':
+			return p.parseArray(TagLongArray, TagLong)
+		}
+		return Node{}, p.errorf("Invalid array type %q", p.s[p.pos])
+	}
+
+	n := Node{Tag: TagList, Value: List{}}
+	if p.accept(']') {
+		return n, nil
+	}
+
+	var list List
+	for {
+		elem, err := p.parseValue()
+		if err != nil {
+			return Node{}, err
+		}
+		if len(list) != 0 && elem.Tag != n.Elem {
+			return Node{}, p.errorf("Can't put %s in a TAG_List of %s", elem.Tag, n.Elem)
+		}
+		n.Elem = elem.Tag
+		list = append(list, elem)
+
+		if p.accept(']') {
+			n.Value = list
+			return n, nil
+		}
+		if err := p.expect(','); err != nil {
+			return Node{}, err
+		}
+	}
+}
+
+// Parses the elements of [B;...], [I;...] or [L;...], just after the [.
+// Whole numbers without a suffix are accepted in any of them.
+func (p *snbtParser) parseArray(tag, elem Tag) (Node, error) {
+	p.pos += 2 // B;
+
+	var byteArray []byte
+	var intArray []int32
+	var longArray []int64
+	if !p.accept(']') {
+		for {
+			p.skipSpace()
+			word := p.readUnquoted()
+			value := parseScalar(word)
+			if value.Tag == TagInt && elem != TagInt {
+				v := value.Value.(int32)
+				if elem == TagByte && (v < math.MinInt8 || v > math.MaxInt8) {
+					return Node{}, p.errorf("Can't put %q in a %s", word, tag)
+				}
+				if elem == TagByte {
+					value = Node{Tag: TagByte, Value: int8(v)}
+				} else {
+					value = Node{Tag: TagLong, Value: int64(v)}
+				}
+			}
+			if value.Tag != elem {
+				return Node{}, p.errorf("Can't put %q in a %s", word, tag)
+			}
+
+			switch v := value.Value.(type) {
+			case int8:
+				byteArray = append(byteArray, byte(v))
+			case int32:
+				intArray = append(intArray, v)
+			case int64:
+				longArray = append(longArray, v)
+			}
+
+			if p.accept(']') {
+				break
+			}
+			if err := p.expect(','); err != nil {
+				return Node{}, err
+			}
+		}
+	}
+
+	switch tag {
+	case TagByteArray:
+		if byteArray == nil {
+			byteArray = []byte{}
+		}
+		return Node{Tag: tag, Value: byteArray}, nil
+	case TagIntArray:
+		if intArray == nil {
+			intArray = []int32{}
+		}
+		return Node{Tag: tag, Value: intArray}, nil
+	}
+	if longArray == nil {
+		longArray = []int64{}
+	}
+	return Node{Tag: tag, Value: longArray}, nil
+}
+
+// Works out what an unquoted word means, falling back to a TAG_String.
+func parseScalar(word string) Node {
+	switch strings.ToLower(word) {
+	case "true":
+		return Node{Tag: TagByte, Value: int8(1)}
+	case "false":
+		return Node{Tag: TagByte, Value: int8(0)}
+	}
+
+	if len(word) > 1 && isInteger(word[:len(word)-1]) {
+		digits := word[:len(word)-1]
+		switch word[len(word)-1] {
+		case 'b', 'B':
+			if v, err := strconv.ParseInt(digits, 10, 8); err == nil {
+				return Node{Tag: TagByte, Value: int8(v)}
+			}
+		case 's', 'S':
+			if v, err := strconv.ParseInt(digits, 10, 16); err == nil {
+				return Node{Tag: TagShort, Value: int16(v)}
+			}
+		case 'l', 'L':
+			if v, err := strconv.ParseInt(digits, 10, 64); err == nil {
+				return Node{Tag: TagLong, Value: v}
+			}
+		}
+	}
+
+	if isInteger(word) {
+		if v, err := strconv.ParseInt(word, 10, 32); err == nil {
+			return Node{Tag: TagInt, Value: int32(v)}
+		}
+		return Node{Tag: TagString, Value: word}
+	}
+
+	if len(word) > 1 && isDecimal(word[:len(word)-1]) {
+		digits := word[:len(word)-1]
+		switch word[len(word)-1] {
+		case 'f', 'F':
+			if v, err := strconv.ParseFloat(digits, 32); err == nil {
+				return Node{Tag: TagFloat, Value: float32(v)}
+			}
+		case 'd', 'D':
+			if v, err := strconv.ParseFloat(digits, 64); err == nil {
+				return Node{Tag: TagDouble, Value: v}
+			}
+		}
+	}
+
+	if isDecimal(word) && strings.ContainsAny(word, ".eE") {
+		if v, err := strconv.ParseFloat(word, 64); err == nil {
+			return Node{Tag: TagDouble, Value: v}
+		}
+	}
+
+	return Node{Tag: TagString, Value: word}
+}
+
+// Reports whether s is an optionally signed run of decimal digits.
+func isInteger(s string) bool {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether s is a number in the form Minecraft accepts for floats and
+// doubles: digits with an optional decimal point and exponent.
+func isDecimal(s string) bool {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	mantissa, exponent := s, ""
+	if i := strings.IndexAny(s, "eE"); i != -1 {
+		mantissa, exponent = s[:i], s[i+1:]
+		if !isInteger(exponent) {
+			return false
+		}
+	}
+
+	digits := 0
+	point := false
+	for i := 0; i < len(mantissa); i++ {
+		switch c := mantissa[i]; {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.' && !point:
+			point = true
+		default:
+			return false
+		}
+	}
+	return digits != 0
+}
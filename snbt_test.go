@@ -0,0 +1,210 @@
+package nbt
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type SNBTPlayer struct {
+	Health float32
+	Pos    []float64
+	Name   string
+}
+
+func TestUnmarshalSNBT(t *testing.T) {
+	var result SNBTPlayer
+	if err := UnmarshalSNBT(`{Health:20f,Pos:[0.0d,64.0d,0.0d],Name:"Steve"}`, &result); err != nil {
+		t.Fatal(err)
+	}
+	expected := SNBTPlayer{Health: 20, Pos: []float64{0, 64, 0}, Name: "Steve"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Found %#v, but expected %#v.", result, expected)
+	}
+
+	var inventory struct {
+		Items []struct{ ID string }
+	}
+	if err := UnmarshalSNBT(`{Items:[]}`, &inventory); err != nil {
+		t.Fatal(err)
+	}
+	if inventory.Items == nil || len(inventory.Items) != 0 {
+		t.Errorf("Found %#v, but expected no items.", inventory.Items)
+	}
+}
+
+func TestParseSNBT(t *testing.T) {
+	n, err := ParseSNBT(` { 'quoted key' : "a \"b\" \\ é\n" , bare-key.1:'it\'s', b: 1b, s: -2S, i: 3, l: 4L,
+		f: 1.5F, d: .25, e: 1e3, t: true, word: hello, big: 99999999999, list: [[1, 2], [], [3]],
+		compounds: [{}, {x: 1}], bytes: [B; 1b, -2], ints: [I;], longs: [L; 1l, 2] } `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]Node{
+		"quoted key": {Tag: TagString, Value: "a \"b\" \\ é\n"},
+		"bare-key.1": {Tag: TagString, Value: "it's"},
+		"b":          {Tag: TagByte, Value: int8(1)},
+		"s":          {Tag: TagShort, Value: int16(-2)},
+		"i":          {Tag: TagInt, Value: int32(3)},
+		"l":          {Tag: TagLong, Value: int64(4)},
+		"f":          {Tag: TagFloat, Value: float32(1.5)},
+		"d":          {Tag: TagDouble, Value: 0.25},
+		"e":          {Tag: TagDouble, Value: 1000.0},
+		"t":          {Tag: TagByte, Value: int8(1)},
+		"word":       {Tag: TagString, Value: "hello"},
+		"big":        {Tag: TagString, Value: "99999999999"},
+		"bytes":      {Tag: TagByteArray, Value: []byte{1, 0xfe}},
+		"ints":       {Tag: TagIntArray, Value: []int32{}},
+		"longs":      {Tag: TagLongArray, Value: []int64{1, 2}},
+	} {
+		expected.Name = name
+		if found, ok := n.Get(name); !ok {
+			t.Errorf("%s is missing.", name)
+		} else if !reflect.DeepEqual(found, expected) {
+			t.Errorf("%s is %#v, but expected %#v.", name, found, expected)
+		}
+	}
+
+	list, _ := n.Get("list")
+	if list.Elem != TagList || list.Len() != 3 {
+		t.Fatalf("list is %d %s.", list.Len(), list.Elem)
+	}
+	if inner := list.Index(0); inner.Elem != TagInt || inner.Index(1).Int() != 2 {
+		t.Errorf("list[0] is %#v.", inner)
+	}
+	if inner := list.Index(1); inner.Elem != TagEnd || inner.Len() != 0 {
+		t.Errorf("list[1] is %#v.", inner)
+	}
+	compounds, _ := n.Get("compounds")
+	if compounds.Elem != TagCompound || compounds.Len() != 2 || compounds.Index(1).Len() != 1 {
+		t.Errorf("compounds is %#v.", compounds)
+	}
+}
+
+func TestParseSNBTErrors(t *testing.T) {
+	for s, expected := range map[string]string{
+		`{a:1`:                                 `Expected ',' but found the end`,
+		`{a 1}`:                                `Expected ':'`,
+		`[1, 2b]`:                              `Can't put TAG_Byte in a TAG_List of TAG_Int`,
+		`[B; 1, 300]`:                          `Can't put "300" in a TAG_Byte_Array`,
+		`[I; 1b]`:                              `Can't put "1b" in a TAG_Int_Array`,
+		`[Q; 1]`:                               `Invalid array type 'Q'`,
+		`"abc`:                                 `Unterminated string`,
+		`"\q"`:                                 `Invalid escape sequence \q`,
+		`{} x`:                                 `Unexpected "x" after the value`,
+		`{a:}`:                                 `Unexpected '}'`,
+		``:                                     `Expected a value but found the end`,
+		strings.Repeat("[", DefaultMaxDepth+1): `nested more than`,
+	} {
+		_, err := ParseSNBT(s)
+		if err == nil {
+			t.Errorf("No error parsing %q.", s)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Parsing %q: expected %q in %q.", s, expected, err)
+		}
+	}
+}
+
+type SNBTScalars struct {
+	Byte   int8
+	Short  int16
+	Int    int32
+	Long   int64
+	Float  float32
+	Double float64
+	String string
+	Bytes  []byte
+	Ints   [2]int32
+	Longs  [1]int64
+	Nested struct {
+		List    []string
+		Strange int32 `nbt:"key with spaces"`
+	}
+}
+
+func TestMarshalSNBT(t *testing.T) {
+	v := SNBTScalars{
+		Byte:   -1,
+		Short:  300,
+		Int:    20,
+		Long:   1 << 40,
+		Float:  20,
+		Double: 0.1,
+		String: `say "hi"`,
+		Bytes:  []byte{1, 0xff},
+		Ints:   [2]int32{1, -2},
+		Longs:  [1]int64{3},
+	}
+	v.Nested.List = []string{"a", "it's"}
+	v.Nested.Strange = 7
+
+	s, err := MarshalSNBT(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{Byte:-1b,Bytes:[B;1b,-1b],Double:0.1d,Float:20.0f,Int:20,Ints:[I;1,-2],Long:1099511627776L,Longs:[L;3L],` +
+		`Nested:{List:["a","it's"],"key with spaces":7},Short:300s,String:'say "hi"'}`
+	if s != expected {
+		t.Errorf("Found    %s", s)
+		t.Logf("Expected %s", expected)
+	}
+
+	var result SNBTScalars
+	if err := UnmarshalSNBT(s, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, v) {
+		t.Errorf("Found %#v, but expected %#v.", result, v)
+	}
+}
+
+func TestFormatSNBTFloat(t *testing.T) {
+	for _, c := range []struct {
+		f        float64
+		bits     int
+		expected string
+	}{
+		{0, 64, "0.0"},
+		{-2.5, 64, "-2.5"},
+		{1e7, 64, "1.0E7"},
+		{1.5e-5, 64, "1.5E-5"},
+		{0.001, 64, "0.001"},
+		{float64(float32(0.1)), 32, "0.1"},
+		{math.Inf(-1), 64, "-Infinity"},
+	} {
+		if s := formatSNBTFloat(c.f, c.bits); s != c.expected {
+			t.Errorf("%v formatted as %s, but expected %s.", c.f, s, c.expected)
+		}
+	}
+}
+
+func TestMarshalSNBTNode(t *testing.T) {
+	n := Node{Tag: TagList, Value: List{{Tag: TagDouble, Value: 1e21}, {Tag: TagDouble, Value: -0.5}}}
+	if s, err := MarshalSNBT(n); err != nil {
+		t.Error(err)
+	} else if s != "[1.0E21d,-0.5d]" {
+		t.Errorf("Found %s.", s)
+	}
+
+	if _, err := MarshalSNBT(Node{Tag: TagList}); err == nil {
+		t.Error("No error for an untyped list.")
+	}
+
+	// Empty lists as they are parsed, and as Marshal writes them.
+	parsed, err := ParseSNBT(`{Items:[],Nested:[[]]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []interface{}{
+		parsed,
+		map[string]interface{}{"Items": []interface{}{}, "Nested": [][]int32{{}}},
+	} {
+		if s, err := MarshalSNBT(v); err != nil {
+			t.Errorf("%T: %v", v, err)
+		} else if s != "{Items:[],Nested:[[]]}" {
+			t.Errorf("%T: Found %s.", v, s)
+		}
+	}
+}
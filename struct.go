@@ -3,32 +3,361 @@ package nbt
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
-func parseStruct(v reflect.Value) map[string]reflect.Value {
-	parsed := make(map[string]reflect.Value)
-	t := v.Type()
+// A struct field as seen by the encoder and decoder.
+type structField struct {
+	name      string
+	index     []int // For fieldByIndex.
+	omitEmpty bool
 
+	// From ",union=key": the compound field whose value picks the type of
+	// the compounds held in interfaces in this field. See RegisterType.
+	union string
+
+	// From ",extra": the field is a map holding every compound field that
+	// no other field has a place for.
+	extra bool
+
+	// From ",type=short" and the like: the tag a number is read and written
+	// as in place of the one its Go type would get, or TagEnd.
+	tag Tag
+}
+
+// The fields of a struct type, worked out once and then kept in fieldCache.
+type structInfo struct {
+	fields   []structField // In declaration order.
+	byName   map[string]structField
+	extra    structField
+	hasExtra bool
+
+	// Set, in place of the rest, if the struct tags don't make sense.
+	err error
+}
+
+// Maps each struct type that has been encoded or decoded to its *structInfo.
+var fieldCache sync.Map
+
+// Returns the fields of struct type t, working them out the first time t is
+// seen. The result is shared and must not be modified.
+func cachedStructInfo(t reflect.Type) (*structInfo, error) {
+	if info, ok := fieldCache.Load(t); ok {
+		info := info.(*structInfo)
+		return info, info.err
+	}
+
+	fields, err := typeFields(t)
+	info := &structInfo{fields: fields, err: err}
+	info.byName = make(map[string]structField, len(info.fields))
+	for _, f := range info.fields {
+		if f.extra {
+			info.extra, info.hasExtra = f, true
+		} else {
+			info.byName[f.name] = f
+		}
+	}
+	cached, _ := fieldCache.LoadOrStore(t, info)
+	info = cached.(*structInfo)
+	return info, info.err
+}
+
+// Like cachedStructInfo, but panics with the error, for the encoder.
+func mustStructInfo(t reflect.Type) *structInfo {
+	info, err := cachedStructInfo(t)
+	if err != nil {
+		panic(err)
+	}
+	return info
+}
+
+// Returns the fields of struct type t, in declaration order. It panics if
+// their tags don't make sense.
+func structFields(t reflect.Type) []structField {
+	return mustStructInfo(t).fields
+}
+
+// Works out the fields of struct type t for cachedStructInfo, or returns an
+// error if their tags don't make sense.
+//
+// The fields of embedded structs and pointers to structs without a name in
+// their tag are promoted into t, as encoding/json does. Where several fields
+// end up with the same name, the least deeply embedded one wins, and if there
+// is a tie below the top level, the one with a name in its tag. Otherwise they
+// cancel each other out. Names given twice in t itself are an error.
+func typeFields(t reflect.Type) ([]structField, error) {
+	var candidates []fieldCandidate
+	if err := collectFields(t, nil, map[reflect.Type]bool{t: true}, &candidates); err != nil {
+		return nil, err
+	}
+
+	// The extra field competes with other extra fields as if by name.
+	key := func(f structField) string {
+		if f.extra {
+			return ",extra"
+		}
+		return f.name
+	}
+	byKey := make(map[string][]fieldCandidate)
+	var keys []string
+	for _, c := range candidates {
+		k := key(c.structField)
+		if byKey[k] == nil {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], c)
+	}
+
+	var fields []structField
+	for _, k := range keys {
+		f, ok, err := dominantField(t, byKey[k])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fields = append(fields, f)
+		}
+	}
+
+	// Embedded fields go where the struct holding them was declared.
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for n := 0; n < len(a) && n < len(b); n++ {
+			if a[n] != b[n] {
+				return a[n] < b[n]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return fields, nil
+}
+
+// A field that may or may not be hidden by another one of the same name.
+type fieldCandidate struct {
+	structField
+	tagged bool // The struct tag gave the name.
+}
+
+// Adds the fields of struct type t, which is embedded at index, to fields.
+// Types in outer are the ones t is embedded in, which a struct that embeds a
+// pointer to itself would otherwise go through forever.
+func collectFields(t reflect.Type, index []int, outer map[reflect.Type]bool, fields *[]fieldCandidate) error {
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
-		if f.Anonymous {
+		tag := f.Tag.Get("nbt")
+
+		field := fieldCandidate{structField: structField{name: f.Name}}
+		field.index = append(append([]int(nil), index...), i)
+		if tag != "" {
+			if err := parseTag(tag, &field.structField); err != nil {
+				return fmt.Errorf("nbt: Field %s of %s has a bad struct tag: %w", f.Name, t, err)
+			}
+			field.tagged = field.name != ""
+			if field.name == "" {
+				field.name = f.Name
+			}
+		}
+		if field.name == "-" {
 			continue
 		}
 
-		name := f.Name
-		if tag := f.Tag.Get("nbt"); tag != "" {
-			name = tag
+		if f.Anonymous {
+			embedded := f.Type
+			// A pointer to an unexported type can't be allocated.
+			if embedded.Kind() == reflect.Ptr && f.PkgPath == "" {
+				embedded = embedded.Elem()
+			}
+			if !field.tagged && embedded.Kind() == reflect.Struct && !outer[embedded] {
+				outer[embedded] = true
+				err := collectFields(embedded, field.index, outer, fields)
+				delete(outer, embedded)
+				if err != nil {
+					return err
+				}
+			}
+			if !field.tagged {
+				continue
+			}
 		}
-		if name == "-" {
+		// Unexported fields can't be set, as in encoding/json.
+		if f.PkgPath != "" {
 			continue
 		}
 
-		if _, exists := parsed[name]; exists {
-			panic(fmt.Errorf("Multiple fields with name %#v", name))
+		if field.extra && (f.Type.Kind() != reflect.Map || f.Type.Key().Kind() != reflect.String) {
+			return fmt.Errorf("nbt: Extra field %s of %s is a %s, not a map with string keys", f.Name, t, f.Type)
+		}
+		if field.tag != TagEnd && !canOverrideTag(f.Type, field.tag) {
+			return fmt.Errorf("nbt: Field %s of %s is a %s, which cannot be a %s", f.Name, t, f.Type, field.tag)
 		}
+		*fields = append(*fields, field)
+	}
+	return nil
+}
+
+// Picks the field of struct type t that wins out of those sharing a name, if
+// any does.
+func dominantField(t reflect.Type, candidates []fieldCandidate) (structField, bool, error) {
+	depth := len(candidates[0].index)
+	for _, c := range candidates[1:] {
+		if len(c.index) < depth {
+			depth = len(c.index)
+		}
+	}
+	var shallowest []fieldCandidate
+	for _, c := range candidates {
+		if len(c.index) == depth {
+			shallowest = append(shallowest, c)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0].structField, true, nil
+	}
+
+	if depth == 1 {
+		if shallowest[0].extra {
+			return structField{}, false, fmt.Errorf("nbt: %s has multiple fields with the extra option", t)
+		}
+		return structField{}, false, fmt.Errorf("nbt: %s has multiple fields with name %#v", t, shallowest[0].name)
+	}
+	var tagged []fieldCandidate
+	for _, c := range shallowest {
+		if c.tagged {
+			tagged = append(tagged, c)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0].structField, true, nil
+	}
+	return structField{}, false, nil
+}
 
-		parsed[name] = reflect.Indirect(v.Field(i))
+// Reports whether a field of type t can be given the type option tag. Only
+// numbers can, and integers must stay integers.
+func canOverrideTag(t reflect.Type, tag Tag) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return tag >= TagByte && tag <= TagLong
+	case reflect.Float32, reflect.Float64:
+		return tag == TagFloat || tag == TagDouble
+	}
+	return false
+}
 
-	return parsed
+// Splits an nbt struct tag into the tag name and its options, which it
+// stores in field. NBT names may contain commas, so only trailing options
+// that are known, such as ",omitempty", are split off.
+func parseTag(tag string, field *structField) error {
+	for {
+		i := strings.LastIndex(tag, ",")
+		if i == -1 {
+			break
+		}
+		if option := tag[i+1:]; option == "omitempty" {
+			field.omitEmpty = true
+		} else if option == "extra" {
+			field.extra = true
+		} else if strings.HasPrefix(option, "union=") {
+			field.union = strings.TrimPrefix(option, "union=")
+		} else if strings.HasPrefix(option, "type=") {
+			t, err := parseTypeOption(strings.TrimPrefix(option, "type="))
+			if err != nil {
+				return err
+			}
+			field.tag = t
+		} else {
+			break
+		}
+		tag = tag[:i]
+	}
+	field.name = tag
+	return nil
+}
+
+// The tags a ",type=" option can name, which are the numeric ones.
+var typeOptions = []struct {
+	name string
+	tag  Tag
+}{
+	{"byte", TagByte},
+	{"short", TagShort},
+	{"int", TagInt},
+	{"long", TagLong},
+	{"float", TagFloat},
+	{"double", TagDouble},
+}
+
+// Returns the tag named by the value of a ",type=" option.
+func parseTypeOption(name string) (Tag, error) {
+	names := make([]string, len(typeOptions))
+	for i, option := range typeOptions {
+		if option.name == name {
+			return option.tag, nil
+		}
+		names[i] = option.name
+	}
+	return TagEnd, fmt.Errorf("unknown type option %q, which must be one of %s", name, strings.Join(names, ", "))
+}
+
+// Returns the fields of struct type t by name, leaving out the extra field.
+// It panics if their tags don't make sense.
+func structFieldsByName(t reflect.Type) map[string]structField {
+	return mustStructInfo(t).byName
+}
+
+// Returns the field of struct v at index, or false if it is inside an
+// embedded struct pointer that is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// Returns the field of struct v at index, allocating any nil embedded struct
+// pointers on the way to it.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// Reports whether v is empty for the purposes of omitempty: false, zero
+// numbers, nil pointers and interfaces, and zero-length strings, slices,
+// maps and arrays. Structs are never empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
 }
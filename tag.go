@@ -1,64 +1,133 @@
 package nbt
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
-// All tags are big endian.
+// Unless a different ByteOrder is chosen, all tags are big endian.
 
 type Tag byte
 
 const (
-	tagEnd       Tag = iota // No payload, no name.
-	tagByte                 // Signed 8 bit integer.
-	tagShort                // Signed 16 bit integer.
-	tagInt                  // Signed 32 bit integer.
-	tagLong                 // Signed 64 bit integer.
-	tagFloat                // IEEE 754-2008 32 bit floating point number.
-	tagDouble               // IEEE 754-2008 64 bit floating point number.
-	tagByteArray            // size tagInt, then payload [size]byte.
-	tagString               // length tagShort, then payload (utf-8) string (of length length).
-	tagList                 // tagID tagByte, length tagInt, then payload [length]tagID.
-	tagCompound             // { tagID tagByte, name tagString, payload tagID }... tagEnd
-	tagIntArray             // size tagInt, then payload [size]tagInt
-	tagLongArray
+	TagEnd       Tag = iota // No payload, no name.
+	TagByte                 // Signed 8 bit integer.
+	TagShort                // Signed 16 bit integer.
+	TagInt                  // Signed 32 bit integer.
+	TagLong                 // Signed 64 bit integer.
+	TagFloat                // IEEE 754-2008 32 bit floating point number.
+	TagDouble               // IEEE 754-2008 64 bit floating point number.
+	TagByteArray            // size TagInt, then payload [size]byte.
+	TagString               // length TagShort, then payload (utf-8) string (of length length).
+	TagList                 // tagID TagByte, length TagInt, then payload [length]tagID.
+	TagCompound             // { tagID TagByte, name TagString, payload tagID }... TagEnd
+	TagIntArray             // size TagInt, then payload [size]TagInt
+	TagLongArray            // size TagInt, then payload [size]TagLong
 )
 
+// Returns the name the NBT specification uses for the tag, such as
+// "TAG_Compound".
 func (tag Tag) String() string {
-	name := "Unknown"
+	var name string
 	switch tag {
-	case tagEnd:
+	case TagEnd:
 		name = "TAG_End"
-	case tagByte:
+	case TagByte:
 		name = "TAG_Byte"
-	case tagShort:
+	case TagShort:
 		name = "TAG_Short"
-	case tagInt:
+	case TagInt:
 		name = "TAG_Int"
-	case tagLong:
+	case TagLong:
 		name = "TAG_Long"
-	case tagFloat:
+	case TagFloat:
 		name = "TAG_Float"
-	case tagDouble:
+	case TagDouble:
 		name = "TAG_Double"
-	case tagByteArray:
+	case TagByteArray:
 		name = "TAG_Byte_Array"
-	case tagString:
+	case TagString:
 		name = "TAG_String"
-	case tagList:
+	case TagList:
 		name = "TAG_List"
-	case tagCompound:
+	case TagCompound:
 		name = "TAG_Compound"
-	case tagIntArray:
+	case TagIntArray:
 		name = "TAG_Int_Array"
-	case tagLongArray:
+	case TagLongArray:
 		name = "TAG_Long_Array"
+	default:
+		name = fmt.Sprintf("Unknown(%d)", byte(tag))
 	}
-	return fmt.Sprintf("%s (0x%02x)", name, byte(tag))
+	return name
 }
 
+// The numbers stored in an interface{} by a Decoder told to use TypedScalars,
+// each named for the tag it came from, so that maps of them can be written
+// back out with the same tags.
+type (
+	Byte   int8
+	Short  int16
+	Int    int32
+	Long   int64
+	Float  float32
+	Double float64
+)
+
+// How a stream of NBT is compressed. Other formats can be added with
+// RegisterCompression.
 type Compression byte
 
 const (
 	Uncompressed Compression = iota
 	GZip
 	ZLib
+
+	// Only for reading. Looks at the first bytes of the stream to decide
+	// between Uncompressed, GZip, ZLib, and Zstd.
+	AutoDetect
+
+	// Added after AutoDetect so that the values above stay the same.
+	Zstd
+)
+
+// Returns the name ParseCompression accepts for c, such as "gzip". Unknown
+// values give "unknown" followed by the number, like "unknown(42)".
+func (c Compression) String() string {
+	switch c {
+	case Uncompressed:
+		return "none"
+	case GZip:
+		return "gzip"
+	case ZLib:
+		return "zlib"
+	case Zstd:
+		return "zstd"
+	case AutoDetect:
+		return "auto"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(c))
+}
+
+// Returns the Compression with the given name, as returned by String, in
+// any case. This is meant for command line flags such as --compression=gzip.
+func ParseCompression(name string) (Compression, error) {
+	for c := Uncompressed; c <= Zstd; c++ {
+		if strings.EqualFold(name, c.String()) {
+			return c, nil
+		}
+	}
+	return Uncompressed, fmt.Errorf("nbt: Unknown compression type: %q", name)
+}
+
+type ByteOrder byte
+
+const (
+	BigEndian    ByteOrder = iota // Java Edition.
+	LittleEndian                  // Bedrock Edition files.
+
+	// The Bedrock Edition network protocol. Like LittleEndian, except that
+	// TAG_Int and TAG_Long payloads and the lengths of arrays and lists are
+	// zig-zag VarInts, and string lengths are unsigned VarInts.
+	NetworkLittleEndian
 )
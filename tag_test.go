@@ -0,0 +1,49 @@
+package nbt
+
+import "testing"
+
+func TestTagString(t *testing.T) {
+	for tag, expected := range map[Tag]string{
+		TagEnd:       "TAG_End",
+		TagInt:       "TAG_Int",
+		TagCompound:  "TAG_Compound",
+		TagIntArray:  "TAG_Int_Array",
+		TagLongArray: "TAG_Long_Array",
+		13:           "Unknown(13)",
+		255:          "Unknown(255)",
+	} {
+		assertString(t, "Tag.String()", tag.String(), expected)
+	}
+}
+
+func TestCompressionString(t *testing.T) {
+	for c, expected := range map[Compression]string{
+		Uncompressed: "none",
+		GZip:         "gzip",
+		ZLib:         "zlib",
+		Zstd:         "zstd",
+		AutoDetect:   "auto",
+	} {
+		assertString(t, "Compression.String()", c.String(), expected)
+
+		parsed, err := ParseCompression(expected)
+		if err != nil || parsed != c {
+			t.Errorf("ParseCompression(%q) = %d, %v; expected %d.", expected, parsed, err, c)
+		}
+	}
+	assertString(t, "Compression.String()", Compression(42).String(), "unknown(42)")
+
+	// The values may be stored, so they must not change.
+	for c, expected := range map[Compression]byte{Uncompressed: 0, GZip: 1, ZLib: 2, AutoDetect: 3, Zstd: 4} {
+		if byte(c) != expected {
+			t.Errorf("%v is %d, but expected %d.", c, byte(c), expected)
+		}
+	}
+
+	if c, err := ParseCompression("GZip"); err != nil || c != GZip {
+		t.Errorf("ParseCompression(\"GZip\") = %d, %v; expected %d.", c, err, GZip)
+	}
+	if _, err := ParseCompression("lz4"); err == nil || err.Error() != `nbt: Unknown compression type: "lz4"` {
+		t.Errorf("Expected an error for \"lz4\", but got %v.", err)
+	}
+}
@@ -0,0 +1,27 @@
+package nbt
+
+import (
+	"reflect"
+	"time"
+)
+
+// A time.Time is stored as a TAG_Long holding the number of milliseconds
+// since the Unix epoch, which is what Java's System.currentTimeMillis gives
+// and how Minecraft stores timestamps such as LastPlayed in level.dat. Game
+// ticks, like a world's Time, are not timestamps and belong in an int64.
+var timeType = reflect.TypeOf(time.Time{})
+
+// Reads the payload of a TAG_Long into v, which is a time.Time.
+func (d *decodeState) readTime(v reflect.Value) error {
+	millis, err := d.readLong()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(time.UnixMilli(int64(millis))))
+	return nil
+}
+
+// Writes v, which is a time.Time, as the payload of a TAG_Long.
+func (e *encodeState) writeTime(v reflect.Value) {
+	e.writeValue(TagLong, v.Interface().(time.Time).UnixMilli())
+}
@@ -0,0 +1,162 @@
+package nbt
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	unionTypesMu   sync.RWMutex
+	unionTypes     = make(map[string]reflect.Type)
+	interfaceTypes = make(map[interfaceKey]reflect.Type)
+)
+
+// A discriminator registered for one interface type only.
+type interfaceKey struct {
+	iface reflect.Type
+	id    string
+}
+
+// Registers the type of prototype as the one to use for compounds whose
+// discriminator is id, so that lists of entities or items of many kinds can
+// be read into their own Go types.
+//
+// A struct field opts in with a ",union=key" option naming the compound
+// field that holds the discriminator, which must be a TAG_String:
+//
+//	type Chunk struct {
+//		Entities []Entity `nbt:"Entities,union=id"`
+//	}
+//
+//	nbt.RegisterType("minecraft:zombie", (*Zombie)(nil))
+//
+// Every compound read into an interface in that field, directly or as an
+// element of a slice, array or map, is then read into a new value of the
+// type registered for its discriminator instead. Compounds whose
+// discriminator is missing or not registered are read as
+// map[string]interface{}, which is an error unless the interface can hold
+// one. Nothing is added when writing, so the discriminator should be a field
+// of the registered types too.
+//
+// Registering the same id twice with different types panics.
+func RegisterType(id string, prototype interface{}) {
+	t := reflect.TypeOf(prototype)
+	if t == nil {
+		panic(fmt.Errorf("nbt: Cannot register a nil type for %q", id))
+	}
+
+	unionTypesMu.Lock()
+	defer unionTypesMu.Unlock()
+	if old, ok := unionTypes[id]; ok && old != t {
+		panic(fmt.Errorf("nbt: %q is already registered as %v", id, old))
+	}
+	unionTypes[id] = t
+}
+
+// Like RegisterType, but the type is only used for compounds read into the
+// interface iface points to, such as (*BlockEntity)(nil), and it takes
+// precedence there over a type registered with RegisterType. This lets
+// plugins register kinds of entity, block entity and so on separately, even
+// when the same id means different things in each. The field still needs a
+// ",union=key" option.
+//
+// The type of prototype must implement the interface. Registering the same
+// id twice for an interface with different types panics.
+func RegisterInterfaceType(iface interface{}, id string, prototype interface{}) {
+	p := reflect.TypeOf(iface)
+	if p == nil || p.Kind() != reflect.Ptr || p.Elem().Kind() != reflect.Interface {
+		panic(fmt.Errorf("nbt: RegisterInterfaceType needs a pointer to an interface, not %v", p))
+	}
+	t := reflect.TypeOf(prototype)
+	if t == nil || !t.Implements(p.Elem()) {
+		panic(fmt.Errorf("nbt: Cannot register %v for %q, as it does not implement %v", t, id, p.Elem()))
+	}
+
+	key := interfaceKey{p.Elem(), id}
+	unionTypesMu.Lock()
+	defer unionTypesMu.Unlock()
+	if old, ok := interfaceTypes[key]; ok && old != t {
+		panic(fmt.Errorf("nbt: %q is already registered for %v as %v", id, p.Elem(), old))
+	}
+	interfaceTypes[key] = t
+}
+
+// Reads a TAG_Compound into v, an interface in a field with a union option.
+// The discriminator can be anywhere in the compound, so the compound is read
+// in full before it is decoded.
+func (d *decodeState) readUnion(v reflect.Value) error {
+	raw, err := d.readRaw(TagCompound)
+	if err != nil {
+		return err
+	}
+
+	sub := d.sub(raw)
+	id, err := sub.findString(d.union)
+	d.ticks = sub.ticks
+	if err != nil {
+		return sub.offsetError(err)
+	}
+
+	unionTypesMu.RLock()
+	t, ok := interfaceTypes[interfaceKey{v.Type(), id}]
+	if !ok {
+		t, ok = unionTypes[id]
+	}
+	unionTypesMu.RUnlock()
+
+	var value reflect.Value
+	if ok {
+		value = reflect.New(t).Elem()
+	} else {
+		value, _ = d.allocate(TagCompound)
+	}
+	if !value.Type().AssignableTo(v.Type()) {
+		if !ok {
+			return fmt.Errorf("nbt: No type is registered for %s %q, and a %s cannot hold a %s", d.union, id, v.Type(), value.Type())
+		}
+		return fmt.Errorf("nbt: %s is registered for %s %q, but a %s cannot hold one", t, d.union, id, v.Type())
+	}
+
+	sub = d.sub(raw)
+	sub.union = ""
+	err = sub.readValue(TagCompound, value)
+	d.ticks = sub.ticks
+	if err != nil {
+		return sub.offsetError(err)
+	}
+	v.Set(value)
+	return nil
+}
+
+// Returns a decodeState with the same options as d that reads from data,
+// which d has just read. Offsets in its errors are still from the start of
+// the input.
+func (d *decodeState) sub(data []byte) *decodeState {
+	sub := *d
+	sub.in, sub.count = nil, nil
+	sub.fromBytes, sub.data, sub.pos = true, data, 0
+	sub.base = d.offset() - int64(len(data))
+	sub.root = -1
+	return &sub
+}
+
+// Reads the payload of a TAG_Compound and returns its TAG_String with the
+// given name, or "" if it has none.
+func (d *decodeState) findString(name string) (string, error) {
+	var found string
+	for {
+		field, tag, err := d.readTag()
+		if err != nil || tag == TagEnd {
+			return found, err
+		}
+		if tag == TagString && field == name {
+			found, err = d.readString()
+		} else {
+			err = d.skipValue(tag)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package nbt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+)
+
+// A UUID as Minecraft stores it since 1.16: a TAG_Int_Array of four ints,
+// most significant first. A UUID can also be read from a TAG_Byte_Array of
+// sixteen bytes, like any other [16]byte.
+type UUID [16]byte
+
+var uuidType = reflect.TypeOf(UUID{})
+
+// Returns the UUID made of the two halves older versions of Minecraft store
+// in pairs of TAG_Longs, such as UUIDMost and UUIDLeast.
+func UUIDFromLongs(most, least int64) UUID {
+	var u UUID
+	binary.BigEndian.PutUint64(u[:8], uint64(most))
+	binary.BigEndian.PutUint64(u[8:], uint64(least))
+	return u
+}
+
+// Returns u in the usual xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx form.
+func (u UUID) String() string {
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// Reads the payload of a TAG_Int_Array into v, which is a UUID.
+func (d *decodeState) readUUID(v reflect.Value) error {
+	length, err := d.readLength(TagIntArray)
+	if err != nil {
+		return err
+	}
+	if length != 4 {
+		return fmt.Errorf("nbt: UUID needs a TAG_Int_Array of length 4, not %d", length)
+	}
+	var u UUID
+	for i := 0; i < 4; i++ {
+		value, err := d.readInt()
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint32(u[i*4:], value)
+	}
+	v.Set(reflect.ValueOf(u))
+	return nil
+}
+
+// Writes v, which is a UUID, as the payload of a TAG_Int_Array.
+func (e *encodeState) writeUUID(v reflect.Value) {
+	u := v.Interface().(UUID)
+	e.w(uint32(4))
+	for i := 0; i < 4; i++ {
+		e.writeValue(TagInt, binary.BigEndian.Uint32(u[i*4:]))
+	}
+}
@@ -0,0 +1,100 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// A Visitor is told about each tag Walk reads, in the order they appear in
+// the input.
+//
+// The path of a tag holds the names of the compounds it is in, with list
+// elements named by their index in decimal, and ends with the tag's own
+// name; the root tag's path is empty. The path slice is reused once the
+// method returns, so it must be copied to be kept.
+type Visitor interface {
+	// Called for every tag that is not a TAG_List or TAG_Compound, with the
+	// value Unmarshal would store in an interface{}.
+	OnScalar(path []string, tag Tag, value interface{})
+
+	// Called for a TAG_Compound, before any of the tags in it.
+	OnEnterCompound(path []string)
+
+	// Called for a TAG_List, before any of its elements.
+	OnList(path []string, elem Tag, length int)
+}
+
+// Reads an NBT root tag from in, calling the methods of visitor for each
+// tag in it. Unlike Parse, nothing is kept once visitor has been called, so
+// documents of any size can be looked through.
+func Walk(compression Compression, in io.Reader, visitor Visitor) error {
+	dec := NewDecoder(compression, in)
+	if dec.err != nil {
+		return dec.err
+	}
+	d := &dec.state
+
+	d.root = d.offset()
+	_, tag, err := d.readTag()
+	if err == nil {
+		err = d.walk(tag, nil, visitor)
+	}
+	return d.offsetError(err)
+}
+
+func (d *decodeState) walk(tag Tag, path []string, visitor Visitor) error {
+	switch tag {
+	case TagList:
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+
+		elem, length, err := d.readListHeader()
+		if err != nil {
+			return err
+		}
+		visitor.OnList(path, elem, int(length))
+		for i := 0; i < int(length); i++ {
+			if err := d.tick(1); err != nil {
+				return err
+			}
+			if err := d.walk(elem, append(path, strconv.Itoa(i)), visitor); err != nil {
+				return fmt.Errorf("%w\n\t\tat list index %d", err, i)
+			}
+		}
+
+	case TagCompound:
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+
+		visitor.OnEnterCompound(path)
+		for {
+			name, tag, err := d.readTag()
+			if err != nil {
+				return err
+			}
+			if tag == TagEnd {
+				break
+			}
+			if err := d.walk(tag, append(path, name), visitor); err != nil {
+				return fmt.Errorf("%w\n\t\tat struct field %#v", err, name)
+			}
+		}
+
+	default:
+		value, err := d.allocate(tag)
+		if err != nil {
+			return err
+		}
+		if err := d.readValue(tag, value); err != nil {
+			return err
+		}
+		visitor.OnScalar(path, tag, value.Interface())
+	}
+
+	return nil
+}
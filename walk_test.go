@@ -0,0 +1,81 @@
+package nbt
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Records each call as a line.
+type recordingVisitor []string
+
+func (r *recordingVisitor) OnScalar(path []string, tag Tag, value interface{}) {
+	*r = append(*r, fmt.Sprintf("%s %s %v", strings.Join(path, "/"), tag, value))
+}
+
+func (r *recordingVisitor) OnEnterCompound(path []string) {
+	*r = append(*r, fmt.Sprintf("%s {", strings.Join(path, "/")))
+}
+
+func (r *recordingVisitor) OnList(path []string, elem Tag, length int) {
+	*r = append(*r, fmt.Sprintf("%s [%d]%s", strings.Join(path, "/"), length, elem))
+}
+
+func TestWalk(t *testing.T) {
+	type Item struct {
+		ID    string `nbt:"id"`
+		Count int8
+	}
+	data, err := MarshalBytes(GZip, "root", struct {
+		Name  string
+		Items []Item
+		Pos   []float64
+		Tags  struct{ Flags []byte }
+		Empty []int32
+	}{
+		Name:  "walker",
+		Items: []Item{{"stone", 3}, {"dirt", 64}},
+		Pos:   []float64{0.5, -2},
+		Tags:  struct{ Flags []byte }{[]byte{1, 2}},
+		Empty: []int32{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var visited recordingVisitor
+	if err := Walk(GZip, bytes.NewReader(data), &visited); err != nil {
+		t.Fatal(err)
+	}
+	expected := recordingVisitor{
+		" {",
+		"Name TAG_String walker",
+		"Items [2]TAG_Compound",
+		"Items/0 {",
+		"Items/0/id TAG_String stone",
+		"Items/0/Count TAG_Byte 3",
+		"Items/1 {",
+		"Items/1/id TAG_String dirt",
+		"Items/1/Count TAG_Byte 64",
+		"Pos [2]TAG_Double",
+		"Pos/0 TAG_Double 0.5",
+		"Pos/1 TAG_Double -2",
+		"Tags {",
+		"Tags/Flags TAG_Byte_Array [1 2]",
+		"Empty [0]TAG_Int",
+	}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Visited:\n%s\nbut expected:\n%s", strings.Join(visited, "\n"), strings.Join(expected, "\n"))
+	}
+
+	truncated := []byte{byte(TagCompound), 0, 0, byte(TagList), 0, 1, 'l', byte(TagInt), 0, 0, 0, 2, 0, 0, 0, 1}
+	visited = nil
+	if err := Walk(Uncompressed, bytes.NewReader(truncated), &visited); err == nil {
+		t.Error("No error for a truncated list, but one was expected!")
+	}
+	if len(visited) != 3 {
+		t.Errorf("Visited %q before the end of the input.", visited)
+	}
+}